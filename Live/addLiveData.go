@@ -3,68 +3,85 @@ package Live
 import (
 	"encoding/json"
 	"fmt"
+	"gosse/audit"
+	"gosse/dates"
 	"gosse/twoddata"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 )
 
-// AddLiveDataHandler handles POST /addLiveData and stores the data in memory
-func AddLiveDataHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		fmt.Println("Method not allowed", r.Method)
-		return
-	}
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusBadRequest)
-		return
-	}
-	var data Live
-	if err := json.Unmarshal(body, &data); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
-	}
-	liveDataMu.Lock()
-	liveDataStore = []Live{data}
-	jdata, err := json.Marshal(liveDataStore)
-	if err != nil {
-		return
-	}
-	os.WriteFile("live.json", jdata, 0644)
-	liveDataMu.Unlock()
+// FinalNotifier is told about every final 2D result archived from live data
+type FinalNotifier func(d twoddata.TwodData)
 
-	// --- DB insert logic ---
-	// 1. If todaydate data not in DB
-	// 2. If time > 16:30
-	// 3. If post data.Live does not contain "-"
-	now := time.Now()
-	if now.Hour() > 16 || (now.Hour() == 16 && now.Minute() >= 30) {
-		if !strings.Contains(data.Live, "-") {
-			// Open DB (assume twoddata.db in root, or adjust as needed)
-			db := twoddata.InitDB("twoddata.db")
-			defer db.Close()
-			// Check if today's data exists
-			var count int
-			dateStr := data.Date
-			err := db.QueryRow("SELECT COUNT(*) FROM twoddata WHERE date = ?", dateStr).Scan(&count)
-			if err == nil && count == 0 {
-				if data.Eresult == "--" {
-					return
-				}
-				// Insert new row
-				_, err := db.Exec(`INSERT INTO twoddata (mset, mvalue, mresult, eset, evalue, eresult, tmodern, tinernet, nmodern, ninternet, date) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-					data.Mset, data.Mvalue, data.Mresult, data.Eset, data.Evalue, data.Eresult, data.Tmodern, data.Tinternet, data.Nmodern, data.Ninternet, dateStr)
+// AddLiveDataHandler handles POST /addLiveData, stores the data in memory and archives the final result
+func AddLiveDataHandler(repo twoddata.Repository, notify FinalNotifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			fmt.Println("Method not allowed", r.Method)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read body", http.StatusBadRequest)
+			return
+		}
+		var data Live
+		if err := json.Unmarshal(body, &data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		audit.SetTarget(r, data.Date)
+		liveDataMu.Lock()
+		liveDataStore = []Live{data}
+		jdata, err := json.Marshal(liveDataStore)
+		if err != nil {
+			return
+		}
+		os.WriteFile("live.json", jdata, 0644)
+		liveDataMu.Unlock()
+
+		// --- DB insert logic ---
+		// 1. If todaydate data not in DB
+		// 2. If time > 16:30
+		// 3. If post data.Live does not contain "-"
+		now := time.Now()
+		if now.Hour() > 16 || (now.Hour() == 16 && now.Minute() >= 30) {
+			if !strings.Contains(data.Live, "-") {
+				// Check if today's data exists
+				dateStr, err := dates.Normalize(data.Date)
 				if err != nil {
-					// Log or handle error as needed
+					// Live data is always today's; fall back to the server date
+					log.Printf("unreadable live date %q, archiving under today", data.Date)
+					dateStr = dates.Today()
+				}
+				count, err := repo.CountByDate(dateStr)
+				if err == nil && count == 0 {
+					if data.Eresult == "--" {
+						return
+					}
+					// Insert new row
+					final := twoddata.TwodData{
+						MSet: data.Mset, MValue: data.Mvalue, MResult: data.Mresult,
+						ESet: data.Eset, EValue: data.Evalue, EResult: data.Eresult,
+						TModern: data.Tmodern, TInernet: data.Tinternet,
+						NModern: data.Nmodern, NInernet: data.Ninternet,
+						Date: dateStr,
+					}
+					if err := repo.Insert(final); err != nil {
+						log.Printf("failed to archive 2D result for %s: %v", dateStr, err)
+					} else if notify != nil {
+						notify(final)
+					}
 				}
 			}
 		}
-	}
 
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte("ok"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("ok"))
+	}
 }
@@ -0,0 +1,212 @@
+package Live
+
+import (
+	"encoding/json"
+	"errors"
+	"gosse/admin"
+	"gosse/audit"
+	"gosse/dates"
+	"gosse/twoddata"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CorrectionNotifier is called after a correction has been stored
+type CorrectionNotifier func(c twoddata.Correction)
+
+// correctionRequest is the body accepted by the correction endpoints
+type correctionRequest struct {
+	Reason string          `json:"reason"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// TwodEditHandler handles POST /admin/twod/edit?id=... to change the values of an archived result.
+// Only the fields present in "data" are changed.
+func TwodEditHandler(repo twoddata.CorrectionRepository, notify CorrectionNotifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, req, ok := parseCorrection(w, r, true)
+		if !ok {
+			return
+		}
+		current, _, err := repo.Get(id)
+		if err != nil {
+			writeCorrectionError(w, err)
+			return
+		}
+		if len(req.Data) == 0 {
+			http.Error(w, "Missing data", http.StatusBadRequest)
+			return
+		}
+		// Unmarshal over the current values so omitted fields keep them
+		if err := json.Unmarshal(req.Data, &current); err != nil {
+			http.Error(w, "Invalid data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if current.Date, err = dates.Normalize(current.Date); err != nil {
+			http.Error(w, "Invalid date", http.StatusBadRequest)
+			return
+		}
+		c, err := repo.Edit(id, current, admin.Actor(r), req.Reason)
+		if err != nil {
+			writeCorrectionError(w, err)
+			return
+		}
+		writeCorrection(w, r, c, notify)
+	}
+}
+
+// TwodVoidHandler handles POST /admin/twod/void?id=... to hide a wrong result from the history
+func TwodVoidHandler(repo twoddata.CorrectionRepository, notify CorrectionNotifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, req, ok := parseCorrection(w, r, true)
+		if !ok {
+			return
+		}
+		c, err := repo.Void(id, admin.Actor(r), req.Reason)
+		if err != nil {
+			writeCorrectionError(w, err)
+			return
+		}
+		writeCorrection(w, r, c, notify)
+	}
+}
+
+// TwodReinsertHandler handles POST /admin/twod/reinsert to archive a result for a date without one
+func TwodReinsertHandler(repo twoddata.CorrectionRepository, notify CorrectionNotifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, req, ok := parseCorrection(w, r, false)
+		if !ok {
+			return
+		}
+		var d twoddata.TwodData
+		if err := json.Unmarshal(req.Data, &d); err != nil {
+			http.Error(w, "Invalid data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		date, err := dates.Normalize(d.Date)
+		if err != nil {
+			http.Error(w, "Missing or invalid date", http.StatusBadRequest)
+			return
+		}
+		d.Date = date
+		c, err := repo.Reinsert(d, admin.Actor(r), req.Reason)
+		if err != nil {
+			writeCorrectionError(w, err)
+			return
+		}
+		writeCorrection(w, r, c, notify)
+	}
+}
+
+// TwodCorrectionsHandler handles GET /admin/twod/corrections?id=... to list the audit trail
+func TwodCorrectionsHandler(repo twoddata.CorrectionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rowID int
+		if v := r.URL.Query().Get("id"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "Invalid id parameter", http.StatusBadRequest)
+				return
+			}
+			rowID = n
+		}
+		all, err := repo.Corrections(rowID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(all)
+	}
+}
+
+// BroadcastCorrection returns a notifier that pushes corrections to SSE and WebSocket
+// subscribers as a "correction" event and patches the live data if it shows the corrected date.
+func BroadcastCorrection(sse *Broker, ws *WebSocketBroker) CorrectionNotifier {
+	return func(c twoddata.Correction) {
+		if c.After != nil {
+			applyCorrectionToLive(*c.After)
+		}
+		if sse != nil {
+			if err := sse.PublishEvent("correction", c); err != nil {
+				log.Printf("failed to publish correction %d: %v", c.ID, err)
+			}
+		}
+		if ws != nil {
+			if err := ws.PublishEvent("correction", c); err != nil {
+				log.Printf("failed to publish correction %d: %v", c.ID, err)
+			}
+		}
+	}
+}
+
+// applyCorrectionToLive updates the in-memory live data when it holds the corrected date
+func applyCorrectionToLive(d twoddata.TwodData) {
+	liveDataMu.Lock()
+	defer liveDataMu.Unlock()
+	for i := range liveDataStore {
+		l := &liveDataStore[i]
+		if date, err := dates.Normalize(l.Date); err != nil || date != d.Date {
+			continue
+		}
+		l.Mset, l.Mvalue, l.Mresult = d.MSet, d.MValue, d.MResult
+		l.Eset, l.Evalue, l.Eresult = d.ESet, d.EValue, d.EResult
+		l.Tmodern, l.Tinternet = d.TModern, d.TInernet
+		l.Nmodern, l.Ninternet = d.NModern, d.NInernet
+	}
+}
+
+// parseCorrection checks the method, decodes the body and, when needID is set, the id parameter
+func parseCorrection(w http.ResponseWriter, r *http.Request, needID bool) (int, correctionRequest, bool) {
+	var req correctionRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return 0, req, false
+	}
+	var id int
+	if needID {
+		n, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil || n <= 0 {
+			http.Error(w, "Missing or invalid id parameter", http.StatusBadRequest)
+			return 0, req, false
+		}
+		id = n
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return 0, req, false
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "Missing reason", http.StatusBadRequest)
+		return 0, req, false
+	}
+	return id, req, true
+}
+
+func writeCorrectionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, twoddata.ErrInvalidResult):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, twoddata.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, twoddata.ErrVoided), errors.Is(err, twoddata.ErrDateTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeCorrection(w http.ResponseWriter, r *http.Request, c twoddata.Correction, notify CorrectionNotifier) {
+	audit.SetTarget(r, strconv.Itoa(c.RowID))
+	if notify != nil {
+		notify(c)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "corrected",
+		"correction": c,
+	})
+}
@@ -0,0 +1,106 @@
+package Live_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gosse/Live"
+	"gosse/admin"
+	"gosse/storage"
+	"gosse/twoddata"
+)
+
+func TestTwodCorrections(t *testing.T) {
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := twoddata.NewSQLiteRepository(db)
+	auth := admin.NewAuthenticator("aung:secret")
+
+	var notified []twoddata.Correction
+	notify := func(c twoddata.Correction) { notified = append(notified, c) }
+
+	do := func(h http.HandlerFunc, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		auth.Require(h)(rec, req)
+		return rec
+	}
+
+	// Edit only the evening result of the first row
+	rec := do(Live.TwodEditHandler(repo, notify), "/admin/twod/edit?id=1", `{"reason":"scraper misread","data":{"EResult":"21"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("edit status = %d: %s", rec.Code, rec.Body)
+	}
+	d, _, err := repo.Get(1)
+	if err != nil || d.EResult != "21" || d.MResult != "25" {
+		t.Fatalf("after edit got %+v, %v", d, err)
+	}
+
+	// Results must be two digits and an edit may not move a row onto a taken date
+	if rec := do(Live.TwodEditHandler(repo, notify), "/admin/twod/edit?id=1", `{"reason":"typo","data":{"MResult":"2"}}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("edit with one-digit result status = %d, want 400", rec.Code)
+	}
+	if rec := do(Live.TwodEditHandler(repo, notify), "/admin/twod/edit?id=1", `{"reason":"wrong day","data":{"Date":"2025-08-18"}}`); rec.Code != http.StatusConflict {
+		t.Fatalf("edit onto a taken date status = %d, want 409", rec.Code)
+	}
+
+	// Void the second row; it must disappear from the public history
+	if rec := do(Live.TwodVoidHandler(repo, notify), "/admin/twod/void?id=2", `{"reason":"duplicate"}`); rec.Code != http.StatusOK {
+		t.Fatalf("void status = %d: %s", rec.Code, rec.Body)
+	}
+	all, _ := repo.All()
+	if len(all) != 1 {
+		t.Fatalf("history has %d rows after void, want 1", len(all))
+	}
+	if rec := do(Live.TwodVoidHandler(repo, notify), "/admin/twod/void?id=2", `{"reason":"again"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("second void status = %d, want 409", rec.Code)
+	}
+
+	// Re-insert the voided date, but not a date that still has a row
+	if rec := do(Live.TwodReinsertHandler(repo, notify), "/admin/twod/reinsert", `{"reason":"fixed","data":{"Date":"2025-08-18","MResult":"02","EResult":"37"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("reinsert status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(Live.TwodReinsertHandler(repo, notify), "/admin/twod/reinsert", `{"reason":"dup","data":{"Date":"2025-08-15","MResult":"11","EResult":"22"}}`); rec.Code != http.StatusConflict {
+		t.Fatalf("reinsert over live row status = %d, want 409", rec.Code)
+	}
+
+	if rec := do(Live.TwodVoidHandler(repo, notify), "/admin/twod/void?id=1", `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("void without reason status = %d, want 400", rec.Code)
+	}
+
+	trail, err := repo.Corrections(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trail) != 3 || len(notified) != 3 {
+		t.Fatalf("got %d corrections and %d notifications, want 3", len(trail), len(notified))
+	}
+	edit := trail[2]
+	if edit.Actor != "aung" || edit.Before.EResult != "20" || edit.After.EResult != "21" {
+		t.Fatalf("unexpected edit record: %+v", edit)
+	}
+}
+
+func TestTwodCorrectionsRequireAdmin(t *testing.T) {
+	auth := admin.NewAuthenticator("aung:secret")
+	h := auth.Require(func(w http.ResponseWriter, r *http.Request) { t.Fatal("handler must not run") })
+	req := httptest.NewRequest(http.MethodPost, "/admin/twod/void?id=1", strings.NewReader(`{"reason":"x"}`))
+	req.Header.Set("X-Admin-Token", "wrong")
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+	var resp map[string]any
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp["status"] != "unauthorized" {
+		t.Fatalf("unexpected body %v", resp)
+	}
+}
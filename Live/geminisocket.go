@@ -261,6 +261,23 @@ func (b *WebSocketBroker) StartBroadcastingTimeAndClients() {
 	}
 }
 
+// PublishEvent broadcasts v to every WebSocket client as {"type": event, "data": v}.
+func (b *WebSocketBroker) PublishEvent(event string, v any) error {
+	jsonMessage, err := json.Marshal(struct {
+		Type string `json:"type"`
+		Data any    `json:"data"`
+	}{Type: event, Data: v})
+	if err != nil {
+		return err
+	}
+	select {
+	case b.broadcast <- jsonMessage:
+	default:
+		log.Printf("Broadcast channel is full, dropping %s event.", event)
+	}
+	return nil
+}
+
 // Accessors for external use (e.g., from main.go if needed)
 func (b *WebSocketBroker) GetTotalClients() int64 {
 	return b.totalClients.Load()
@@ -9,9 +9,15 @@ import (
 	"time"
 )
 
+// sseEvent is a single Server-Sent Events frame.
+type sseEvent struct {
+	Event string // Optional event name; empty means the default "message" event
+	Data  string // JSON payload
+}
+
 // Client represents a single SSE client connection.
 type Client struct {
-	MessageChannel chan string   // Channel to send messages to this specific client
+	MessageChannel chan sseEvent // Channel to send messages to this specific client
 	Done           chan struct{} // Signal channel for client disconnection
 }
 
@@ -20,9 +26,10 @@ type Broker struct {
 	clients       map[*Client]bool // Registered clients
 	newClients    chan *Client     // Channel for new client connections
 	closedClients chan *Client     // Channel for disconnected clients
-	broadcaster   chan string      // Channel to receive messages for broadcasting
+	broadcaster   chan sseEvent    // Channel to receive messages for broadcasting
 	totalClients  int64            // Atomic counter for total active clients
 	mu            sync.RWMutex     // Mutex to protect client map
+	snapshot      func() any       // Optional first message for new clients; nil sends the 2D live data
 }
 
 // NewBroker creates and initializes a new Broker.
@@ -31,11 +38,19 @@ func NewBroker() *Broker {
 		clients:       make(map[*Client]bool),
 		newClients:    make(chan *Client),
 		closedClients: make(chan *Client),
-		broadcaster:   make(chan string, 100), // Buffered channel for messages
+		broadcaster:   make(chan sseEvent, 100), // Buffered channel for messages
 		totalClients:  0,
 	}
 }
 
+// NewSnapshotBroker creates a Broker whose clients receive snapshot() as their first
+// message instead of the 2D live data. It backs channels other than /live.
+func NewSnapshotBroker(snapshot func() any) *Broker {
+	b := NewBroker()
+	b.snapshot = snapshot
+	return b
+}
+
 // Start begins the Broker's main loop for managing clients and broadcasting messages.
 func (b *Broker) Start() {
 	go func() {
@@ -98,7 +113,7 @@ func (b *Broker) SSEHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Create a new client
 	client := &Client{
-		MessageChannel: make(chan string),
+		MessageChannel: make(chan sseEvent, 16),
 		Done:           make(chan struct{}),
 	}
 
@@ -113,7 +128,12 @@ func (b *Broker) SSEHandler(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	// Send the latest live data immediately on connect
-	data, _ := json.Marshal(liveDataStore)
+	var data []byte
+	if b.snapshot != nil {
+		data, _ = json.Marshal(b.snapshot())
+	} else {
+		data, _ = json.Marshal(liveDataStore)
+	}
 	fmt.Fprintf(w, "data: %s\n\n", string(data))
 	flusher.Flush()
 
@@ -123,7 +143,10 @@ func (b *Broker) SSEHandler(w http.ResponseWriter, r *http.Request) {
 	for {
 		select {
 		case msg := <-client.MessageChannel:
-			fmt.Fprintf(w, "data: %s\n\n", msg)
+			if msg.Event != "" {
+				fmt.Fprintf(w, "event: %s\n", msg.Event)
+			}
+			fmt.Fprintf(w, "data: %s\n\n", msg.Data)
 			flusher.Flush()
 		case <-pingTicker.C:
 			fmt.Fprintf(w, ": ping\n\n")
@@ -148,7 +171,7 @@ func (b *Broker) StartBroadcastingTime() {
 			data, _ := json.Marshal(liveDataStore)
 			currentLive := string(data)
 			if currentLive != previousLive {
-				b.broadcaster <- currentLive
+				b.broadcaster <- sseEvent{Data: currentLive}
 				previousLive = currentLive
 			}
 		case <-time.After(1 * time.Minute):
@@ -158,3 +181,19 @@ func (b *Broker) StartBroadcastingTime() {
 		}
 	}
 }
+
+// PublishEvent broadcasts v as JSON to every SSE client under the named event.
+// Like WebSocketBroker.PublishEvent it never blocks; the event is dropped when
+// the broadcast queue is full.
+func (b *Broker) PublishEvent(event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	select {
+	case b.broadcaster <- sseEvent{Event: event, Data: string(data)}:
+	default:
+		log.Printf("Broadcast channel is full, dropping %s event.", event)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package Live
+
+import (
+	"errors"
+	"gosse/dates"
+	"gosse/threedata"
+	"log"
+	"sync"
+	"time"
+)
+
+// 3D draw states pushed on the live channel
+const (
+	ThreedCountdown = "countdown" // the next draw has not started yet
+	ThreedDrawing   = "drawing"   // draw day, past the draw time, no result stored yet
+	ThreedResult    = "result"    // the result of today's draw is known
+)
+
+// ThreedStatus is the message sent to 3D live subscribers
+type ThreedStatus struct {
+	State       string `json:"state"`
+	DrawDate    string `json:"draw_date"`
+	DrawTime    string `json:"draw_time"`
+	SecondsLeft int64  `json:"seconds_left"`
+	Result      string `json:"result,omitempty"`
+	Time        string `json:"time"`
+}
+
+// ThreedLive drives the 3D draw-day channel. Draws happen on the 1st and 16th of
+// each month; subscribers get a countdown, a "drawing" state once the draw time
+// has passed and the result the moment it is stored.
+type ThreedLive struct {
+	// DrawHour and DrawMinute give the local (Asia/Yangon) time the draw starts
+	DrawHour   int
+	DrawMinute int
+	// ResultTTL is how long a looked-up result, or its absence, is reused
+	// before the repository is asked again; PublishResult refreshes it at once
+	ResultTTL time.Duration
+
+	repo threedata.Repository
+	sse  *Broker
+	ws   *WebSocketBroker
+
+	mu       sync.Mutex
+	last     ThreedStatus
+	lastSent time.Time
+
+	cacheMu sync.Mutex
+	cached  cachedResult
+}
+
+// cachedResult is the outcome of the latest result lookup
+type cachedResult struct {
+	date     string
+	d        threedata.ThreedData
+	ok       bool
+	loadedAt time.Time
+}
+
+// NewThreedLive creates the 3D channel with its own SSE broker. ws may be nil.
+// The draw time defaults to 14:00 Yangon time (14:30 in Bangkok) and results
+// are looked up at most every 30 seconds.
+func NewThreedLive(repo threedata.Repository, ws *WebSocketBroker) *ThreedLive {
+	t := &ThreedLive{DrawHour: 14, DrawMinute: 0, ResultTTL: 30 * time.Second, repo: repo, ws: ws}
+	t.sse = NewSnapshotBroker(func() any { return t.StatusAt(time.Now()) })
+	return t
+}
+
+// Broker returns the SSE broker serving the 3D channel
+func (t *ThreedLive) Broker() *Broker {
+	return t.sse
+}
+
+// NextDrawDate returns the day of the current or next draw for now
+func NextDrawDate(now time.Time) time.Time {
+	y, m, d := now.Date()
+	switch {
+	case d == 1 || d == 16:
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	case d < 16:
+		return time.Date(y, m, 16, 0, 0, 0, 0, now.Location())
+	default:
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, now.Location())
+	}
+}
+
+// StatusAt computes the channel state for the given instant
+func (t *ThreedLive) StatusAt(now time.Time) ThreedStatus {
+	now = now.In(dates.Location())
+	day := NextDrawDate(now)
+	drawAt := day.Add(time.Duration(t.DrawHour)*time.Hour + time.Duration(t.DrawMinute)*time.Minute)
+	st := ThreedStatus{
+		State:    ThreedCountdown,
+		DrawDate: day.Format(threedata.DateLayout),
+		DrawTime: drawAt.Format(time.RFC3339),
+		Time:     now.Format(time.RFC3339),
+	}
+	if now.Before(drawAt) {
+		st.SecondsLeft = int64(drawAt.Sub(now).Seconds())
+		// An early manual entry still counts as the result for today
+		if sameDay(day, now) {
+			if d, ok := t.lookup(st.DrawDate); ok {
+				st.State, st.Result, st.SecondsLeft = ThreedResult, d.Result, 0
+			}
+		}
+		return st
+	}
+	st.State = ThreedDrawing
+	if d, ok := t.lookup(st.DrawDate); ok {
+		st.State, st.Result = ThreedResult, d.Result
+	}
+	return st
+}
+
+// lookup returns the result of date, from the cache while it is fresh, so the
+// per-second broadcast does not query the repository every tick
+func (t *ThreedLive) lookup(date string) (threedata.ThreedData, bool) {
+	t.cacheMu.Lock()
+	defer t.cacheMu.Unlock()
+	if c := t.cached; c.date == date && time.Since(c.loadedAt) < t.ResultTTL {
+		return c.d, c.ok
+	}
+	d, err := t.repo.Get(date)
+	if err != nil {
+		if !errors.Is(err, threedata.ErrNotFound) {
+			log.Printf("3D live: failed to load result for %s: %v", date, err)
+			return threedata.ThreedData{}, false
+		}
+		t.cached = cachedResult{date: date, loadedAt: time.Now()}
+		return threedata.ThreedData{}, false
+	}
+	t.cached = cachedResult{date: date, d: d, ok: true, loadedAt: time.Now()}
+	return d, true
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// PublishResult pushes a freshly stored result to every subscriber right away.
+// It is meant to be passed to threedata.ThreedCreateHandler as its notifier.
+func (t *ThreedLive) PublishResult(d threedata.ThreedData) {
+	t.cacheMu.Lock()
+	t.cached = cachedResult{date: d.Date, d: d, ok: true, loadedAt: time.Now()}
+	t.cacheMu.Unlock()
+	st := t.StatusAt(time.Now())
+	if st.DrawDate != d.Date {
+		// A back-filled draw: announce it without changing today's state
+		t.send(ThreedStatus{State: ThreedResult, DrawDate: d.Date, Result: d.Result, Time: time.Now().Format(time.RFC3339)})
+		return
+	}
+	t.publish(st, true)
+}
+
+// StartBroadcasting pushes the 3D state every second during the last ten minutes
+// before the draw and while drawing, once a minute otherwise, and immediately
+// whenever the state changes.
+func (t *ThreedLive) StartBroadcasting() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		st := t.StatusAt(now)
+		urgent := st.State == ThreedDrawing || (st.State == ThreedCountdown && st.SecondsLeft <= 600)
+		t.publish(st, urgent)
+	}
+}
+
+func (t *ThreedLive) publish(st ThreedStatus, force bool) {
+	t.mu.Lock()
+	changed := st.State != t.last.State || st.Result != t.last.Result || st.DrawDate != t.last.DrawDate
+	if !force && !changed && time.Since(t.lastSent) < time.Minute {
+		t.mu.Unlock()
+		return
+	}
+	t.last, t.lastSent = st, time.Now()
+	t.mu.Unlock()
+	t.send(st)
+}
+
+func (t *ThreedLive) send(st ThreedStatus) {
+	if err := t.sse.PublishEvent("", st); err != nil {
+		log.Printf("3D live: failed to publish: %v", err)
+	}
+	if t.ws != nil {
+		t.ws.PublishEvent("threed", st)
+	}
+}
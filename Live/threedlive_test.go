@@ -0,0 +1,76 @@
+package Live_test
+
+import (
+	"testing"
+	"time"
+
+	"gosse/Live"
+	"gosse/storage"
+	"gosse/threedata"
+)
+
+func TestNextDrawDate(t *testing.T) {
+	loc := time.FixedZone("MMT", 390*60)
+	cases := map[string]string{
+		"2025-08-01": "2025-08-01",
+		"2025-08-02": "2025-08-16",
+		"2025-08-16": "2025-08-16",
+		"2025-08-17": "2025-09-01",
+		"2025-12-31": "2026-01-01",
+	}
+	for in, want := range cases {
+		now, _ := time.ParseInLocation("2006-01-02", in, loc)
+		if got := Live.NextDrawDate(now.Add(20 * time.Hour)).Format(threedata.DateLayout); got != want {
+			t.Errorf("NextDrawDate(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestThreedLiveStates(t *testing.T) {
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := threedata.NewSQLiteRepository(db)
+	live := Live.NewThreedLive(repo, nil)
+
+	loc := time.FixedZone("MMT", 390*60)
+	at := func(s string) time.Time {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	st := live.StatusAt(at("2025-08-14 14:00"))
+	if st.State != Live.ThreedCountdown || st.DrawDate != "2025-08-16" || st.SecondsLeft != 2*24*3600 {
+		t.Fatalf("two days before: %+v", st)
+	}
+	st = live.StatusAt(at("2025-08-16 13:59"))
+	if st.State != Live.ThreedCountdown || st.SecondsLeft != 60 {
+		t.Fatalf("one minute before: %+v", st)
+	}
+	st = live.StatusAt(at("2025-08-16 14:30"))
+	if st.State != Live.ThreedDrawing {
+		t.Fatalf("after draw time: %+v", st)
+	}
+	// The missing result is cached, so a stored one shows once it is published
+	d := threedata.ThreedData{Date: "2025-08-16", Result: "947"}
+	if err := repo.Create(d); err != nil {
+		t.Fatal(err)
+	}
+	if st = live.StatusAt(at("2025-08-16 14:31")); st.State != Live.ThreedDrawing {
+		t.Fatalf("cached lookup before publish: %+v", st)
+	}
+	live.PublishResult(d)
+	st = live.StatusAt(at("2025-08-16 14:31"))
+	if st.State != Live.ThreedResult || st.Result != "947" {
+		t.Fatalf("after result stored: %+v", st)
+	}
+	st = live.StatusAt(at("2025-08-17 09:00"))
+	if st.State != Live.ThreedCountdown || st.DrawDate != "2025-09-01" {
+		t.Fatalf("day after: %+v", st)
+	}
+}
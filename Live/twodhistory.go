@@ -1,30 +1,34 @@
 package Live
 
 import (
-	"database/sql"
 	"encoding/json"
+	"gosse/dates"
 	"gosse/twoddata"
 	"net/http"
 )
 
-// TwoddataHandler handles GET /twoddata and returns all rows as JSON
-func TwoddataHandler(db *sql.DB) http.HandlerFunc {
+// TwoddataHandler handles GET /twoddata and returns all rows as JSON ordered by date.
+// Optional from and to parameters limit the dates returned. Dates are written
+// as 2025/08/15 unless date_format=iso is given.
+func TwoddataHandler(repo twoddata.Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query(`SELECT id, mset, mvalue, mresult, eset, evalue, eresult, tmodern, tinernet, nmodern, ninternet, date FROM twoddata`)
+		layout, err := dates.OutputLayout(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, to, err := dates.ParseRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		all, err := repo.Between(from, to)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-		var all []twoddata.TwodData
-		for rows.Next() {
-			var d twoddata.TwodData
-			err := rows.Scan(&d.ID, &d.MSet, &d.MValue, &d.MResult, &d.ESet, &d.EValue, &d.EResult, &d.TModern, &d.TInernet, &d.NModern, &d.NInernet, &d.Date)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			all = append(all, d)
+		for i := range all {
+			all[i].Date = dates.Format(all[i].Date, layout)
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(all)
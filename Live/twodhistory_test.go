@@ -0,0 +1,64 @@
+package Live_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gosse/Live"
+	"gosse/storage"
+	"gosse/twoddata"
+)
+
+func TestTwoddataHandler(t *testing.T) {
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rec := httptest.NewRecorder()
+	Live.TwoddataHandler(twoddata.NewSQLiteRepository(db))(rec, httptest.NewRequest(http.MethodGet, "/history", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var got []twoddata.TwodData
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d rows, want 2", len(got))
+	}
+	// Dates keep the format clients have always received
+	if got[1].Date != "2025/08/18" || got[1].EResult != "73" {
+		t.Errorf("unexpected second row: %+v", got[1])
+	}
+}
+
+func TestTwoddataHandlerRange(t *testing.T) {
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h := Live.TwoddataHandler(twoddata.NewSQLiteRepository(db))
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/history?from=2025/08/16&to=18.08.2025&date_format=iso", nil))
+	var got []twoddata.TwodData
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Date != "2025-08-18" {
+		t.Errorf("range returned %+v", got)
+	}
+
+	for _, q := range []string{"from=yesterday", "from=2025-08-18&to=2025-08-15", "from=08/03/2025", "date_format=unix"} {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/history?"+q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", q, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Package admin authenticates administrative requests.
+//
+// Admins are configured as name:token pairs, for example
+//
+//	GOSSE_ADMIN_TOKENS="aung:s3cret,mya:0ther"
+//
+// and send the token as "Authorization: Bearer <token>" or "X-Admin-Token: <token>".
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// EnvTokens is the environment variable read by FromEnv
+const EnvTokens = "GOSSE_ADMIN_TOKENS"
+
+type ctxKey struct{}
+
+// Authenticator maps admin tokens to admin names
+type Authenticator struct {
+	admins []account
+}
+
+type account struct {
+	name  string
+	token []byte
+}
+
+// NewAuthenticator parses a comma separated list of name:token pairs
+func NewAuthenticator(spec string) *Authenticator {
+	a := &Authenticator{}
+	for _, pair := range strings.Split(spec, ",") {
+		name, token, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || name == "" || token == "" {
+			continue
+		}
+		a.admins = append(a.admins, account{name: name, token: []byte(token)})
+	}
+	return a
+}
+
+// FromEnv builds an Authenticator from GOSSE_ADMIN_TOKENS
+func FromEnv() *Authenticator {
+	return NewAuthenticator(os.Getenv(EnvTokens))
+}
+
+// Authenticate returns the admin name for the token carried by r
+func (a *Authenticator) Authenticate(r *http.Request) (string, bool) {
+	token := r.Header.Get("X-Admin-Token")
+	if auth := r.Header.Get("Authorization"); token == "" && strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if token == "" {
+		return "", false
+	}
+	name, found := "", false
+	for _, acc := range a.admins {
+		// Compare against every account so timing does not reveal which one matched
+		if subtle.ConstantTimeCompare(acc.token, []byte(token)) == 1 && !found {
+			name, found = acc.name, true
+		}
+	}
+	return name, found
+}
+
+// Require wraps next so it only runs for authenticated admins.
+// The admin name is available to next through Actor.
+func (a *Authenticator) Require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, ok := a.Authenticate(r)
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  "unauthorized",
+				"message": "admin token required",
+			})
+			return
+		}
+		next(w, r.WithContext(WithActor(r.Context(), name)))
+	}
+}
+
+// WithActor returns a context carrying the admin name
+func WithActor(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, name)
+}
+
+// Actor returns the authenticated admin name, or "" for anonymous requests
+func Actor(r *http.Request) string {
+	name, _ := r.Context().Value(ctxKey{}).(string)
+	return name
+}
@@ -0,0 +1,160 @@
+// Package audit keeps an append-only record of administrative mutations.
+package audit
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Entry is one row of the audit log
+type Entry struct {
+	ID            int    `json:"id"`
+	CreatedAt     string `json:"created_at"`
+	Actor         string `json:"actor"`
+	Action        string `json:"action"`
+	Target        string `json:"target"`
+	RequestID     string `json:"request_id"`
+	IP            string `json:"ip"`
+	PayloadDigest string `json:"payload_digest"`
+	Status        int    `json:"status"`
+}
+
+// Filter narrows a Query; empty fields match everything
+type Filter struct {
+	Actor     string
+	Action    string // exact action, or a prefix ending in "." such as "twod."
+	Target    string
+	RequestID string
+	Since     string // RFC3339, inclusive
+	Until     string // RFC3339, exclusive
+	Limit     int
+	Offset    int
+}
+
+// Logger is the storage contract for the audit log
+type Logger interface {
+	// Append stores e; the log can never be updated or deleted
+	Append(e Entry) (Entry, error)
+	// Query returns matching entries, newest first
+	Query(f Filter) ([]Entry, error)
+}
+
+// InitAuditTable creates the audit_log table and the triggers that make it append-only
+func InitAuditTable(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS audit_log (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        created_at TEXT NOT NULL,
+        actor TEXT NOT NULL,
+        action TEXT NOT NULL,
+        target TEXT,
+        request_id TEXT,
+        ip TEXT,
+        payload_digest TEXT,
+        status INTEGER
+    );`,
+		`CREATE INDEX IF NOT EXISTS audit_log_action ON audit_log (action, created_at);`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+    BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+    BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;`,
+	}
+	for _, q := range stmts {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SQLiteLogger implements Logger on top of the audit_log table
+type SQLiteLogger struct {
+	db *sql.DB
+}
+
+// NewSQLiteLogger wraps an open database handle
+func NewSQLiteLogger(db *sql.DB) *SQLiteLogger {
+	return &SQLiteLogger{db: db}
+}
+
+// Append inserts e, stamping the creation time when it is empty
+func (s *SQLiteLogger) Append(e Entry) (Entry, error) {
+	if e.CreatedAt == "" {
+		e.CreatedAt = time.Now().Format(time.RFC3339)
+	}
+	res, err := s.db.Exec(`INSERT INTO audit_log (created_at, actor, action, target, request_id, ip, payload_digest, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.CreatedAt, e.Actor, e.Action, e.Target, e.RequestID, e.IP, e.PayloadDigest, e.Status)
+	if err != nil {
+		return Entry{}, err
+	}
+	id, err := res.LastInsertId()
+	e.ID = int(id)
+	return e, err
+}
+
+// likeEscaper escapes the LIKE wildcards so an action prefix matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Query returns the entries matching f, newest first
+func (s *SQLiteLogger) Query(f Filter) ([]Entry, error) {
+	var where []string
+	var args []any
+	if f.Actor != "" {
+		where = append(where, "actor=?")
+		args = append(args, f.Actor)
+	}
+	if f.Action != "" {
+		if strings.HasSuffix(f.Action, ".") {
+			where = append(where, `action LIKE ? ESCAPE '\'`)
+			args = append(args, likeEscaper.Replace(f.Action)+"%")
+		} else {
+			where = append(where, "action=?")
+			args = append(args, f.Action)
+		}
+	}
+	if f.Target != "" {
+		where = append(where, "target=?")
+		args = append(args, f.Target)
+	}
+	if f.RequestID != "" {
+		where = append(where, "request_id=?")
+		args = append(args, f.RequestID)
+	}
+	if f.Since != "" {
+		where = append(where, "created_at>=?")
+		args = append(args, f.Since)
+	}
+	if f.Until != "" {
+		where = append(where, "created_at<?")
+		args = append(args, f.Until)
+	}
+	query := `SELECT id, created_at, actor, action, target, request_id, ip, payload_digest, status FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	limit := f.Limit
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, f.Offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var target, requestID, ip, digest sql.NullString
+		var status sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.Action, &target, &requestID, &ip, &digest, &status); err != nil {
+			return nil, err
+		}
+		e.Target, e.RequestID, e.IP, e.PayloadDigest, e.Status = target.String, requestID.String, ip.String, digest.String, int(status.Int64)
+		all = append(all, e)
+	}
+	return all, rows.Err()
+}
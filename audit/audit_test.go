@@ -0,0 +1,150 @@
+package audit_test
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gosse/admin"
+	"gosse/audit"
+	"gosse/storage"
+)
+
+func TestRecorderWrap(t *testing.T) {
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	l := audit.NewSQLiteLogger(db)
+	rec := audit.NewRecorder(l, admin.NewAuthenticator("aung:secret"))
+
+	ok := rec.Wrap("gift.upload", func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		audit.SetTarget(r, "flower/rose")
+		w.Write([]byte("ok"))
+	})
+	failing := rec.Wrap("chat.ban", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Missing id parameter", http.StatusBadRequest)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/addgift/?id=rose", strings.NewReader("image bytes"))
+	req.Header.Set("X-Admin-Token", "secret")
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	ok(httptest.NewRecorder(), req)
+	failing(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/chat/ban", nil))
+	ok(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/addgift/", strings.NewReader("other")))
+
+	all, err := l.Query(audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("got %d entries, want 2 (failed calls are not recorded)", len(all))
+	}
+	first := all[1]
+	if first.Actor != "aung" || first.Action != "gift.upload" || first.Target != "flower/rose" ||
+		first.RequestID != "req-1" || first.IP != "192.0.2.1" || len(first.PayloadDigest) != 64 {
+		t.Fatalf("unexpected entry: %+v", first)
+	}
+	if all[0].Actor != audit.Anonymous || all[0].PayloadDigest == first.PayloadDigest {
+		t.Fatalf("unexpected second entry: %+v", all[0])
+	}
+
+	if _, err := db.Exec("DELETE FROM audit_log"); err == nil {
+		t.Fatal("audit_log must reject deletes")
+	}
+	if _, err := db.Exec("UPDATE audit_log SET actor='x'"); err == nil {
+		t.Fatal("audit_log must reject updates")
+	}
+}
+
+func TestQueryHandlerFilters(t *testing.T) {
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	l := audit.NewSQLiteLogger(db)
+	for _, e := range []audit.Entry{
+		{Actor: "aung", Action: "twod.edit", Target: "1", CreatedAt: "2025-08-15T10:00:00+06:30"},
+		{Actor: "mya", Action: "twod.void", Target: "2", CreatedAt: "2025-08-16T10:00:00+06:30"},
+		{Actor: "mya", Action: "chat.ban", Target: "u9", CreatedAt: "2025-08-17T10:00:00+06:30"},
+	} {
+		if _, err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]int{
+		"":                       3,
+		"?actor=mya":             2,
+		"?action=twod.":          2,
+		"?action=chat.ban":       1,
+		"?since=2025-08-16":      2,
+		"?until=2025-08-16":      1,
+		"?actor=mya&target=u9":   1,
+		"?limit=1":               1,
+		"?action=twod.&offset=1": 1,
+		"?action=_wod.":          0,
+		"?action=%25.":           0,
+	}
+	h := audit.QueryHandler(l)
+	for query, want := range cases {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/admin/audit"+query, nil))
+		var got []audit.Entry
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if len(got) != want {
+			t.Errorf("%s: got %d entries, want %d", query, len(got), want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := audit.ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(old []*net.IPNet) { audit.TrustedProxies = old }(audit.TrustedProxies)
+	audit.TrustedProxies = proxies
+
+	cases := []struct {
+		remote, xff, want string
+	}{
+		{"198.51.100.4:5000", "203.0.113.7", "198.51.100.4"},
+		{"192.0.2.1:5000", "", "192.0.2.1"},
+		{"192.0.2.1:5000", "203.0.113.7", "203.0.113.7"},
+		{"192.0.2.1:5000", "1.2.3.4, 203.0.113.7, 10.0.0.1", "203.0.113.7"},
+		{"192.0.2.1:5000", "10.0.0.2, 10.0.0.1", "10.0.0.2"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = c.remote
+		if c.xff != "" {
+			req.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if got := audit.ClientIP(req); got != c.want {
+			t.Errorf("ClientIP(%s, %q) = %s, want %s", c.remote, c.xff, got, c.want)
+		}
+	}
+	// X-Forwarded-Proto is believed from the same proxies only
+	for remote, want := range map[string]string{"192.0.2.1:5000": "https", "198.51.100.4:5000": "http"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-Proto", "https")
+		if got := audit.Scheme(req); got != want {
+			t.Errorf("Scheme from %s = %s, want %s", remote, got, want)
+		}
+	}
+	if _, err := audit.ParseTrustedProxies("not-an-ip"); err == nil {
+		t.Error("ParseTrustedProxies accepted an invalid address")
+	}
+}
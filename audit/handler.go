@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// QueryHandler handles GET /admin/audit to search the audit log.
+// Filters: actor, action (exact or prefix ending in "."), target, request_id,
+// since and until (RFC3339 or YYYY-MM-DD), limit and offset.
+func QueryHandler(l Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		f := Filter{
+			Actor:     q.Get("actor"),
+			Action:    q.Get("action"),
+			Target:    q.Get("target"),
+			RequestID: q.Get("request_id"),
+		}
+		var err error
+		if f.Since, err = parseBound(q.Get("since")); err != nil {
+			http.Error(w, "Invalid since parameter", http.StatusBadRequest)
+			return
+		}
+		if f.Until, err = parseBound(q.Get("until")); err != nil {
+			http.Error(w, "Invalid until parameter", http.StatusBadRequest)
+			return
+		}
+		if v := q.Get("limit"); v != "" {
+			if f.Limit, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := q.Get("offset"); v != "" {
+			if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+				http.Error(w, "Invalid offset parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		entries, err := l.Query(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// parseBound normalizes a time filter to the RFC3339 form stored in created_at
+func parseBound(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.In(time.Local).Format(time.RFC3339), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(time.RFC3339), nil
+}
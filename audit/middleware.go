@@ -0,0 +1,234 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"gosse/admin"
+)
+
+// Anonymous is recorded as the actor when a request carries no admin token
+const Anonymous = "anonymous"
+
+type ctxKey struct{}
+
+// pending collects what a handler learns about its own target while it runs
+type pending struct {
+	target string
+}
+
+// Recorder wraps mutating handlers and appends an audit entry for every successful call
+type Recorder struct {
+	log  Logger
+	auth *admin.Authenticator
+}
+
+// NewRecorder returns a Recorder writing to l. auth identifies the actor on
+// endpoints that are not wrapped by admin.Authenticator.Require; it may be nil.
+func NewRecorder(l Logger, auth *admin.Authenticator) *Recorder {
+	return &Recorder{log: l, auth: auth}
+}
+
+// Wrap records action for each call of next that finishes with a status below 400.
+// The target defaults to the "id" or "date" query parameter and can be set by
+// next with SetTarget.
+func (rec *Recorder) Wrap(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		// Hash the query and the body as the handler reads it, so large uploads are not buffered twice
+		h := sha256.New()
+		io.WriteString(h, r.URL.RawQuery)
+		h.Write([]byte{0})
+		if r.Body != nil {
+			r.Body = &hashingBody{ReadCloser: r.Body, h: h}
+		}
+
+		p := &pending{}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next(sw, r.WithContext(context.WithValue(r.Context(), ctxKey{}, p)))
+		if sw.status >= 400 {
+			return
+		}
+
+		target := p.target
+		if target == "" {
+			target = r.URL.Query().Get("id")
+		}
+		if target == "" {
+			target = r.URL.Query().Get("date")
+		}
+		_, err := rec.log.Append(Entry{
+			Actor:         rec.actor(r),
+			Action:        action,
+			Target:        target,
+			RequestID:     requestID,
+			IP:            ClientIP(r),
+			PayloadDigest: hex.EncodeToString(h.Sum(nil)),
+			Status:        sw.status,
+		})
+		if err != nil {
+			log.Printf("audit: failed to record %s (request %s): %v", action, requestID, err)
+		}
+	}
+}
+
+func (rec *Recorder) actor(r *http.Request) string {
+	if name := admin.Actor(r); name != "" {
+		return name
+	}
+	if rec.auth != nil {
+		if name, ok := rec.auth.Authenticate(r); ok {
+			return name
+		}
+	}
+	return Anonymous
+}
+
+// SetTarget lets a wrapped handler name the object it changed, e.g. a generated id
+func SetTarget(r *http.Request, target string) {
+	if p, ok := r.Context().Value(ctxKey{}).(*pending); ok {
+		p.target = target
+	}
+}
+
+// EnvTrustedProxies names the environment variable holding the reverse
+// proxies whose X-Forwarded-For header is believed
+const EnvTrustedProxies = "GOSSE_TRUSTED_PROXIES"
+
+// TrustedProxies are the networks ClientIP accepts X-Forwarded-For from.
+// It is empty by default, so the header is ignored.
+var TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR networks
+func ParseTrustedProxies(spec string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", s)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network %q", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the remote address without port. When that address is a
+// trusted proxy, X-Forwarded-For is walked from the right and the first
+// address that is not a trusted proxy is returned; the entries further left
+// are set by the client and ignored.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trusted(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if net.ParseIP(hop) == nil || !trusted(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+// Scheme returns "https" for requests received over TLS, or forwarded by a
+// trusted proxy with X-Forwarded-Proto: https, and "http" otherwise
+func Scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if trusted(host) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		return "https"
+	}
+	return "http"
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type hashingBody struct {
+	io.ReadCloser
+	h hash.Hash
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.h.Write(p[:n])
+	return n, err
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,185 @@
+// Package blob stores media content-addressed: every file is named by the
+// SHA-256 of its bytes, so identical uploads are stored once. Blobs are
+// reference-counted from the tables that point at them, and a periodic
+// collector removes the ones nothing references after a grace period.
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"gosse/upload"
+)
+
+// RefSource returns the blob names a table references; a name may appear once per reference
+type RefSource func() ([]string, error)
+
+var blobName = regexp.MustCompile(`^[0-9a-f]{64}(\.[a-z0-9]+)?$`)
+
+// IsBlob reports whether name has the form of a blob name
+func IsBlob(name string) bool {
+	return blobName.MatchString(name)
+}
+
+// Name returns the blob name for data stored with the extension ext
+func Name(data []byte, ext string) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) + ext
+}
+
+// Store keeps blobs as flat files in Dir. Files in Dir that are not named
+// like blobs are left alone.
+type Store struct {
+	Dir  string
+	Refs []RefSource
+	// Now is the clock; tests replace it
+	Now func() time.Time
+
+	// mu orders Put against the collector's final check, so a blob that is
+	// re-uploaded while a collection runs is never deleted
+	mu sync.Mutex
+}
+
+// NewStore returns a store in dir whose references come from refs
+func NewStore(dir string, refs ...RefSource) *Store {
+	return &Store{Dir: dir, Refs: refs, Now: time.Now}
+}
+
+// Put stores data and returns its blob name. Storing bytes that already
+// exist only refreshes the blob's modification time, which restarts its
+// grace period; created reports whether a new file was written.
+func (s *Store) Put(data []byte, ext string) (name string, created bool, err error) {
+	name = Name(data, ext)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return "", false, err
+	}
+	p := filepath.Join(s.Dir, name)
+	if info, err := os.Stat(p); err == nil && info.Size() == int64(len(data)) {
+		now := s.Now()
+		return name, false, os.Chtimes(p, now, now)
+	}
+	if err := upload.WriteFile(s.Dir, name, data); err != nil {
+		return "", false, err
+	}
+	return name, true, nil
+}
+
+// RefCounts returns how often every referenced blob is referenced
+func (s *Store) RefCounts() (map[string]int, error) {
+	counts := map[string]int{}
+	for _, src := range s.Refs {
+		names, err := src()
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			if IsBlob(n) {
+				counts[n]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+// Stats summarises a store
+type Stats struct {
+	Blobs        int   `json:"blobs"`
+	Bytes        int64 `json:"bytes"`
+	Unreferenced int   `json:"unreferenced"`
+	Removed      int   `json:"removed"`
+	FreedBytes   int64 `json:"freed_bytes"`
+}
+
+// GC removes the blobs that nothing references and that were not written or
+// re-uploaded within grace. It returns the store's stats after the run.
+func (s *Store) GC(grace time.Duration) (Stats, error) {
+	var st Stats
+	counts, err := s.RefCounts()
+	if err != nil {
+		return st, err
+	}
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !IsBlob(e.Name()) {
+			continue
+		}
+		removed, size, err := s.collect(e.Name(), counts[e.Name()] > 0, grace)
+		if err != nil {
+			return st, err
+		}
+		if size < 0 {
+			continue
+		}
+		if removed {
+			st.Removed++
+			st.FreedBytes += size
+			continue
+		}
+		st.Blobs++
+		st.Bytes += size
+		if counts[e.Name()] == 0 {
+			st.Unreferenced++
+		}
+	}
+	return st, nil
+}
+
+// collect deletes one blob if it is unreferenced and older than grace. It
+// returns the blob's size, or -1 if it no longer exists.
+func (s *Store) collect(name string, referenced bool, grace time.Duration) (bool, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := filepath.Join(s.Dir, name)
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, -1, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	if referenced || s.Now().Sub(info.ModTime()) < grace {
+		return false, info.Size(), nil
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, 0, err
+	}
+	return true, info.Size(), nil
+}
+
+// StartGC collects garbage in every store once per interval until stop is
+// closed, removing unreferenced blobs older than grace
+func StartGC(stores []*Store, grace, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, s := range stores {
+			st, err := s.GC(grace)
+			if err != nil {
+				log.Printf("blob: gc %s: %v", s.Dir, err)
+			} else if st.Removed > 0 {
+				log.Printf("blob: gc %s removed %d blobs (%d bytes), %d kept", s.Dir, st.Removed, st.FreedBytes, st.Blobs)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
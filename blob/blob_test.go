@@ -0,0 +1,57 @@
+package blob_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gosse/blob"
+)
+
+func TestPutAndGC(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	var refs []string
+	s := blob.NewStore(dir, func() ([]string, error) { return refs, nil })
+	s.Now = func() time.Time { return now }
+
+	a, created, err := s.Put([]byte("image a"), ".png")
+	if err != nil || !created || !blob.IsBlob(a) || a != blob.Name([]byte("image a"), ".png") {
+		t.Fatalf("put = %q, %v, %v", a, created, err)
+	}
+	if again, created, err := s.Put([]byte("image a"), ".png"); err != nil || created || again != a {
+		t.Errorf("second put = %q, %v, %v", again, created, err)
+	}
+	b, _, _ := s.Put([]byte("image b"), ".jpg")
+	// files that are not named like blobs are never collected
+	os.WriteFile(filepath.Join(dir, "1692000000.123456.png"), []byte("legacy"), 0644)
+	refs = []string{a, a}
+
+	counts, err := s.RefCounts()
+	if err != nil || counts[a] != 2 || counts[b] != 0 {
+		t.Errorf("counts = %v, %v", counts, err)
+	}
+
+	// b is unreferenced but still within its grace period
+	st, err := s.GC(time.Hour)
+	if err != nil || st.Removed != 0 || st.Blobs != 2 || st.Unreferenced != 1 {
+		t.Errorf("gc within grace = %+v, %v", st, err)
+	}
+	now = now.Add(2 * time.Hour)
+	st, err = s.GC(time.Hour)
+	if err != nil || st.Removed != 1 || st.FreedBytes != 7 || st.Blobs != 1 {
+		t.Errorf("gc after grace = %+v, %v", st, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("%d files left, want the referenced blob and the legacy file", len(entries))
+	}
+
+	// re-uploading an unreferenced blob restarts its grace period
+	refs = nil
+	s.Put([]byte("image a"), ".png")
+	if st, _ := s.GC(time.Hour); st.Removed != 0 {
+		t.Errorf("re-uploaded blob was collected: %+v", st)
+	}
+}
@@ -6,13 +6,6 @@ import (
 	"net/http"
 )
 
-// IsBanned checks if a user id is in the ban table
-func IsBanned(db *sql.DB, id string) bool {
-	var exists string
-	err := db.QueryRow("SELECT id FROM ban WHERE id=?", id).Scan(&exists)
-	return err == nil
-}
-
 // Ban represents a banned user by id
 type Ban struct {
 	ID string `json:"id"`
@@ -25,18 +18,29 @@ func InitBanTable(db *sql.DB) error {
 	return err
 }
 
-// BanHandler handles GET /ban?id=... to ban/check a user
-func BanHandler(db *sql.DB) http.HandlerFunc {
+// BanNotifier is told about every newly banned user
+type BanNotifier func(b Ban)
+
+// BanHandler handles GET /ban?id=... to ban/check a user; the user's messages
+// are removed from the chat history either way
+func BanHandler(bans BanRepository, messages MessageRepository, notify BanNotifier) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.URL.Query().Get("id")
 		if id == "" {
 			http.Error(w, "Missing id parameter", http.StatusBadRequest)
 			return
 		}
-		var exists string
-		err := db.QueryRow("SELECT id FROM ban WHERE id=?", id).Scan(&exists)
-		if err == nil { // already banned; ensure past messages removed
-			removed := RemoveMessagesByID(id)
+		banned, err := bans.IsBanned(id)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if banned { // already banned; ensure past messages removed
+			removed, err := messages.RemoveByUser(id)
+			if err != nil {
+				http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]interface{}{
 				"status":           "already ban",
@@ -44,17 +48,20 @@ func BanHandler(db *sql.DB) http.HandlerFunc {
 				"removed_messages": removed,
 			})
 			return
-		} else if err != sql.ErrNoRows {
-			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
-			return
 		}
 		// Not found, insert
-		_, err = db.Exec("INSERT INTO ban (id) VALUES (?)", id)
-		if err != nil {
+		if err := bans.Ban(id); err != nil {
 			http.Error(w, "Database insert error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		removed := RemoveMessagesByID(id)
+		removed, err := messages.RemoveByUser(id)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if notify != nil {
+			notify(Ban{ID: id})
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":           "banned",
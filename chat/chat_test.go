@@ -0,0 +1,417 @@
+package chat_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gosse/admin"
+	"gosse/chat"
+	"gosse/storage"
+	"gosse/user"
+)
+
+type repos struct {
+	bans     *chat.SQLiteBanRepository
+	reports  *chat.SQLiteReportRepository
+	messages *chat.SQLiteMessageRepository
+	rooms    *chat.SQLiteRoomRepository
+	users    *user.SQLiteRepository
+}
+
+func openDB(t *testing.T) repos {
+	t.Helper()
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return repos{
+		bans:     chat.NewSQLiteBanRepository(db),
+		reports:  chat.NewSQLiteReportRepository(db),
+		messages: chat.NewSQLiteMessageRepository(db),
+		rooms:    chat.NewSQLiteRoomRepository(db),
+		users:    user.NewSQLiteRepository(db),
+	}
+}
+
+func (r repos) send() http.HandlerFunc {
+	return chat.SendMessageHandler(r.bans, r.messages, r.users, r.rooms, nil)
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var resp map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestBanHandler(t *testing.T) {
+	db := openDB(t)
+	h := chat.BanHandler(db.bans, db.messages, nil)
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/chat/ban?id=u2", nil))
+	if got := decode(t, rec)["status"]; got != "banned" {
+		t.Fatalf("status = %v, want banned", got)
+	}
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/chat/ban?id=u2", nil))
+	if got := decode(t, rec)["status"]; got != "already ban" {
+		t.Fatalf("status = %v, want already ban", got)
+	}
+}
+
+func TestSendMessageHandlerRejectsBanned(t *testing.T) {
+	db := openDB(t)
+	rec := httptest.NewRecorder()
+	db.send()(rec, httptest.NewRequest(http.MethodPost, "/chat/sendmessage", strings.NewReader(`{"id":"banned-user","message":"hi"}`)))
+	if got := decode(t, rec)["status"]; got != "banned" {
+		t.Fatalf("status = %v, want banned", got)
+	}
+}
+
+func TestReportHandler(t *testing.T) {
+	h := chat.ReportHandler(openDB(t).reports, nil)
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/chat/report", strings.NewReader(`{"userid":"u1","reportid":"spammer"}`)))
+	if got := decode(t, rec)["status"]; got != "already report" {
+		t.Fatalf("status = %v, want already report", got)
+	}
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/chat/report", strings.NewReader(`{"userid":"u2","reportid":"spammer"}`)))
+	if got := decode(t, rec)["status"]; got != "reported" {
+		t.Fatalf("status = %v, want reported", got)
+	}
+}
+
+func TestChatHistory(t *testing.T) {
+	db := openDB(t)
+	send := db.send()
+	for body, code := range map[string]int{
+		`{"id":"u1","message":"   "}`:                              http.StatusBadRequest,
+		`{"id":"u1","message":"` + strings.Repeat("a", 501) + `"}`: http.StatusBadRequest,
+		`{"userId":"u1","message":"hi"}`:                           http.StatusBadRequest,
+		`{"id":"stranger","message":"hi"}`:                         http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		send(rec, httptest.NewRequest(http.MethodPost, "/chat/sendmessage", strings.NewReader(body)))
+		if rec.Code != code {
+			t.Errorf("%.40s: status = %d, want %d", body, rec.Code, code)
+		}
+	}
+	for _, body := range []string{
+		`{"id":"u1","message":" one "}`,
+		`{"id":"u1","message":"one"}`, // repeat, not stored
+		`{"id":"u2","message":"two","created_at":"yesterday","name":"Admin","admin":true}`,
+		`{"id":"u1","message":"three"}`,
+	} {
+		rec := httptest.NewRecorder()
+		send(rec, httptest.NewRequest(http.MethodPost, "/chat/sendmessage", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", body, rec.Code)
+		}
+	}
+
+	history := func(query string) []any {
+		rec := httptest.NewRecorder()
+		chat.HistoryHandler(db.messages, db.rooms)(rec, httptest.NewRequest(http.MethodGet, "/chat/history"+query, nil))
+		return decode(t, rec)["messages"].([]any)
+	}
+	all := history("")
+	if len(all) != 3 {
+		t.Fatalf("history = %v", all)
+	}
+	second := all[1].(map[string]any)
+	if second["message"] != "two" || second["created_at"] == "yesterday" || second["message_id"] != 2.0 || second["name"] != "Su Su" || second["admin"] != nil {
+		t.Errorf("second message = %v", second)
+	}
+	if first := all[0].(map[string]any); first["message"] != "one" || first["profile_pic"] != "http://localhost/images/u1.png" {
+		t.Errorf("first message = %v", first)
+	}
+	if page := history("?before=3&limit=1"); len(page) != 1 || page[0].(map[string]any)["message"] != "two" {
+		t.Errorf("page before 3 = %v", page)
+	}
+
+	// a new viewer gets the recent messages first
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	chat.ChatSSEHandler(db.messages, db.rooms)(rec, httptest.NewRequest(http.MethodGet, "/chat/sse", nil).WithContext(ctx))
+	if n := strings.Count(rec.Body.String(), "data: "); n != 3 || !strings.Contains(rec.Body.String(), `"message":"three"`) {
+		t.Errorf("stream = %q", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	chat.BanHandler(db.bans, db.messages, nil)(rec, httptest.NewRequest(http.MethodGet, "/chat/ban?id=u1", nil))
+	if got := decode(t, rec)["removed_messages"]; got != 2.0 {
+		t.Errorf("removed_messages = %v, want 2", got)
+	}
+	if left := history(""); len(left) != 1 {
+		t.Errorf("history after ban = %v", left)
+	}
+}
+
+func TestChatRooms(t *testing.T) {
+	db := openDB(t)
+	send := func(query, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		db.send()(rec, httptest.NewRequest(http.MethodPost, "/chat/sendmessage"+query, strings.NewReader(body)))
+		return rec
+	}
+	if rec := send("?room=nowhere", `{"id":"u1","message":"hi"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown room: status = %d", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	chat.ModeratorHandler(db.rooms)(rec, httptest.NewRequest(http.MethodPost, "/admin/chat/rooms/moderator", strings.NewReader(`{"room":"2d","user_id":"u2","moderator":true}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("moderator: status = %d: %s", rec.Code, rec.Body)
+	}
+	send("?room=2d", `{"id":"u1","message":"12 today"}`)
+	send("?room=2d", `{"id":"u2","message":"no spam please"}`)
+	send("?room=3d", `{"id":"u1","message":"12 today"}`)
+	send("", `{"id":"u1","message":"hello"}`)
+
+	for room, want := range map[string]int{"2d": 2, "3d": 1, "general": 1, "thai": 0} {
+		page, err := db.messages.History(room, 0, 10)
+		if err != nil || len(page) != want {
+			t.Errorf("%s history = %+v, %v", room, page, err)
+		}
+	}
+	// nothing in the history tells the moderator apart
+	page, _ := db.messages.History("2d", 0, 10)
+	if page[1].Room != "2d" {
+		t.Errorf("2d history = %+v", page)
+	}
+	rec = httptest.NewRecorder()
+	chat.HistoryHandler(db.messages, db.rooms)(rec, httptest.NewRequest(http.MethodGet, "/chat/history?room=2d", nil))
+	if strings.Contains(rec.Body.String(), "moderator") {
+		t.Errorf("history reveals moderators: %s", rec.Body)
+	}
+
+	// a viewer of 2d is counted in the listing
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		chat.ChatSSEHandler(db.messages, db.rooms)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/chat/sse?room=2d", nil).WithContext(ctx))
+		close(done)
+	}()
+	for i := 0; i < 100 && chat.Members("2d") == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	rec = httptest.NewRecorder()
+	chat.RoomsHandler(db.rooms)(rec, httptest.NewRequest(http.MethodGet, "/chat/rooms", nil))
+	var rooms []chat.Room
+	json.NewDecoder(rec.Body).Decode(&rooms)
+	cancel()
+	<-done
+	if len(rooms) != 4 || rooms[1].ID != "2d" || rooms[1].Members != 1 || rooms[0].Members != 0 || len(rooms[1].Moderators) != 0 {
+		t.Errorf("rooms = %+v", rooms)
+	}
+	rec = httptest.NewRecorder()
+	chat.AdminRoomsHandler(db.rooms)(rec, httptest.NewRequest(http.MethodGet, "/admin/chat/rooms", nil))
+	rooms = nil
+	json.NewDecoder(rec.Body).Decode(&rooms)
+	if len(rooms) != 4 || len(rooms[1].Moderators) != 1 || rooms[1].Moderators[0] != "u2" {
+		t.Errorf("admin rooms = %+v", rooms)
+	}
+
+	auth := admin.NewAuthenticator("aung:secret")
+	del := func(token string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/chat/delete?room=2d", strings.NewReader(`{"message_id":1}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		auth.Require(chat.DeleteMessageHandler(db.messages, db.rooms))(rec, req)
+		return rec.Code
+	}
+	if code := del(""); code != http.StatusUnauthorized {
+		t.Errorf("delete without a token: status = %d", code)
+	}
+	if code := del("secret"); code != http.StatusOK {
+		t.Errorf("delete by admin: status = %d", code)
+	}
+	if code := del("secret"); code != http.StatusNotFound {
+		t.Errorf("second delete: status = %d", code)
+	}
+}
+
+// stalledWriter is a viewer that takes the history and then stops reading
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	mu      sync.Mutex
+	flushes int
+	stall   chan struct{}
+}
+
+func (w *stalledWriter) Flush() {
+	w.mu.Lock()
+	w.flushes++
+	stalled := w.flushes > 1
+	w.mu.Unlock()
+	if stalled {
+		<-w.stall
+	}
+}
+
+func TestSlowViewerIsDisconnected(t *testing.T) {
+	db := openDB(t)
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), stall: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		chat.ChatSSEHandler(db.messages, db.rooms)(w, httptest.NewRequest(http.MethodGet, "/chat/sse?room=thai", nil))
+		close(done)
+	}()
+	for i := 0; i < 100 && chat.Members("thai") == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	// a burst of gifts is buffered; when the buffer overflows the viewer is
+	// dropped instead of silently missing events
+	sent := 0
+	for ; sent < 1000 && chat.Members("thai") == 1; sent++ {
+		chat.PublishRoomEvent("thai", "gift", map[string]int{"n": sent})
+	}
+	if sent < 10 || chat.Members("thai") != 0 {
+		t.Errorf("viewer dropped after %d gifts, %d members left", sent, chat.Members("thai"))
+	}
+	close(w.stall)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stalled viewer was not disconnected")
+	}
+	if got := strings.Count(w.Body.String(), "event: gift"); got != sent-1 {
+		t.Errorf("viewer got %d of the %d buffered gifts", got, sent-1)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := chat.NewLimiter(0.5, 2)
+	l.Now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	if ok, retry := l.Allow("a"); ok || retry != 2*time.Second {
+		t.Errorf("empty bucket = %v, %v", ok, retry)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("other key shares the bucket")
+	}
+	now = now.Add(2 * time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("bucket did not refill")
+	}
+}
+
+func TestSendMessageThrottle(t *testing.T) {
+	db := openDB(t)
+	now := time.Now()
+	th := chat.NewThrottle()
+	th.Users = chat.NewLimiter(1, 3)
+	th.Users.Now = func() time.Time { return now }
+	th.AutoMuteStrikes = 3
+	th.MuteFor = time.Minute
+	send := chat.SendMessageHandler(db.bans, db.messages, db.users, db.rooms, th)
+	postFrom := func(ip, room, id string, n int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/chat/sendmessage?room="+room, strings.NewReader(fmt.Sprintf(`{"id":%q,"message":"message %d"}`, id, n)))
+		req.RemoteAddr = ip + ":1234"
+		send(rec, req)
+		return rec
+	}
+	post := func(room, id string, n int) *httptest.ResponseRecorder {
+		return postFrom("192.0.2.1", room, id, n)
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := post("general", "u1", i); rec.Code != http.StatusOK {
+			t.Fatalf("message %d: status = %d", i, rec.Code)
+		}
+	}
+	rec := post("general", "u1", 3)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("over the limit: status = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if resp := decode(t, rec); resp["status"] != "rate limited" || resp["retry_after"] != 1.0 {
+		t.Errorf("over the limit = %v", resp)
+	}
+
+	// slow mode holds back everyone but the moderators of the room
+	rec = httptest.NewRecorder()
+	chat.SlowModeHandler(db.rooms)(rec, httptest.NewRequest(http.MethodPost, "/admin/chat/rooms/slowmode", strings.NewReader(`{"room":"3d","seconds":60}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("slow mode: status = %d: %s", rec.Code, rec.Body)
+	}
+	db.rooms.SetModerator("3d", "u1", true)
+	post("3d", "u2", 1)
+	if rec := post("3d", "u2", 2); rec.Code != http.StatusTooManyRequests || decode(t, rec)["status"] != "slow mode" {
+		t.Errorf("second message in slow mode: status = %d", rec.Code)
+	}
+	now = now.Add(time.Minute)
+	post("3d", "u1", 10)
+	if rec := post("3d", "u1", 11); rec.Code != http.StatusOK {
+		t.Errorf("moderator in slow mode: status = %d", rec.Code)
+	}
+
+	// the third throttled attempt mutes u2 for a while without banning it
+	if rec := post("3d", "u2", 3); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second strike: status = %d", rec.Code)
+	}
+	rec = post("3d", "u2", 4)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" || decode(t, rec)["status"] != "muted" {
+		t.Errorf("third strike: status = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if got := decode(t, post("general", "u2", 5))["status"]; got != "muted" {
+		t.Errorf("while muted: status = %v", got)
+	}
+	if banned, _ := db.bans.IsBanned("u2"); banned {
+		t.Error("u2 was banned by the throttle")
+	}
+	if page, _ := db.messages.History("3d", 0, 10); len(page) != 3 {
+		t.Errorf("3d history after the mute = %+v", page)
+	}
+	now = now.Add(time.Minute)
+	if rec := post("general", "u2", 6); rec.Code != http.StatusOK {
+		t.Errorf("after the mute: status = %d", rec.Code)
+	}
+
+	// strikes under u1's id from another IP mute only that IP
+	for i := 0; i < 6; i++ {
+		postFrom("198.51.100.7", "general", "u1", 20+i)
+	}
+	if got := decode(t, postFrom("198.51.100.7", "general", "u1", 30))["status"]; got != "muted" {
+		t.Errorf("forged sender: status = %v, want muted", got)
+	}
+	now = now.Add(3 * time.Second)
+	if rec := post("general", "u1", 31); rec.Code != http.StatusOK {
+		t.Errorf("u1 from its own IP: status = %d", rec.Code)
+	}
+
+	// users sharing an IP are held back by its limit but never struck for it
+	th = chat.NewThrottle()
+	th.IPs = chat.NewLimiter(1, 1)
+	th.IPs.Now = func() time.Time { return now }
+	th.AutoMuteStrikes = 1
+	send = chat.SendMessageHandler(db.bans, db.messages, db.users, db.rooms, th)
+	post("general", "u1", 40)
+	for i := 0; i < 3; i++ {
+		if rec := post("general", "u3", 41+i); rec.Code != http.StatusTooManyRequests || decode(t, rec)["status"] != "rate limited" {
+			t.Errorf("shared IP attempt %d: status = %d", i, rec.Code)
+		}
+	}
+}
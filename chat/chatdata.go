@@ -1,64 +1,171 @@
 package chat
 
 import (
-	"reflect"
+	"database/sql"
+	"encoding/json"
+	"gosse/dbutil"
 	"sync"
+	"time"
 )
 
-var (
-	chatMessages []any
-	chatMu       sync.Mutex
-)
+// HistorySize is how many recent messages ChatSSEHandler sends on connect
+var HistorySize = 50
 
-// RemoveMessagesByID removes all messages whose map[string]any has id == given id.
-// Returns number of removed messages.
-func RemoveMessagesByID(id string) int {
-	chatMu.Lock()
-	defer chatMu.Unlock()
-	if id == "" || len(chatMessages) == 0 {
-		return 0
-	}
-	kept := make([]any, 0, len(chatMessages))
-	removed := 0
-	for _, m := range chatMessages {
-		if mm, ok := m.(map[string]any); ok {
-			if vid, ok2 := mm["id"].(string); ok2 && vid == id {
-				removed++
-				continue
-			}
+// InitMessageTable creates the chat_message table if it does not exist. The
+// message is kept in payload; its server id and time are columns.
+func InitMessageTable(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS chat_message (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id TEXT NOT NULL,
+        payload TEXT NOT NULL,
+        created_at TEXT NOT NULL
+    );`,
+		`CREATE INDEX IF NOT EXISTS chat_message_user ON chat_message (user_id);`,
+	}
+	for _, q := range stmts {
+		if _, err := db.Exec(q); err != nil {
+			return err
 		}
-		kept = append(kept, m)
 	}
-	if removed > 0 {
-		chatMessages = kept
+	if err := dbutil.AddColumn(db, "chat_message", "room", "TEXT NOT NULL DEFAULT '"+DefaultRoom+"'"); err != nil {
+		return err
+	}
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS chat_message_room ON chat_message (room, id)`)
+	return err
+}
+
+// MessageRepository is the storage contract for the chat history
+type MessageRepository interface {
+	// Add stores m in m.Room with its server id and time unless it repeats the
+	// latest message of the room; added reports whether it was stored, and a
+	// repeat returns the stored message
+	Add(m Message) (saved Message, added bool, err error)
+	// History returns up to limit messages of room older than message before,
+	// oldest first; before 0 returns the most recent ones
+	History(room string, before int64, limit int) ([]Message, error)
+	// Delete removes one message of room; ok is false when there is none
+	Delete(room string, id int64) (ok bool, err error)
+	// RemoveByUser deletes every message of userID and returns how many it deleted
+	RemoveByUser(userID string) (int, error)
+	// LastSent returns when userID last sent a message to room, or the zero time
+	LastSent(room, userID string) (time.Time, error)
+}
+
+// SQLiteMessageRepository implements MessageRepository on top of the chat_message table
+type SQLiteMessageRepository struct {
+	db *sql.DB
+	// mu makes the duplicate check and the insert of Add one step
+	mu sync.Mutex
+}
+
+// NewSQLiteMessageRepository wraps an open database handle
+func NewSQLiteMessageRepository(db *sql.DB) *SQLiteMessageRepository {
+	return &SQLiteMessageRepository{db: db}
+}
+
+func scanMessage(id int64, room, payload, createdAt string) (Message, error) {
+	var m Message
+	if err := json.Unmarshal([]byte(payload), &m); err != nil {
+		return m, err
 	}
-	return removed
+	m.MessageID, m.Room, m.CreatedAt = id, room, createdAt
+	return m, nil
 }
 
-// AddChatMessage adds a message to the chatMessages slice, keeping only the last 50.
-// Returns true if the message was appended, false if it was considered a duplicate of the last.
-func AddChatMessage(msg any) bool {
-	chatMu.Lock()
-	defer chatMu.Unlock()
-	if n := len(chatMessages); n > 0 {
-		last := chatMessages[n-1]
-		if reflect.DeepEqual(last, msg) { // exact duplicate of last message
-			return false
+// Add inserts m; the payload holds the message without its server fields
+func (s *SQLiteMessageRepository) Add(m Message) (Message, bool, error) {
+	room := m.Room
+	m.MessageID, m.Room, m.CreatedAt = 0, "", ""
+	b, err := json.Marshal(m)
+	if err != nil {
+		return m, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var id int64
+	var payload, createdAt string
+	err = s.db.QueryRow(`SELECT id, payload, created_at FROM chat_message WHERE room=? ORDER BY id DESC LIMIT 1`, room).Scan(&id, &payload, &createdAt)
+	if err != nil && err != sql.ErrNoRows {
+		return m, false, err
+	}
+	if err == nil {
+		if last, err := scanMessage(id, room, payload, createdAt); err == nil && last.ID == m.ID && last.Message == m.Message {
+			return last, false, nil
 		}
 	}
-	chatMessages = append(chatMessages, msg)
-	if len(chatMessages) > 50 {
-		chatMessages = chatMessages[len(chatMessages)-50:]
+	m.Room, m.CreatedAt = room, time.Now().Format(time.RFC3339)
+	res, err := s.db.Exec(`INSERT INTO chat_message (user_id, room, payload, created_at) VALUES (?, ?, ?, ?)`, m.ID, room, string(b), m.CreatedAt)
+	if err != nil {
+		return m, false, err
+	}
+	if m.MessageID, err = res.LastInsertId(); err != nil {
+		return m, false, err
 	}
-	return true
+	return m, true, nil
 }
 
-// GetLatestChatMessage returns the latest message (or nil if none)
-func GetLatestChatMessage() any {
-	chatMu.Lock()
-	defer chatMu.Unlock()
-	if len(chatMessages) == 0 {
-		return nil
-	}
-	return chatMessages[len(chatMessages)-1]
+// History pages backwards by id and reverses the page into chronological order
+func (s *SQLiteMessageRepository) History(room string, before int64, limit int) ([]Message, error) {
+	rows, err := s.db.Query(`SELECT id, payload, created_at FROM chat_message WHERE room=? AND (?=0 OR id < ?) ORDER BY id DESC LIMIT ?`, room, before, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Message{}
+	for rows.Next() {
+		var id int64
+		var payload, createdAt string
+		if err := rows.Scan(&id, &payload, &createdAt); err != nil {
+			return nil, err
+		}
+		m, err := scanMessage(id, room, payload, createdAt)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
+
+// Delete removes the row with id in room
+func (s *SQLiteMessageRepository) Delete(room string, id int64) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM chat_message WHERE room=? AND id=?`, room, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RemoveByUser deletes the rows of userID in every room
+func (s *SQLiteMessageRepository) RemoveByUser(userID string) (int, error) {
+	if userID == "" {
+		return 0, nil
+	}
+	res, err := s.db.Exec(`DELETE FROM chat_message WHERE user_id=?`, userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// LastSent reads the created_at of the newest row of userID in room
+func (s *SQLiteMessageRepository) LastSent(room, userID string) (time.Time, error) {
+	var createdAt string
+	err := s.db.QueryRow(`SELECT created_at FROM chat_message WHERE room=? AND user_id=? ORDER BY id DESC LIMIT 1`, room, userID).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, createdAt)
 }
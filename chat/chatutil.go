@@ -1,9 +1,38 @@
 package chat
 
-// ProfilePicMessage represents a public chat message with profile picture and metadata
-type ProfilePicMessage struct {
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxMessageLength is the longest message body accepted, in characters
+var MaxMessageLength = 500
+
+// Message is the canonical chat message. MessageID and CreatedAt are set by
+// the server and Name and ProfilePic come from the sender's useraccount.
+// Nothing marks moderators: sender ids are not authenticated, and a known
+// moderator id would let anyone skip slow mode.
+type Message struct {
+	MessageID  int64  `json:"message_id"`
+	Room       string `json:"room"`
 	ID         string `json:"id"`
-	Message    string `json:"message"`
+	Name       string `json:"name"`
 	ProfilePic string `json:"profile_pic"`
+	Message    string `json:"message"`
 	CreatedAt  string `json:"created_at"`
 }
+
+// NormalizeBody trims body and checks it is valid, non-empty and within MaxMessageLength
+func NormalizeBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	switch {
+	case !utf8.ValidString(body):
+		return "", errors.New("message is not valid UTF-8")
+	case body == "":
+		return "", errors.New("message is empty")
+	case utf8.RuneCountInString(body) > MaxMessageLength:
+		return "", errors.New("message is too long")
+	}
+	return body, nil
+}
@@ -4,92 +4,207 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
 
-// Pub/Sub for immediate SSE broadcast
-var chatSubscribers = make(map[chan any]struct{})
+// Pub/Sub for immediate SSE broadcast, one subscriber set per room
+var chatSubscribers = make(map[string]map[chan any]struct{})
 var chatSubMu sync.Mutex
 
-// Subscribe returns a channel that receives new chat messages
-func subscribe() chan any {
-	ch := make(chan any, 1)
+// subscriberBuffer is how many messages and events a viewer may fall behind
+// before it is disconnected
+const subscriberBuffer = 64
+
+// Subscribe returns a channel that receives new chat messages of room
+func subscribe(room string) chan any {
+	ch := make(chan any, subscriberBuffer)
 	chatSubMu.Lock()
-	chatSubscribers[ch] = struct{}{}
+	if chatSubscribers[room] == nil {
+		chatSubscribers[room] = make(map[chan any]struct{})
+	}
+	chatSubscribers[room][ch] = struct{}{}
 	chatSubMu.Unlock()
 	return ch
 }
 
-// Unsubscribe removes a channel from subscribers
-func unsubscribe(ch chan any) {
+// Unsubscribe removes a channel from the subscribers of room
+func unsubscribe(room string, ch chan any) {
 	chatSubMu.Lock()
-	delete(chatSubscribers, ch)
+	drop(room, ch)
 	chatSubMu.Unlock()
+}
+
+// drop removes and closes ch unless that was done already; chatSubMu is held
+func drop(room string, ch chan any) {
+	if _, ok := chatSubscribers[room][ch]; !ok {
+		return
+	}
+	delete(chatSubscribers[room], ch)
+	if len(chatSubscribers[room]) == 0 {
+		delete(chatSubscribers, room)
+	}
 	close(ch)
 }
 
-// Publish sends a message to all subscribers
-func publish(msg any) {
+// Publish sends a message to all subscribers of room. Nothing is dropped
+// silently: a viewer whose buffer is full is disconnected instead, and gets
+// the history again when it reconnects.
+func publish(room string, msg any) {
 	chatSubMu.Lock()
-	for ch := range chatSubscribers {
+	for ch := range chatSubscribers[room] {
 		select {
 		case ch <- msg:
 		default:
+			drop(room, ch)
 		}
 	}
 	chatSubMu.Unlock()
 }
 
-func ChatSSEHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+// Members returns how many viewers are connected to room
+func Members(room string) int {
+	chatSubMu.Lock()
+	defer chatSubMu.Unlock()
+	return len(chatSubscribers[room])
+}
+
+// event is a typed entry of the chat stream; plain chat messages have no type
+type event struct {
+	name string
+	data any
+}
+
+// PublishRoomEvent sends v to the viewers of room as an SSE event named name,
+// so clients can tell it apart from chat messages
+func PublishRoomEvent(room, name string, v any) {
+	publish(room, event{name: name, data: v})
+}
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+// writeSSE writes msg as one SSE event
+func writeSSE(w http.ResponseWriter, msg any) {
+	name := ""
+	if e, ok := msg.(event); ok {
+		name, msg = e.name, e.data
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
 		return
 	}
+	if name != "" {
+		fmt.Fprintf(w, "event: %s\n", name)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", string(b))
+}
 
-	// On first connect, send all 50 messages as SSE events (data: ...)
-	chatMu.Lock()
-	all := make([]any, len(chatMessages))
-	copy(all, chatMessages)
-	chatMu.Unlock()
-	for _, msg := range all {
-		b, err := json.Marshal(msg)
-		if err == nil {
-			fmt.Fprintf(w, "data: %s\n\n", string(b))
+// ChatSSEHandler streams one room of the chat via SSE, ?room= defaulting to
+// DefaultRoom: the most recent HistorySize messages on connect, then every new
+// message and typed event
+func ChatSSEHandler(messages MessageRepository, rooms RoomRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		room, ok := roomParam(w, r, rooms)
+		if !ok {
+			return
 		}
-	}
-	flusher.Flush()
-
-	// Subscribe for new messages
-	sub := subscribe()
-	defer unsubscribe(sub)
-	notify := r.Context().Done()
-	pingTicker := time.NewTicker(15 * time.Second)
-	defer pingTicker.Stop()
-	for {
-		select {
-		case <-notify:
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
 			return
-		case msg := <-sub:
-			b, err := json.Marshal(msg)
-			if err == nil {
-				fmt.Fprintf(w, "data: %s\n\n", string(b))
+		}
+
+		// Subscribe before loading the history so no message falls in between
+		sub := subscribe(room.ID)
+		defer unsubscribe(room.ID, sub)
+
+		// On first connect, send the recent messages as SSE events (data: ...)
+		recent, err := messages.History(room.ID, 0, HistorySize)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var last int64
+		for _, msg := range recent {
+			writeSSE(w, msg)
+			last = msg.MessageID
+		}
+		flusher.Flush()
+
+		notify := r.Context().Done()
+		pingTicker := time.NewTicker(15 * time.Second)
+		defer pingTicker.Stop()
+		for {
+			select {
+			case <-notify:
+				return
+			case msg, ok := <-sub:
+				if !ok { // too slow, disconnected by publish
+					return
+				}
+				// skip messages already sent as history
+				if m, ok := msg.(Message); ok && m.MessageID <= last {
+					continue
+				}
+				writeSSE(w, msg)
+				flusher.Flush()
+			case <-pingTicker.C:
+				// Send SSE comment as keepalive (ping)
+				fmt.Fprintf(w, ": ping\n\n")
 				flusher.Flush()
 			}
-		case <-pingTicker.C:
-			// Send SSE comment as keepalive (ping)
-			fmt.Fprintf(w, ": ping\n\n")
-			flusher.Flush()
 		}
 	}
 }
 
+// HistoryHandler handles GET /chat/history?room=&before=&limit= and pages
+// backwards through a room: it returns up to limit messages older than
+// message_id before, oldest first. Pass the first message_id of a page as the
+// next before.
+func HistoryHandler(messages MessageRepository, rooms RoomRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		room, ok := roomParam(w, r, rooms)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		var before int64
+		if v := q.Get("before"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				http.Error(w, "Invalid before parameter", http.StatusBadRequest)
+				return
+			}
+			before = n
+		}
+		limit := HistorySize
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 200 {
+				http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		page, err := messages.History(room.ID, before, limit)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   "success",
+			"room":     room.ID,
+			"messages": page,
+			"has_more": len(page) == limit,
+		})
+	}
+}
+
 // // ChatSSEHandler streams chat messages via SSE
 // func ChatSSEHandler(w http.ResponseWriter, r *http.Request) {
 // 	w.Header().Set("Content-Type", "text/event-stream")
@@ -0,0 +1,195 @@
+package chat
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limiter is a set of token buckets, one per key. Each bucket holds up to
+// Burst tokens and refills at Rate tokens per second; a request takes one.
+// It is kept in memory, so it restarts full with the server.
+type Limiter struct {
+	Rate  float64
+	Burst float64
+	// Now is the clock; tests replace it
+	Now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxIdleBuckets is how many buckets a Limiter keeps before it drops the full ones
+const maxIdleBuckets = 10000
+
+// NewLimiter allows burst requests at once per key and rate requests per second after that
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{Rate: rate, Burst: float64(burst), Now: time.Now, buckets: map[string]*bucket{}}
+}
+
+// Allow takes a token from the bucket of key. When it is empty, Allow returns
+// false and how long until the next token.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.Now()
+	if len(l.buckets) > maxIdleBuckets {
+		for k, b := range l.buckets {
+			if l.refill(b, now) >= l.Burst {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.Burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens, b.last = l.refill(b, now), now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(l.Burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+}
+
+// Throttle limits how fast messages are sent, per user and per client IP, and
+// counts the throttled attempts of each user from each IP. A user with
+// AutoMuteStrikes throttled attempts from one IP within StrikeWindow is muted
+// from that IP for MuteFor. Sender ids are not authenticated, so the throttle
+// never bans: someone posting under another user's id only mutes themselves.
+// A nil *Throttle allows everything.
+type Throttle struct {
+	Users *Limiter
+	IPs   *Limiter
+
+	AutoMuteStrikes int
+	StrikeWindow    time.Duration
+	MuteFor         time.Duration
+
+	mu      sync.Mutex
+	strikes map[string][]time.Time
+	muted   map[string]time.Time
+}
+
+// NewThrottle uses the default limits: a burst of 5 messages and one every
+// 2 seconds per user, 20 and 2 per second per IP, and a 10 minute mute after
+// 30 throttled attempts in 10 minutes
+func NewThrottle() *Throttle {
+	return &Throttle{
+		Users:           NewLimiter(0.5, 5),
+		IPs:             NewLimiter(2, 20),
+		AutoMuteStrikes: 30,
+		StrikeWindow:    10 * time.Minute,
+		MuteFor:         10 * time.Minute,
+		strikes:         map[string][]time.Time{},
+		muted:           map[string]time.Time{},
+	}
+}
+
+// Allow takes a token for both the user and the IP; scope names the limit
+// that was hit
+func (t *Throttle) Allow(userID, ip string) (ok bool, scope string, retry time.Duration) {
+	if t == nil {
+		return true, "", 0
+	}
+	if ok, retry := t.IPs.Allow(ip); !ok {
+		return false, "ip", retry
+	}
+	if ok, retry := t.Users.Allow(userID); !ok {
+		return false, "user", retry
+	}
+	return true, "", 0
+}
+
+// Muted returns how long userID stays muted from ip, or 0
+func (t *Throttle) Muted(userID, ip string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until, ok := t.muted[strikeKey(userID, ip)]
+	if !ok {
+		return 0
+	}
+	left := until.Sub(t.Users.Now())
+	if left <= 0 {
+		delete(t.muted, strikeKey(userID, ip))
+		return 0
+	}
+	return left
+}
+
+// Strike records a throttled attempt of userID from ip and reports whether
+// that mutes the user from ip; the count starts over after that
+func (t *Throttle) Strike(userID, ip string) bool {
+	if t == nil || t.AutoMuteStrikes <= 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := strikeKey(userID, ip)
+	now := t.Users.Now()
+	if len(t.strikes) > maxIdleBuckets {
+		for k, at := range t.strikes {
+			if now.Sub(at[len(at)-1]) >= t.StrikeWindow {
+				delete(t.strikes, k)
+			}
+		}
+	}
+	live := t.strikes[key][:0]
+	for _, at := range t.strikes[key] {
+		if now.Sub(at) < t.StrikeWindow {
+			live = append(live, at)
+		}
+	}
+	live = append(live, now)
+	if len(live) < t.AutoMuteStrikes {
+		t.strikes[key] = live
+		return false
+	}
+	delete(t.strikes, key)
+	if len(t.muted) > maxIdleBuckets {
+		for k, until := range t.muted {
+			if !until.After(now) {
+				delete(t.muted, k)
+			}
+		}
+	}
+	t.muted[key] = now.Add(t.MuteFor)
+	return true
+}
+
+func strikeKey(userID, ip string) string {
+	return userID + "\x00" + ip
+}
+
+// writeThrottled answers a throttled message with 429, a Retry-After header
+// and the same delay in seconds in the body
+func writeThrottled(w http.ResponseWriter, status, message string, retry time.Duration) {
+	secs := int(math.Ceil(retry.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":      status,
+		"message":     message,
+		"retry_after": secs,
+	})
+}
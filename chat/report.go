@@ -24,8 +24,11 @@ func InitReportTable(db *sql.DB) error {
 	return err
 }
 
+// ReportNotifier is told about every new report
+type ReportNotifier func(r Report)
+
 // ReportHandler handles POST /report to add or update a report
-func ReportHandler(db *sql.DB) http.HandlerFunc {
+func ReportHandler(reports ReportRepository, notify ReportNotifier) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -45,9 +48,12 @@ func ReportHandler(db *sql.DB) http.HandlerFunc {
 		}
 
 		// Check if this userid and reportid already exists
-		var count int
-		err := db.QueryRow("SELECT reportcount FROM report WHERE userid=? AND reportid=?", req.UserID, req.ReportID).Scan(&count)
-		if err == nil {
+		exists, err := reports.Exists(req.UserID, req.ReportID)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if exists {
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]interface{}{
 				"status":   "already report",
@@ -55,31 +61,15 @@ func ReportHandler(db *sql.DB) http.HandlerFunc {
 				"reportid": req.ReportID,
 			})
 			return
-		} else if err != sql.ErrNoRows {
-			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
-			return
 		}
 
-		// Check if reportid exists in any row
-		err = db.QueryRow("SELECT reportcount FROM report WHERE reportid=? ORDER BY reportcount DESC LIMIT 1", req.ReportID).Scan(&count)
-		if err == nil {
-			// Exists, insert new row with incremented reportcount
-			_, err = db.Exec("INSERT INTO report (userid, reportid, reportcount) VALUES (?, ?, ?)", req.UserID, req.ReportID, count+1)
-			if err != nil {
-				http.Error(w, "Database insert error: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-		} else if err == sql.ErrNoRows {
-			// Not found, insert new row with reportcount = 1
-			_, err = db.Exec("INSERT INTO report (userid, reportid, reportcount) VALUES (?, ?, 1)", req.UserID, req.ReportID)
-			if err != nil {
-				http.Error(w, "Database insert error: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		if err := reports.Add(req.UserID, req.ReportID); err != nil {
+			http.Error(w, "Database insert error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if notify != nil {
+			notify(Report{UserID: req.UserID, ReportID: req.ReportID})
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
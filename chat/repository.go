@@ -0,0 +1,73 @@
+package chat
+
+import (
+	"database/sql"
+)
+
+// BanRepository is the storage contract for banned user ids
+type BanRepository interface {
+	// IsBanned reports whether id is in the ban list
+	IsBanned(id string) (bool, error)
+	// Ban adds id to the ban list
+	Ban(id string) error
+}
+
+// ReportRepository is the storage contract for user reports
+type ReportRepository interface {
+	// Exists reports whether userID already reported reportID
+	Exists(userID, reportID string) (bool, error)
+	// Add records that userID reported reportID
+	Add(userID, reportID string) error
+}
+
+// SQLiteBanRepository implements BanRepository on top of the ban table
+type SQLiteBanRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteBanRepository wraps an open database handle
+func NewSQLiteBanRepository(db *sql.DB) *SQLiteBanRepository {
+	return &SQLiteBanRepository{db: db}
+}
+
+// IsBanned checks if a user id is in the ban table
+func (s *SQLiteBanRepository) IsBanned(id string) (bool, error) {
+	var exists string
+	err := s.db.QueryRow("SELECT id FROM ban WHERE id=?", id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Ban inserts id into the ban table
+func (s *SQLiteBanRepository) Ban(id string) error {
+	_, err := s.db.Exec("INSERT INTO ban (id) VALUES (?)", id)
+	return err
+}
+
+// SQLiteReportRepository implements ReportRepository on top of the report table
+type SQLiteReportRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteReportRepository wraps an open database handle
+func NewSQLiteReportRepository(db *sql.DB) *SQLiteReportRepository {
+	return &SQLiteReportRepository{db: db}
+}
+
+// Exists checks whether the userid and reportid pair is already stored
+func (s *SQLiteReportRepository) Exists(userID, reportID string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT reportcount FROM report WHERE userid=? AND reportid=?", userID, reportID).Scan(&count)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Add inserts a report row
+func (s *SQLiteReportRepository) Add(userID, reportID string) error {
+	_, err := s.db.Exec("INSERT INTO report (userid, reportid) VALUES (?, ?)", userID, reportID)
+	return err
+}
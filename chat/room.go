@@ -0,0 +1,418 @@
+package chat
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gosse/audit"
+	"gosse/dbutil"
+)
+
+// DefaultRoom is the room of requests that name none and of messages sent
+// before rooms existed
+const DefaultRoom = "general"
+
+// DefaultRooms are created with the room table
+var DefaultRooms = []Room{
+	{ID: DefaultRoom, Name: "General", SortOrder: 0},
+	{ID: "2d", Name: "2D", SortOrder: 1},
+	{ID: "3d", Name: "3D", SortOrder: 2},
+	{ID: "thai", Name: "Thai lottery", SortOrder: 3},
+}
+
+var roomIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// ValidRoomID reports whether id can name a room: lower case letters, digits
+// and dashes, up to 32 characters
+func ValidRoomID(id string) bool {
+	return roomIDPattern.MatchString(id)
+}
+
+// Room is a chat channel with its own history, viewers and moderators.
+// SlowMode is the least number of seconds between two messages of one user,
+// 0 when off. Members is the number of connected viewers and is not stored.
+type Room struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	SortOrder   int      `json:"sort_order"`
+	SlowMode    int      `json:"slow_mode"`
+	Moderators  []string `json:"moderators,omitempty"`
+	Members     int      `json:"members"`
+	CreatedAt   string   `json:"created_at"`
+}
+
+// InitRoomTables creates the chat_room and chat_room_moderator tables and the DefaultRooms
+func InitRoomTables(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS chat_room (
+        id TEXT PRIMARY KEY,
+        name TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        sort_order INTEGER NOT NULL DEFAULT 0,
+        created_at TEXT NOT NULL
+    );`,
+		`CREATE TABLE IF NOT EXISTS chat_room_moderator (
+        room TEXT NOT NULL,
+        user_id TEXT NOT NULL,
+        PRIMARY KEY (room, user_id)
+    );`,
+	}
+	for _, q := range stmts {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+	if err := dbutil.AddColumn(db, "chat_room", "slow_mode", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	now := time.Now().Format(time.RFC3339)
+	for _, room := range DefaultRooms {
+		if _, err := db.Exec(`INSERT OR IGNORE INTO chat_room (id, name, description, sort_order, created_at) VALUES (?, ?, ?, ?, ?)`,
+			room.ID, room.Name, room.Description, room.SortOrder, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RoomRepository is the storage contract for chat rooms and their moderators
+type RoomRepository interface {
+	// Rooms returns every room with its moderators, in sort order
+	Rooms() ([]Room, error)
+	// Room returns one room with its moderators; ok is false when it does not exist
+	Room(id string) (room Room, ok bool, err error)
+	// SaveRoom creates a room or updates its name, description and sort order
+	SaveRoom(room Room) (Room, error)
+	// SetModerator makes userID a moderator of room, or with on false no longer one
+	SetModerator(room, userID string, on bool) error
+	// SetSlowMode sets the slow mode interval of room in seconds; 0 turns it off
+	SetSlowMode(room string, seconds int) error
+}
+
+// SQLiteRoomRepository implements RoomRepository on top of the chat_room tables
+type SQLiteRoomRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRoomRepository wraps an open database handle
+func NewSQLiteRoomRepository(db *sql.DB) *SQLiteRoomRepository {
+	return &SQLiteRoomRepository{db: db}
+}
+
+// Rooms reads all rooms and attaches the moderators
+func (s *SQLiteRoomRepository) Rooms() ([]Room, error) {
+	rows, err := s.db.Query(`SELECT id, name, description, sort_order, slow_mode, created_at FROM chat_room ORDER BY sort_order, id`)
+	if err != nil {
+		return nil, err
+	}
+	all := []Room{}
+	for rows.Next() {
+		var room Room
+		if err := rows.Scan(&room.ID, &room.Name, &room.Description, &room.SortOrder, &room.SlowMode, &room.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		all = append(all, room)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range all {
+		if all[i].Moderators, err = s.moderators(all[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return all, nil
+}
+
+// Room looks up a room by id
+func (s *SQLiteRoomRepository) Room(id string) (Room, bool, error) {
+	var room Room
+	err := s.db.QueryRow(`SELECT id, name, description, sort_order, slow_mode, created_at FROM chat_room WHERE id=?`, id).
+		Scan(&room.ID, &room.Name, &room.Description, &room.SortOrder, &room.SlowMode, &room.CreatedAt)
+	if err == sql.ErrNoRows {
+		return Room{}, false, nil
+	}
+	if err != nil {
+		return Room{}, false, err
+	}
+	room.Moderators, err = s.moderators(id)
+	return room, err == nil, err
+}
+
+func (s *SQLiteRoomRepository) moderators(room string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT user_id FROM chat_room_moderator WHERE room=? ORDER BY user_id`, room)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// SaveRoom upserts the room row, keeping the creation time of an existing room
+func (s *SQLiteRoomRepository) SaveRoom(room Room) (Room, error) {
+	_, err := s.db.Exec(`INSERT INTO chat_room (id, name, description, sort_order, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET name=excluded.name, description=excluded.description, sort_order=excluded.sort_order`,
+		room.ID, room.Name, room.Description, room.SortOrder, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return room, err
+	}
+	saved, _, err := s.Room(room.ID)
+	return saved, err
+}
+
+// SetModerator inserts or deletes the moderator row
+func (s *SQLiteRoomRepository) SetModerator(room, userID string, on bool) error {
+	var err error
+	if on {
+		_, err = s.db.Exec(`INSERT OR IGNORE INTO chat_room_moderator (room, user_id) VALUES (?, ?)`, room, userID)
+	} else {
+		_, err = s.db.Exec(`DELETE FROM chat_room_moderator WHERE room=? AND user_id=?`, room, userID)
+	}
+	return err
+}
+
+// SetSlowMode updates the slow_mode column
+func (s *SQLiteRoomRepository) SetSlowMode(room string, seconds int) error {
+	_, err := s.db.Exec(`UPDATE chat_room SET slow_mode=? WHERE id=?`, seconds, room)
+	return err
+}
+
+// IsModerator reports whether userID moderates room
+func (r Room) IsModerator(userID string) bool {
+	for _, m := range r.Moderators {
+		if m == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// roomParam resolves the room query parameter, writing the error response
+// when the room does not exist
+func roomParam(w http.ResponseWriter, r *http.Request, rooms RoomRepository) (Room, bool) {
+	id := strings.TrimSpace(r.URL.Query().Get("room"))
+	if id == "" {
+		id = DefaultRoom
+	}
+	room, ok, err := rooms.Room(id)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		return Room{}, false
+	}
+	if !ok {
+		http.Error(w, "Unknown room "+id, http.StatusNotFound)
+		return Room{}, false
+	}
+	return room, true
+}
+
+// RoomsHandler handles GET /chat/rooms and lists the rooms with their live
+// member counts. Moderators are not listed publicly.
+func RoomsHandler(rooms RoomRepository) http.HandlerFunc {
+	return listRooms(rooms, false)
+}
+
+// AdminRoomsHandler handles GET /admin/chat/rooms and lists the rooms with
+// their moderators and live member counts
+func AdminRoomsHandler(rooms RoomRepository) http.HandlerFunc {
+	return listRooms(rooms, true)
+}
+
+func listRooms(rooms RoomRepository, moderators bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all, err := rooms.Rooms()
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range all {
+			all[i].Members = Members(all[i].ID)
+			if !moderators {
+				all[i].Moderators = nil
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(all)
+	}
+}
+
+// SaveRoomHandler handles POST /admin/chat/rooms/save with
+// {"id": "mandalay", "name": "Mandalay", "description": "...", "sort_order": 10}
+// to create a room or rename an existing one
+func SaveRoomHandler(rooms RoomRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req Room
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.ID, req.Name = strings.TrimSpace(req.ID), strings.TrimSpace(req.Name)
+		if !ValidRoomID(req.ID) {
+			http.Error(w, "id must be 1 to 32 lower case letters, digits or dashes", http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		audit.SetTarget(r, req.ID)
+		room, err := rooms.SaveRoom(req)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		room.Members = Members(room.ID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "saved", "room": room})
+	}
+}
+
+// ModeratorHandler handles POST /admin/chat/rooms/moderator with
+// {"room": "2d", "user_id": "...", "moderator": true} to add or, with
+// moderator false, remove a moderator of a room
+func ModeratorHandler(rooms RoomRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Room      string `json:"room"`
+			UserID    string `json:"user_id"`
+			Moderator bool   `json:"moderator"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.UserID = strings.TrimSpace(req.UserID)
+		if req.UserID == "" {
+			http.Error(w, "user_id is required", http.StatusBadRequest)
+			return
+		}
+		_, ok, err := rooms.Room(req.Room)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Unknown room "+req.Room, http.StatusNotFound)
+			return
+		}
+		audit.SetTarget(r, req.Room+"/"+req.UserID)
+		if err := rooms.SetModerator(req.Room, req.UserID, req.Moderator); err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		room, _, err := rooms.Room(req.Room)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "updated", "room": room})
+	}
+}
+
+// MaxSlowMode is the longest slow mode interval, in seconds
+const MaxSlowMode = 3600
+
+// SlowModeHandler handles POST /admin/chat/rooms/slowmode with
+// {"room": "2d", "seconds": 30}: every user but the room's moderators may then
+// send one message per interval there. Moderators are exempt by id, so their
+// ids are kept out of public listings and messages. 0 turns slow mode off. Viewers of the
+// room get a "slow_mode" event with the new interval.
+func SlowModeHandler(rooms RoomRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Room    string `json:"room"`
+			Seconds int    `json:"seconds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Seconds < 0 || req.Seconds > MaxSlowMode {
+			http.Error(w, "seconds must be between 0 and "+strconv.Itoa(MaxSlowMode), http.StatusBadRequest)
+			return
+		}
+		_, ok, err := rooms.Room(req.Room)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Unknown room "+req.Room, http.StatusNotFound)
+			return
+		}
+		audit.SetTarget(r, req.Room)
+		if err := rooms.SetSlowMode(req.Room, req.Seconds); err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		PublishRoomEvent(req.Room, "slow_mode", map[string]any{"room": req.Room, "seconds": req.Seconds})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "updated", "room": req.Room, "slow_mode": req.Seconds})
+	}
+}
+
+// DeleteMessageHandler handles POST /chat/delete?room= with {"message_id": 12}
+// and removes a message from the room's history; viewers get a "delete" event
+// naming it. Chat users are not authenticated, so the route must be wrapped
+// by admin.Authenticator.Require.
+func DeleteMessageHandler(messages MessageRepository, rooms RoomRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		room, ok := roomParam(w, r, rooms)
+		if !ok {
+			return
+		}
+		var req struct {
+			MessageID int64 `json:"message_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		audit.SetTarget(r, room.ID+"/"+strconv.FormatInt(req.MessageID, 10))
+		found, err := messages.Delete(room.ID, req.MessageID)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		PublishRoomEvent(room.ID, "delete", map[string]any{"room": room.ID, "message_id": req.MessageID})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "deleted", "room": room.ID, "message_id": req.MessageID})
+	}
+}
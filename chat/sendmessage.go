@@ -1,61 +1,124 @@
 package chat
 
 import (
-	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
+
+	"gosse/audit"
+	"gosse/user"
 )
 
-// SendMessageHandler returns a handler that stores a message if user not banned
-func SendMessageHandler(db *sql.DB) http.HandlerFunc {
+// SendMessageHandler handles POST /chat/sendmessage?room= with {"id": "...", "message": "..."}.
+// The message of a registered, not banned user is stored in the history of the
+// room, DefaultRoom when none is given, in its canonical form, which is the only
+// form broadcast; other fields are ignored.
+// Senders are rate limited by throttle, per user and per audit.ClientIP, and
+// by the slow mode of the room. A user whose own throttled attempts from one
+// IP reach the throttle's limit is muted from that IP for a while; hitting
+// the IP limit, which users behind one NAT share, never counts. Bans are left
+// to admins.
+func SendMessageHandler(bans BanRepository, messages MessageRepository, users user.Repository, rooms RoomRepository, throttle *Throttle) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		var msg map[string]any
-		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		room, ok := roomParam(w, r, rooms)
+		if !ok {
+			return
+		}
+		var req struct {
+			ID      string `json:"id"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		// Extract user id from several possible keys or query param
-		extractID := func() string {
-			keys := []string{"id", "userId", "userid", "user_id"}
-			for _, k := range keys {
-				if v, ok := msg[k]; ok {
-					if s, ok := v.(string); ok {
-						return strings.TrimSpace(s)
-					}
-				}
-			}
-			qp := strings.TrimSpace(r.URL.Query().Get("id"))
-			return qp
+		id := strings.TrimSpace(req.ID)
+		if id == "" {
+			id = strings.TrimSpace(r.URL.Query().Get("id"))
 		}
-		id := extractID()
 		if id == "" {
 			http.Error(w, "Missing id in message", http.StatusBadRequest)
 			return
 		}
-		if IsBanned(db, id) {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]any{
-				"status":  "banned",
-				"message": "you are banned",
-			})
+		body, err := NormalizeBody(req.Message)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid message: %v (at most %d characters)", err, MaxMessageLength), http.StatusBadRequest)
+			return
+		}
+		banned, err := bans.IsBanned(id)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if banned {
+			writeBanned(w)
+			return
+		}
+		ip := audit.ClientIP(r)
+		if left := throttle.Muted(id, ip); left > 0 {
+			writeThrottled(w, "muted", "muted for sending too fast", left)
+			return
+		}
+		// throttled attempts of the user count toward an automatic mute
+		throttled := func(strike bool, status, message string, retry time.Duration) {
+			if strike && throttle.Strike(id, ip) {
+				writeThrottled(w, "muted", "muted for sending too fast", throttle.MuteFor)
+				return
+			}
+			writeThrottled(w, status, message, retry)
+		}
+		if ok, scope, retry := throttle.Allow(id, ip); !ok {
+			throttled(scope == "user", "rate limited", "too many messages from this "+scope, retry)
+			return
+		}
+		account, ok, err := users.Get(id)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Unknown user "+id, http.StatusNotFound)
 			return
 		}
-		// Attach normalized id back into message to ensure consistency
-		msg["id"] = id
+		if room.SlowMode > 0 && !room.IsModerator(id) {
+			last, err := messages.LastSent(room.ID, id)
+			if err != nil {
+				http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if wait := time.Until(last.Add(time.Duration(room.SlowMode) * time.Second)); wait > 0 {
+				throttled(true, "slow mode", fmt.Sprintf("slow mode allows one message every %d seconds", room.SlowMode), wait)
+				return
+			}
+		}
+		msg := Message{Room: room.ID, ID: id, Name: account.Name, ProfilePic: account.ProfilePic, Message: body}
 		// First store (with dedup) then publish only if actually stored
-		if added := AddChatMessage(msg); added {
-			publish(msg)
+		saved, added, err := messages.Add(msg)
+		if err != nil {
+			http.Error(w, "Database insert error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if added {
+			publish(room.ID, saved)
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]any{
 			"status":  "success",
-			"message": msg,
+			"message": saved,
 		})
 	}
 }
+
+func writeBanned(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":  "banned",
+		"message": "you are banned",
+	})
+}
@@ -0,0 +1,194 @@
+// Package check matches ticket numbers against the stored results of every game.
+package check
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gosse/dates"
+	"gosse/lottosociety"
+	"gosse/threedata"
+	"gosse/twoddata"
+	"net/http"
+	"strings"
+)
+
+// Games accepted by the checker
+const (
+	Game2D   = "2d"
+	Game3D   = "3d"
+	GameThai = "thai"
+)
+
+// MaxNumbers caps how many tickets one request may check
+const MaxNumbers = 100
+
+// Request is the body of POST /check; GET takes the same fields as query
+// parameters with numbers separated by commas
+type Request struct {
+	Game    string   `json:"game"`
+	Date    string   `json:"date"`
+	Numbers []string `json:"numbers"`
+}
+
+// Result is the outcome for one ticket number. Matches holds the 2D sessions
+// (mresult, eresult), the 3D match type (straight, box) or the Thai prize tiers won.
+type Result struct {
+	Number  string   `json:"number"`
+	Won     bool     `json:"won"`
+	Matches []string `json:"matches"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Response is returned by the checker
+type Response struct {
+	Status  string      `json:"status"`
+	Game    string      `json:"game"`
+	Date    string      `json:"date"`
+	Draw    interface{} `json:"draw"`
+	Results []Result    `json:"results"`
+}
+
+// Checker looks up results in the game repositories
+type Checker struct {
+	Twod   twoddata.Repository
+	Threed threedata.Repository
+	Lotto  lottosociety.Repository
+}
+
+// NewChecker builds a Checker over the three result repositories
+func NewChecker(twod twoddata.Repository, threed threedata.Repository, lotto lottosociety.Repository) *Checker {
+	return &Checker{Twod: twod, Threed: threed, Lotto: lotto}
+}
+
+// matcher returns the matches of one ticket, or an error for malformed tickets
+type matcher func(ticket string) ([]string, error)
+
+// draw loads the result of game on date; ok is false when none is stored
+func (c *Checker) draw(game, date string) (draw interface{}, match matcher, ok bool, err error) {
+	switch game {
+	case Game2D:
+		rows, err := c.Twod.Between(date, date)
+		if err != nil || len(rows) == 0 {
+			return nil, nil, false, err
+		}
+		d := rows[len(rows)-1]
+		return d, func(t string) ([]string, error) {
+			if !twoddata.ValidResult(t) {
+				return nil, fmt.Errorf("2D numbers have 2 digits")
+			}
+			return d.Match(t), nil
+		}, true, nil
+	case Game3D:
+		d, err := c.Threed.Get(date)
+		if errors.Is(err, threedata.ErrNotFound) {
+			return nil, nil, false, nil
+		}
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return d, func(t string) ([]string, error) {
+			if !threedata.ValidResult(t) {
+				return nil, fmt.Errorf("3D numbers have 3 digits")
+			}
+			if m := threedata.MatchTicket(d.Result, t); m != "" {
+				return []string{m}, nil
+			}
+			return nil, nil
+		}, true, nil
+	case GameThai:
+		rows, err := c.Lotto.ByDate(date)
+		if err != nil || len(rows) == 0 {
+			return nil, nil, false, err
+		}
+		l := rows[0]
+		var p lottosociety.Prizes
+		if l.Prizes != nil {
+			p = *l.Prizes
+		}
+		return l, func(t string) ([]string, error) {
+			if !lottosociety.ValidTicket(t) {
+				return nil, fmt.Errorf("Thai lottery tickets have 6 digits")
+			}
+			return p.Match(t), nil
+		}, true, nil
+	}
+	return nil, nil, false, fmt.Errorf("unknown game %q", game)
+}
+
+// CheckHandler handles GET /check?game=2d&date=2025-08-15&numbers=25,20 and
+// POST /check with a Request body; game is 2d, 3d or thai
+func CheckHandler(c *Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		switch r.Method {
+		case http.MethodGet:
+			q := r.URL.Query()
+			req.Game = q.Get("game")
+			req.Date = q.Get("date")
+			for _, v := range q["numbers"] {
+				req.Numbers = append(req.Numbers, strings.Split(v, ",")...)
+			}
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		game := strings.ToLower(strings.TrimSpace(req.Game))
+		if game == "lotto" {
+			game = GameThai
+		}
+		if game != Game2D && game != Game3D && game != GameThai {
+			http.Error(w, "game must be 2d, 3d or thai", http.StatusBadRequest)
+			return
+		}
+		date, err := dates.Normalize(req.Date)
+		if err != nil {
+			http.Error(w, "Invalid date value", http.StatusBadRequest)
+			return
+		}
+		var numbers []string
+		for _, n := range req.Numbers {
+			if n = strings.TrimSpace(n); n != "" {
+				numbers = append(numbers, n)
+			}
+		}
+		if len(numbers) == 0 {
+			http.Error(w, "numbers are required", http.StatusBadRequest)
+			return
+		}
+		if len(numbers) > MaxNumbers {
+			http.Error(w, fmt.Sprintf("at most %d numbers per request", MaxNumbers), http.StatusBadRequest)
+			return
+		}
+
+		draw, match, ok, err := c.draw(game, date)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"status": "not_found", "game": game, "date": date})
+			return
+		}
+		resp := Response{Status: "ok", Game: game, Date: date, Draw: draw, Results: make([]Result, 0, len(numbers))}
+		for _, n := range numbers {
+			res := Result{Number: n, Matches: []string{}}
+			if m, err := match(n); err != nil {
+				res.Error = err.Error()
+			} else if len(m) > 0 {
+				res.Matches = m
+				res.Won = true
+			}
+			resp.Results = append(resp.Results, res)
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,111 @@
+package check_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gosse/check"
+	"gosse/lottosociety"
+	"gosse/storage"
+	"gosse/threedata"
+	"gosse/twoddata"
+)
+
+func newHandler(t *testing.T) http.HandlerFunc {
+	t.Helper()
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return check.CheckHandler(check.NewChecker(
+		twoddata.NewSQLiteRepository(db),
+		threedata.NewSQLiteRepository(db),
+		lottosociety.NewSQLiteRepository(db),
+	))
+}
+
+func get(t *testing.T, h http.HandlerFunc, query string) (int, check.Response) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/check?"+query, nil))
+	var resp check.Response
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func matches(resp check.Response) map[string][]string {
+	out := map[string][]string{}
+	for _, r := range resp.Results {
+		out[r.Number] = r.Matches
+	}
+	return out
+}
+
+func TestCheckGames(t *testing.T) {
+	h := newHandler(t)
+	cases := []struct {
+		query string
+		want  map[string][]string
+	}{
+		{"game=2d&date=2025/08/15&numbers=25,20,52", map[string][]string{
+			"25": {"mresult"}, "20": {"eresult"}, "52": {},
+		}},
+		// the evening session of 2025-08-18 is not decided yet
+		{"game=2d&date=2025-08-18&numbers=02,--", map[string][]string{
+			"02": {"mresult"}, "--": {},
+		}},
+		{"game=3d&date=2025-08-16&numbers=447,474,744,123", map[string][]string{
+			"447": {"straight"}, "474": {"box"}, "744": {"box"}, "123": {},
+		}},
+		{"game=thai&date=2025-08-01&numbers=994865,994866,123456,000030,111001", map[string][]string{
+			"994865": {"first", "front3", "back3"},
+			"994866": {"adjacent", "front3"},
+			"123456": {"front3", "second"},
+			"000030": {"back2"},
+			"111001": {"back3"},
+		}},
+	}
+	for _, c := range cases {
+		code, resp := get(t, h, c.query)
+		if code != http.StatusOK {
+			t.Fatalf("%s: status = %d", c.query, code)
+		}
+		if got := matches(resp); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: matches = %v, want %v", c.query, got, c.want)
+		}
+	}
+}
+
+func TestCheckPostAndErrors(t *testing.T) {
+	h := newHandler(t)
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(`{"game":"3d","date":"16.08.2025","numbers":["447","12"]}`)))
+	var resp check.Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Date != "2025-08-16" || !resp.Results[0].Won || resp.Results[1].Error == "" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	for query, want := range map[string]int{
+		"game=4d&date=2025-08-16&numbers=1":    http.StatusBadRequest,
+		"game=3d&date=someday&numbers=123":     http.StatusBadRequest,
+		"game=3d&date=2025-08-16":              http.StatusBadRequest,
+		"game=3d&date=2025-08-17&numbers=123":  http.StatusNotFound,
+		"game=thai&date=2025-08-16&numbers=12": http.StatusNotFound,
+	} {
+		if code, _ := get(t, h, query); code != want {
+			t.Errorf("%s: status = %d, want %d", query, code, want)
+		}
+	}
+}
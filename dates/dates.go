@@ -0,0 +1,196 @@
+// Package dates normalizes the draw dates stored in the result tables.
+//
+// Every result table stores calendar dates as ISO strings (YYYY-MM-DD) in
+// Asia/Yangon semantics, so string order is date order and range queries can
+// use plain comparisons.
+package dates
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layout is the storage format of every date column
+const Layout = "2006-01-02"
+
+// LegacyLayout is the format the history endpoints have always returned dates
+// in; responses keep it unless the client asks for ISO dates
+const LegacyLayout = "2006/01/02"
+
+var (
+	// ErrInvalid is returned for strings that are not a recognizable date
+	ErrInvalid = errors.New("unrecognized date")
+	// ErrAmbiguous is returned for day-first dates that also read as month-first
+	ErrAmbiguous = errors.New("ambiguous date, use YYYY-MM-DD")
+)
+
+var (
+	locOnce sync.Once
+	loc     *time.Location
+)
+
+// Location returns the Asia/Yangon time zone, or a fixed +06:30 zone when the
+// zone database is not available
+func Location() *time.Location {
+	locOnce.Do(func() {
+		l, err := time.LoadLocation("Asia/Yangon")
+		if err != nil {
+			l = time.FixedZone("MMT", 6*3600+30*60)
+		}
+		loc = l
+	})
+	return loc
+}
+
+// dateLayouts are calendar dates without a time zone; they are read as Yangon dates.
+var dateLayouts = []string{
+	Layout,
+	"2006/01/02",
+	"2006.01.02",
+	"2006-1-2",
+	"2006/1/2",
+	"2 January 2006",
+	"2 Jan 2006",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2006/01/02 03:04:05 PM",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"Mon Jan 02 2006",
+}
+
+// dayFirstLayouts follow local usage (15/08/2025 is 15 August). They are only
+// accepted when the day cannot be a month, since 08/03/2025 is 3 August in US usage.
+var dayFirstLayouts = []string{
+	"02/01/2006",
+	"2/1/2006",
+	"02-01-2006",
+	"02.01.2006",
+}
+
+// instantLayouts carry a zone; the instant is converted to Yangon before taking the date
+var instantLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"Mon Jan 02 2006 15:04:05 GMT-0700",
+}
+
+// Parse reads a date in any of the accepted input formats and returns midnight
+// of that day in Yangon. Numeric day-first dates whose day and month could be
+// swapped, like 08/03/2025, fail with ErrAmbiguous.
+func Parse(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, ErrInvalid
+	}
+	for _, layout := range instantLayouts {
+		in := s
+		if strings.HasPrefix(layout, "Mon") {
+			// JavaScript's Date.toString() appends the zone name in parentheses
+			if i := strings.Index(in, " ("); i > 0 {
+				in = in[:i]
+			}
+		}
+		if t, err := time.Parse(layout, in); err == nil {
+			return Day(t), nil
+		}
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, Location()); err == nil {
+			return Day(t), nil
+		}
+	}
+	for _, layout := range dayFirstLayouts {
+		if t, err := time.ParseInLocation(layout, s, Location()); err == nil {
+			if t.Day() <= 12 && t.Day() != int(t.Month()) {
+				return time.Time{}, ErrAmbiguous
+			}
+			return Day(t), nil
+		}
+	}
+	return time.Time{}, ErrInvalid
+}
+
+// Normalize returns s in the storage Layout
+func Normalize(s string) (string, error) {
+	t, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(Layout), nil
+}
+
+// Format rewrites a stored date into layout; values that are not stored dates
+// are returned unchanged
+func Format(stored, layout string) string {
+	if layout == Layout {
+		return stored
+	}
+	t, err := time.Parse(Layout, stored)
+	if err != nil {
+		return stored
+	}
+	return t.Format(layout)
+}
+
+// OutputLayout reads the optional date_format parameter of r: responses use
+// LegacyLayout by default and Layout for date_format=iso
+func OutputLayout(r *http.Request) (string, error) {
+	switch r.URL.Query().Get("date_format") {
+	case "", "legacy":
+		return LegacyLayout, nil
+	case "iso":
+		return Layout, nil
+	}
+	return "", errors.New("invalid date_format, use iso or legacy")
+}
+
+// Day returns midnight in Yangon of the day t falls on there
+func Day(t time.Time) time.Time {
+	y, m, d := t.In(Location()).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, Location())
+}
+
+// Today returns today's date in Yangon in the storage Layout
+func Today() string {
+	return time.Now().In(Location()).Format(Layout)
+}
+
+// ParseRange reads the optional from and to query parameters of r as an
+// inclusive date range; empty bounds are returned as ""
+func ParseRange(r *http.Request) (from, to string, err error) {
+	q := r.URL.Query()
+	if v := q.Get("from"); v != "" {
+		if from, err = Normalize(v); err != nil {
+			return "", "", errors.New("invalid from date")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = Normalize(v); err != nil {
+			return "", "", errors.New("invalid to date")
+		}
+	}
+	if from != "" && to != "" && from > to {
+		return "", "", errors.New("from is after to")
+	}
+	return from, to, nil
+}
+
+// RangeClause returns an SQL condition and arguments restricting column to the
+// inclusive range; it is empty when both bounds are open
+func RangeClause(column, from, to string) (string, []any) {
+	var conds []string
+	var args []any
+	if from != "" {
+		conds = append(conds, column+" >= ?")
+		args = append(args, from)
+	}
+	if to != "" {
+		conds = append(conds, column+" <= ?")
+		args = append(args, to)
+	}
+	return strings.Join(conds, " AND "), args
+}
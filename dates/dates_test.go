@@ -0,0 +1,107 @@
+package dates_test
+
+import (
+	"testing"
+
+	"gosse/dates"
+	"gosse/storage"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"2025-08-15":             "2025-08-15",
+		"2025/08/15":             "2025-08-15",
+		"2025/8/5":               "2025-08-05",
+		"15/08/2025":             "2025-08-15",
+		"15.08.2025":             "2025-08-15",
+		"05/05/2025":             "2025-05-05",
+		"15 August 2025":         "2025-08-15",
+		"Aug 15, 2025":           "2025-08-15",
+		"2025/08/15 04:30:00 PM": "2025-08-15",
+		// UTC evening is already the next day in Yangon
+		"2025-08-14T18:00:00Z":                             "2025-08-15",
+		"Fri Aug 15 2025 00:10:00 GMT+0630 (Myanmar Time)": "2025-08-15",
+	}
+	for in, want := range cases {
+		got, err := dates.Normalize(in)
+		if err != nil || got != want {
+			t.Errorf("Normalize(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "Invalid Date", "2025-13-01", "tomorrow", "08/13/2025"} {
+		if _, err := dates.Normalize(in); err == nil {
+			t.Errorf("Normalize(%q) succeeded, want error", in)
+		}
+	}
+	// 08/03/2025 is 8 March day-first but 3 August in US usage
+	for _, in := range []string{"08/03/2025", "01/09/2025"} {
+		if _, err := dates.Normalize(in); err != dates.ErrAmbiguous {
+			t.Errorf("Normalize(%q) error = %v, want ErrAmbiguous", in, err)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if got := dates.Format("2025-08-15", dates.LegacyLayout); got != "2025/08/15" {
+		t.Errorf("legacy format = %q", got)
+	}
+	if got := dates.Format("2025-08-15", dates.Layout); got != "2025-08-15" {
+		t.Errorf("iso format = %q", got)
+	}
+	if got := dates.Format("garbage", dates.LegacyLayout); got != "garbage" {
+		t.Errorf("unparsed value = %q", got)
+	}
+}
+
+func TestMigrateColumn(t *testing.T) {
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, d := range []string{"2025/08/16", "2025-08-16", "2025.09.01", "01.09.2025", "garbage"} {
+		if _, err := db.Exec("INSERT INTO lottosociety (date, fnum, snum) VALUES (?, '', '')", d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed, skipped, conflicts, err := dates.MigrateColumn(db, "lottosociety", "date")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 2 || skipped != 2 || len(conflicts) != 0 {
+		t.Errorf("changed, skipped, conflicts = %d, %d, %v; want 2, 2, none", changed, skipped, conflicts)
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM lottosociety WHERE date IN ('2025-08-16', '2025-09-01')").Scan(&n)
+	if n != 3 {
+		t.Errorf("%d migrated rows, want 3", n)
+	}
+}
+
+func TestMigrateColumnConflicts(t *testing.T) {
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// threeddata has a unique date index
+	for _, row := range [][2]string{{"2025-08-16", "111"}, {"2025/08/16", "222"}, {"2025/09/01", "333"}} {
+		if _, err := db.Exec("INSERT INTO threeddata (date, result) VALUES (?, ?)", row[0], row[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed, _, conflicts, err := dates.MigrateColumn(db, "threeddata", "date")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 1 || len(conflicts) != 1 || conflicts[0].Value != "2025/08/16" || conflicts[0].Date != "2025-08-16" {
+		t.Fatalf("changed = %d, conflicts = %+v", changed, conflicts)
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM threeddata").Scan(&n)
+	if n != 3 {
+		t.Errorf("%d rows left, want all 3", n)
+	}
+}
@@ -0,0 +1,76 @@
+package dates
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Conflict is a row whose normalized date is already held by another row of
+// a unique column; the row is left as it was
+type Conflict struct {
+	RowID int64
+	Value string
+	Date  string
+}
+
+// MigrateColumn rewrites every parseable value of table.column into Layout,
+// in rowid order. Values that cannot be parsed are left untouched and counted
+// in skipped. A row whose new value would break a unique constraint is left
+// untouched too and reported in conflicts, so no row is ever replaced.
+func MigrateColumn(db *sql.DB, table, column string) (changed, skipped int, conflicts []Conflict, err error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT rowid, %s FROM %s WHERE %s IS NOT NULL AND %s != '' ORDER BY rowid", column, table, column, column))
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	type update struct {
+		id       int64
+		old, new string
+	}
+	var updates []update
+	for rows.Next() {
+		var id int64
+		var v string
+		if err := rows.Scan(&id, &v); err != nil {
+			rows.Close()
+			return 0, 0, nil, err
+		}
+		norm, err := Normalize(v)
+		if err != nil {
+			skipped++
+			continue
+		}
+		if norm != v {
+			updates = append(updates, update{id, v, norm})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, nil, err
+	}
+	if len(updates) == 0 {
+		return 0, skipped, nil, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer tx.Rollback()
+	stmt := fmt.Sprintf("UPDATE %s SET %s=? WHERE rowid=?", table, column)
+	for _, u := range updates {
+		if _, err := tx.Exec(stmt, u.new, u.id); err != nil {
+			// A failed statement is undone on its own; the transaction goes on
+			var se sqlite3.Error
+			if errors.As(err, &se) && (se.ExtendedCode == sqlite3.ErrConstraintUnique || se.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+				conflicts = append(conflicts, Conflict{RowID: u.id, Value: u.old, Date: u.new})
+				continue
+			}
+			return 0, 0, nil, fmt.Errorf("migrate %s.%s row %d: %w", table, column, u.id, err)
+		}
+		changed++
+	}
+	return changed, skipped, conflicts, tx.Commit()
+}
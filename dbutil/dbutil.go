@@ -0,0 +1,44 @@
+// Package dbutil holds small schema helpers shared by the table packages.
+package dbutil
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// HasColumn reports whether table already has the named column
+func HasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			ctype     string
+			notnull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// AddColumn adds column to table with the given declaration unless it already exists.
+// It lets older database files pick up new columns on startup.
+func AddColumn(db *sql.DB, table, column, decl string) error {
+	ok, err := HasColumn(db, table, column)
+	if err != nil || ok {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
@@ -2,75 +2,338 @@ package futurepaper
 
 import (
 	"encoding/json"
-	"fmt"
-	"io"
+	"gosse/audit"
+	"gosse/blob"
+	"gosse/dates"
+	"gosse/imaging"
+	"gosse/upload"
 	"net/http"
-	"os"
+	"path"
+	"strconv"
 	"time"
 )
 
-// UploadPaperImageHandler handles POST /futurepaper/uploadimage to upload an image to futurepaper/images/
-func UploadPaperImageHandler(w http.ResponseWriter, r *http.Request) {
-	defer func() {
-		if rec := recover(); rec != nil {
-			http.Error(w, "Internal server error (panic)", http.StatusInternalServerError)
-		}
-	}()
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// UploadPaperImageHandler handles POST /futurepaper/addpaper with the raw image as body.
+// The image is stored content-addressed in blobs, below ImageDir/BlobDir, and registered
+// in the catalog. High quality uploads are also rendered in every VariantSpecs size.
+// Query parameters: category (daily, weekly or calendar; default daily), quality
+// (high or low; default high), title, issue_date (default today), sort_order,
+// visible (default true), publish_at (default now) and expires_at as RFC 3339 times or
+// dates, and paper_id to add another quality to an existing paper. New papers that
+// look like a stored one are refused with 409 and the matches unless force=true.
+// The body is checked and charged to the uploader's quota by up; pub is woken to
+// announce the paper when it is due.
+func UploadPaperImageHandler(repo Repository, up *upload.Uploader, blobs *blob.Store, pub *Publisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				http.Error(w, "Internal server error (panic)", http.StatusInternalServerError)
+			}
+		}()
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
-	// Create images folder if not exists
-	if err := os.MkdirAll("futurepaper/images", 0755); err != nil {
-		http.Error(w, "Failed to create images dir: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
+		q := r.URL.Query()
+		quality := q.Get("quality")
+		if quality == "" {
+			quality = QualityHigh
+		}
+		if !ValidQuality(quality) {
+			http.Error(w, "Invalid quality parameter", http.StatusBadRequest)
+			return
+		}
+		var existing *Paper
+		p := Paper{Category: q.Get("category"), Title: q.Get("title"), Visible: true}
+		if v := q.Get("paper_id"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "Invalid paper_id parameter", http.StatusBadRequest)
+				return
+			}
+			found, err := repo.Get(id)
+			if err == ErrNotFound {
+				http.Error(w, "Paper not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			existing, p = &found, found
+		} else {
+			if p.Category == "" {
+				p.Category = CategoryDaily
+			}
+			if !ValidCategory(p.Category) {
+				http.Error(w, "Invalid category parameter", http.StatusBadRequest)
+				return
+			}
+			p.IssueDate = dates.Today()
+			if v := q.Get("issue_date"); v != "" {
+				date, err := dates.Normalize(v)
+				if err != nil {
+					http.Error(w, "Invalid issue_date parameter", http.StatusBadRequest)
+					return
+				}
+				p.IssueDate = date
+			}
+			if v := q.Get("sort_order"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					http.Error(w, "Invalid sort_order parameter", http.StatusBadRequest)
+					return
+				}
+				p.SortOrder = n
+			}
+			if v := q.Get("visible"); v != "" {
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					http.Error(w, "Invalid visible parameter", http.StatusBadRequest)
+					return
+				}
+				p.Visible = b
+			}
+			publishAt, expiresAt, ok := parseSchedule(w, q.Get("publish_at"), q.Get("expires_at"))
+			if !ok {
+				return
+			}
+			if publishAt.IsZero() {
+				publishAt = time.Now()
+			}
+			p.PublishAt = formatTime(publishAt)
+			p.ExpiresAt = formatTime(expiresAt)
+			if !expiresAt.IsZero() && !expiresAt.After(publishAt) {
+				http.Error(w, "expires_at must be after publish_at", http.StatusBadRequest)
+				return
+			}
+		}
 
-	// Read all image data from body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read image: "+err.Error(), http.StatusBadRequest)
-		return
-	}
+		file, err := up.Receive(w, r)
+		if err != nil {
+			upload.WriteError(w, err)
+			return
+		}
 
-	// Use extension from Content-Type if possible, else default to .png
-	ext := ".png"
-	switch r.Header.Get("Content-Type") {
-	case "image/jpeg":
-		ext = ".jpg"
-	case "image/png":
-		ext = ".png"
-	case "image/gif":
-		ext = ".gif"
-	}
+		// Refuse near-duplicates of stored papers unless the admin insists
+		sum := imaging.DHash(file.Image)
+		hash := imaging.FormatHash(sum)
+		var dups []Duplicate
+		if existing == nil {
+			if dups, err = FindDuplicates(repo, sum, DuplicateDistance); err != nil {
+				file.Release()
+				http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if len(dups) > 0 && q.Get("force") != "true" {
+				file.Release()
+				writeDuplicates(w, r, dups)
+				return
+			}
+			p.Hash = hash
+		}
 
-	fname := fmt.Sprintf("%f%s", float64(time.Now().UnixNano())/1e9, ext)
-	fpath := "futurepaper/images/" + fname
-	out, err := os.Create(fpath)
-	if err != nil {
-		http.Error(w, "Failed to save image: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer out.Close()
-	_, err = out.Write(body)
-	if err != nil {
-		http.Error(w, "Failed to write image: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
+		// Strip metadata and render the smaller variants of originals
+		var specs []imaging.Spec
+		if quality == QualityHigh {
+			specs = VariantSpecs
+		}
+		img, err := imaging.ProcessDecoded(file.Data, file.Image, file.Format, specs)
+		if err != nil {
+			file.Release()
+			http.Error(w, "Invalid image: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Files are named by content, so re-uploading an image stores it once
+		files := map[string][]byte{quality: img.Original}
+		exts := map[string]string{quality: imaging.Ext(img.Format)}
+		for _, v := range img.Variants {
+			files[v.Spec.Name], exts[v.Spec.Name] = v.Data, ".jpg"
+		}
+		variants := map[string]string{}
+		for name, data := range files {
+			stored, _, err := blobs.Put(data, exts[name])
+			if err != nil {
+				file.Release()
+				http.Error(w, "Failed to write image: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			variants[name] = path.Join(BlobDir, stored)
+		}
+		rel := variants[quality]
+
+		if existing != nil {
+			for name, v := range variants {
+				if err = repo.SetVariant(p.ID, name, v); err != nil {
+					break
+				}
+				p.Variants[name] = v
+			}
+			if err == nil && quality == QualityHigh {
+				p.Hash = hash
+				err = repo.SetHash(p.ID, hash)
+			}
+		} else {
+			if p.Title == "" {
+				p.Title = p.Category + " " + p.IssueDate
+			}
+			p.Variants = variants
+			p, err = repo.Create(p)
+		}
+		if err != nil {
+			// the stored files are unreferenced now and left to the blob collector
+			file.Release()
+			http.Error(w, "Database insert error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		audit.SetTarget(r, strconv.Itoa(p.ID)+"/"+rel)
+		pub.Wake()
 
-	scheme := "http"
-	if r.TLS != nil {
-		scheme = "https"
+		w.Header().Set("Content-Type", "application/json")
+		resp := map[string]interface{}{
+			"status":    "success",
+			"imagename": path.Base(rel),
+			"url":       imageBaseURL(r) + rel,
+			"paper":     p,
+		}
+		if len(dups) > 0 {
+			// forced uploads are flagged with what they resemble
+			resp["duplicates"] = duplicateURLs(r, dups)
+		}
+		json.NewEncoder(w).Encode(resp)
 	}
-	host := r.Host
-	relUrl := "/futurepaper/images/" + fname
-	fullUrl := scheme + "://" + host + relUrl
+}
 
+// writeDuplicates refuses an upload that resembles stored papers
+func writeDuplicates(w http.ResponseWriter, r *http.Request, dups []Duplicate) {
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":    "success",
-		"imagename": fname,
-		"url":       fullUrl,
+		"status":     "duplicate",
+		"message":    "Image resembles existing papers; upload again with force=true to keep it",
+		"duplicates": duplicateURLs(r, dups),
 	})
 }
+
+func duplicateURLs(r *http.Request, dups []Duplicate) []Duplicate {
+	base := imageBaseURL(r)
+	out := make([]Duplicate, len(dups))
+	for i, d := range dups {
+		out[i] = Duplicate{Paper: d.Paper.WithURLs(base), Distance: d.Distance}
+	}
+	return out
+}
+
+// UpdatePaperHandler handles POST /futurepaper/update?id=... with any of category,
+// title, issue_date, sort_order, visible, publish_at and expires_at to edit a
+// catalog entry; an empty publish_at or expires_at clears it
+func UpdatePaperHandler(repo Repository, pub *Publisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Missing or invalid id parameter", http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Category  *string `json:"category"`
+			Title     *string `json:"title"`
+			IssueDate *string `json:"issue_date"`
+			SortOrder *int    `json:"sort_order"`
+			Visible   *bool   `json:"visible"`
+			PublishAt *string `json:"publish_at"`
+			ExpiresAt *string `json:"expires_at"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		audit.SetTarget(r, strconv.Itoa(id))
+		p, err := repo.Get(id)
+		if err == ErrNotFound {
+			http.Error(w, "Paper not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if req.Category != nil {
+			if !ValidCategory(*req.Category) {
+				http.Error(w, "Invalid category", http.StatusBadRequest)
+				return
+			}
+			p.Category = *req.Category
+		}
+		if req.Title != nil {
+			p.Title = *req.Title
+		}
+		if req.IssueDate != nil {
+			date, err := dates.Normalize(*req.IssueDate)
+			if err != nil {
+				http.Error(w, "Invalid issue_date", http.StatusBadRequest)
+				return
+			}
+			p.IssueDate = date
+		}
+		if req.SortOrder != nil {
+			p.SortOrder = *req.SortOrder
+		}
+		if req.Visible != nil {
+			p.Visible = *req.Visible
+		}
+		for _, f := range []struct {
+			in   *string
+			out  *string
+			name string
+		}{{req.PublishAt, &p.PublishAt, "publish_at"}, {req.ExpiresAt, &p.ExpiresAt, "expires_at"}} {
+			if f.in == nil {
+				continue
+			}
+			*f.out = ""
+			if *f.in != "" {
+				t, err := ParseTime(*f.in)
+				if err != nil {
+					http.Error(w, "Invalid "+f.name, http.StatusBadRequest)
+					return
+				}
+				*f.out = formatTime(t)
+			}
+		}
+		if p.PublishAt != "" && p.ExpiresAt != "" && p.ExpiresAt <= p.PublishAt {
+			http.Error(w, "expires_at must be after publish_at", http.StatusBadRequest)
+			return
+		}
+		if err := repo.Update(p); err != nil {
+			http.Error(w, "Database update error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		pub.Wake()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "updated", "paper": p})
+	}
+}
+
+// parseSchedule reads optional publish and expiry times; zero times are unset
+func parseSchedule(w http.ResponseWriter, publish, expires string) (publishAt, expiresAt time.Time, ok bool) {
+	var err error
+	if publish != "" {
+		if publishAt, err = ParseTime(publish); err != nil {
+			http.Error(w, "Invalid publish_at parameter", http.StatusBadRequest)
+			return publishAt, expiresAt, false
+		}
+	}
+	if expires != "" {
+		if expiresAt, err = ParseTime(expires); err != nil {
+			http.Error(w, "Invalid expires_at parameter", http.StatusBadRequest)
+			return publishAt, expiresAt, false
+		}
+	}
+	return publishAt, expiresAt, true
+}
@@ -0,0 +1,112 @@
+package futurepaper
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// bundleFile is one image of a bundle
+type bundleFile struct {
+	name string
+	rel  string
+	info os.FileInfo
+}
+
+// BundleHandler handles GET /futurepaper/bundle?category=&quality= and streams
+// the published images of one category as a ZIP archive, in listing order.
+// quality defaults to low. The ETag identifies the set of files, so clients
+// revalidate cheaply and get a new archive whenever the set changes.
+func BundleHandler(repo Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		category, quality := q.Get("category"), q.Get("quality")
+		if quality == "" {
+			quality = QualityLow
+		}
+		if !ValidCategory(category) {
+			http.Error(w, "Invalid category parameter", http.StatusBadRequest)
+			return
+		}
+		if !ValidQuality(quality) {
+			http.Error(w, "Invalid quality parameter", http.StatusBadRequest)
+			return
+		}
+		papers, _, err := repo.List(ListFilter{Category: category, Quality: quality, At: time.Now()})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var files []bundleFile
+		sum := sha256.New()
+		for i, p := range papers {
+			rel := p.Variants[quality]
+			info, err := os.Stat(filepath.Join(ImageDir, filepath.FromSlash(rel)))
+			if err != nil {
+				log.Printf("futurepaper: bundle skips paper %d: %v", p.ID, err)
+				continue
+			}
+			f := bundleFile{name: fmt.Sprintf("%02d-%s", i+1, path.Base(rel)), rel: rel, info: info}
+			files = append(files, f)
+			fmt.Fprintf(sum, "%s\x00%s\x00%d\x00%d\n", f.name, rel, info.Size(), info.ModTime().UnixNano())
+		}
+		if len(files) == 0 {
+			http.Error(w, "No papers in this category", http.StatusNotFound)
+			return
+		}
+		etag := `"` + hex.EncodeToString(sum.Sum(nil))[:32] + `"`
+
+		h := w.Header()
+		h.Set("ETag", etag)
+		h.Set("Cache-Control", "public, no-cache")
+		if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		h.Set("Content-Type", "application/zip")
+		h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="futurepaper-%s-%s.zip"`, category, quality))
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		// JPEGs do not compress further, so entries are stored as they are
+		zw := zip.NewWriter(w)
+		for _, f := range files {
+			if err := addBundleFile(zw, f); err != nil {
+				// the status line is already sent; a truncated archive fails to open
+				log.Printf("futurepaper: bundle %s/%s: %v", category, quality, err)
+				return
+			}
+		}
+		if err := zw.Close(); err != nil {
+			log.Printf("futurepaper: bundle %s/%s: %v", category, quality, err)
+		}
+	}
+}
+
+func addBundleFile(zw *zip.Writer, f bundleFile) error {
+	src, err := os.OpenInRoot(ImageDir, filepath.FromSlash(f.rel))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Store, Modified: f.info.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
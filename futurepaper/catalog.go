@@ -0,0 +1,385 @@
+package futurepaper
+
+import (
+	"database/sql"
+	"errors"
+	"gosse/blob"
+	"gosse/dbutil"
+	"gosse/imaging"
+	"path"
+	"strings"
+	"time"
+)
+
+// Paper categories
+const (
+	CategoryDaily    = "daily"
+	CategoryWeekly   = "weekly"
+	CategoryCalendar = "calendar"
+)
+
+// Categories lists the paper categories in display order
+var Categories = []string{CategoryDaily, CategoryWeekly, CategoryCalendar}
+
+// Image qualities a paper can be stored in
+const (
+	QualityHigh = "high"
+	QualityLow  = "low"
+)
+
+// Qualities lists the stored image qualities
+var Qualities = []string{QualityHigh, QualityLow}
+
+// VariantSpecs are the resized copies rendered from every high quality upload.
+// The low variant replaces the hand-made low tree.
+var VariantSpecs = []imaging.Spec{
+	{Name: QualityLow, Width: 720, Quality: 70},
+	{Name: "thumb", Width: 240, Quality: 60},
+}
+
+// ImageDir is the directory holding every paper image; variant paths are relative to it
+var ImageDir = "futurepaper/images"
+
+// ImagePath is the URL path ImageDir is served under
+const ImagePath = "/futurepaper/images/"
+
+// BlobDir is the directory below ImageDir that uploads are stored in, named by content
+const BlobDir = "blobs"
+
+// BlobRefs lists the blob of every paper variant, hidden papers included
+func BlobRefs(repo Repository) blob.RefSource {
+	return func() ([]string, error) {
+		papers, _, err := repo.List(ListFilter{Hidden: true})
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, p := range papers {
+			for _, v := range p.Variants {
+				if path.Dir(v) == BlobDir {
+					names = append(names, path.Base(v))
+				}
+			}
+		}
+		return names, nil
+	}
+}
+
+// ErrNotFound is returned when a paper does not exist
+var ErrNotFound = errors.New("paper not found")
+
+// Paper is one catalog entry. Variants maps a quality to the image path
+// relative to ImageDir; listings turn the paths into URLs. A paper is listed
+// from PublishAt on and moves to the archive at ExpiresAt; both are RFC 3339
+// times and empty means immediately and never.
+type Paper struct {
+	ID        int    `json:"id"`
+	Category  string `json:"category"`
+	Title     string `json:"title"`
+	IssueDate string `json:"issue_date"`
+	SortOrder int    `json:"sort_order"`
+	Visible   bool   `json:"visible"`
+	PublishAt string `json:"publish_at,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// Hash is the perceptual hash of the uploaded image, see imaging.DHash
+	Hash      string            `json:"hash,omitempty"`
+	Variants  map[string]string `json:"variants"`
+	CreatedAt string            `json:"created_at"`
+}
+
+// ListFilter narrows List; empty fields match everything
+type ListFilter struct {
+	Category string
+	// Quality only returns papers that have an image in this quality
+	Quality string
+	// Hidden includes papers that are not visible
+	Hidden bool
+	// At only returns papers that are published and not expired at that time;
+	// the zero time disables the schedule check
+	At time.Time
+	// Archived returns the papers that expired before At instead
+	Archived bool
+	Limit    int
+	Offset   int
+}
+
+// Repository is the storage contract for the paper catalog
+type Repository interface {
+	// List returns one page of matching papers ordered by sort order, then
+	// newest issue first, along with the number of matching papers
+	List(f ListFilter) ([]Paper, int, error)
+	// Get returns one paper with its variants
+	Get(id int) (Paper, error)
+	// Create stores p and its variants and returns it with its ID
+	Create(p Paper) (Paper, error)
+	// Update overwrites the metadata of p.ID; variants are left alone
+	Update(p Paper) error
+	// SetVariant stores the image path of one quality of a paper
+	SetVariant(id int, quality, path string) error
+	// HasPath reports whether any variant points at path
+	HasPath(path string) (bool, error)
+	// Due returns the visible, unexpired papers whose publish time has come
+	// but that were not announced yet
+	Due(now time.Time) ([]Paper, error)
+	// MarkAnnounced records that the publication of a paper was announced
+	MarkAnnounced(id int) error
+	// SetHash stores the perceptual hash of a paper's image
+	SetHash(id int, hash string) error
+}
+
+// InitPaperTables creates the paper and paper_variant tables if they do not exist
+func InitPaperTables(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS paper (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        category TEXT NOT NULL,
+        title TEXT NOT NULL DEFAULT '',
+        issue_date TEXT NOT NULL,
+        sort_order INTEGER NOT NULL DEFAULT 0,
+        visible INTEGER NOT NULL DEFAULT 1,
+        created_at TEXT NOT NULL
+    );`,
+		`CREATE INDEX IF NOT EXISTS paper_listing ON paper (category, visible, sort_order, issue_date);`,
+		`CREATE TABLE IF NOT EXISTS paper_variant (
+        paper_id INTEGER NOT NULL,
+        quality TEXT NOT NULL,
+        path TEXT NOT NULL,
+        PRIMARY KEY (paper_id, quality)
+    );`,
+		`CREATE INDEX IF NOT EXISTS paper_variant_path ON paper_variant (path);`,
+	}
+	for _, q := range stmts {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+	// Schedule columns hold unix seconds, 0 meaning immediately and never
+	for _, col := range []string{"publish_at", "expires_at", "announced"} {
+		if err := dbutil.AddColumn(db, "paper", col, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	return dbutil.AddColumn(db, "paper", "hash", "TEXT NOT NULL DEFAULT ''")
+}
+
+// SQLiteRepository implements Repository on top of the paper tables
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository wraps an open database handle
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{db: db}
+}
+
+const selectPaper = `SELECT id, category, title, issue_date, sort_order, visible, publish_at, expires_at, hash, created_at FROM paper`
+
+// List returns the papers matching f
+func (s *SQLiteRepository) List(f ListFilter) ([]Paper, int, error) {
+	var where []string
+	var args []any
+	if f.Category != "" {
+		where = append(where, "category=?")
+		args = append(args, f.Category)
+	}
+	if f.Quality != "" {
+		where = append(where, "id IN (SELECT paper_id FROM paper_variant WHERE quality=?)")
+		args = append(args, f.Quality)
+	}
+	if !f.Hidden {
+		where = append(where, "visible=1")
+	}
+	if f.Archived {
+		where = append(where, "expires_at>0 AND expires_at<=?")
+		args = append(args, f.At.Unix())
+	} else if !f.At.IsZero() {
+		where = append(where, "publish_at<=? AND (expires_at=0 OR expires_at>?)")
+		args = append(args, f.At.Unix(), f.At.Unix())
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM paper`+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	page, err := s.query(selectPaper+cond+` ORDER BY sort_order, issue_date DESC, id DESC LIMIT ? OFFSET ?`, append(args, limit, f.Offset)...)
+	return page, total, err
+}
+
+// Get returns the paper with the given id
+func (s *SQLiteRepository) Get(id int) (Paper, error) {
+	all, err := s.query(selectPaper+` WHERE id=?`, id)
+	if err != nil {
+		return Paper{}, err
+	}
+	if len(all) == 0 {
+		return Paper{}, ErrNotFound
+	}
+	return all[0], nil
+}
+
+func (s *SQLiteRepository) query(query string, args ...any) ([]Paper, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := []Paper{}
+	byID := map[int]int{}
+	for rows.Next() {
+		var p Paper
+		var publishAt, expiresAt int64
+		if err := rows.Scan(&p.ID, &p.Category, &p.Title, &p.IssueDate, &p.SortOrder, &p.Visible, &publishAt, &expiresAt, &p.Hash, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		p.PublishAt, p.ExpiresAt = formatUnix(publishAt), formatUnix(expiresAt)
+		p.Variants = map[string]string{}
+		byID[p.ID] = len(all)
+		all = append(all, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(all) == 0 {
+		return all, nil
+	}
+	ids := make([]string, 0, len(all))
+	vargs := make([]any, 0, len(all))
+	for _, p := range all {
+		ids = append(ids, "?")
+		vargs = append(vargs, p.ID)
+	}
+	vrows, err := s.db.Query(`SELECT paper_id, quality, path FROM paper_variant WHERE paper_id IN (`+strings.Join(ids, ",")+`)`, vargs...)
+	if err != nil {
+		return nil, err
+	}
+	defer vrows.Close()
+	for vrows.Next() {
+		var id int
+		var quality, path string
+		if err := vrows.Scan(&id, &quality, &path); err != nil {
+			return nil, err
+		}
+		all[byID[id]].Variants[quality] = path
+	}
+	return all, vrows.Err()
+}
+
+// Create inserts p and its variants in one transaction
+func (s *SQLiteRepository) Create(p Paper) (Paper, error) {
+	if p.CreatedAt == "" {
+		p.CreatedAt = time.Now().Format(time.RFC3339)
+	}
+	publishAt, expiresAt, err := scheduleUnix(p)
+	if err != nil {
+		return Paper{}, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Paper{}, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`INSERT INTO paper (category, title, issue_date, sort_order, visible, publish_at, expires_at, hash, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.Category, p.Title, p.IssueDate, p.SortOrder, p.Visible, publishAt, expiresAt, p.Hash, p.CreatedAt)
+	if err != nil {
+		return Paper{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Paper{}, err
+	}
+	p.ID = int(id)
+	for quality, path := range p.Variants {
+		if _, err := tx.Exec(`INSERT INTO paper_variant (paper_id, quality, path) VALUES (?, ?, ?)`, p.ID, quality, path); err != nil {
+			return Paper{}, err
+		}
+	}
+	if p.Variants == nil {
+		p.Variants = map[string]string{}
+	}
+	return p, tx.Commit()
+}
+
+// Update overwrites the metadata columns of p.ID; a new publish time is announced again
+func (s *SQLiteRepository) Update(p Paper) error {
+	publishAt, expiresAt, err := scheduleUnix(p)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`UPDATE paper SET category=?, title=?, issue_date=?, sort_order=?, visible=?,
+        announced=CASE WHEN publish_at=? THEN announced ELSE 0 END, publish_at=?, expires_at=? WHERE id=?`,
+		p.Category, p.Title, p.IssueDate, p.SortOrder, p.Visible, publishAt, publishAt, expiresAt, p.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetVariant stores or replaces the image of one quality
+func (s *SQLiteRepository) SetVariant(id int, quality, path string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO paper_variant (paper_id, quality, path) VALUES (?, ?, ?)`, id, quality, path)
+	return err
+}
+
+// HasPath reports whether a variant already points at path
+func (s *SQLiteRepository) HasPath(path string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM paper_variant WHERE path=?`, path).Scan(&n)
+	return n > 0, err
+}
+
+// Due returns the papers to announce at now
+func (s *SQLiteRepository) Due(now time.Time) ([]Paper, error) {
+	return s.query(selectPaper+` WHERE visible=1 AND announced=0 AND publish_at>0 AND publish_at<=? AND (expires_at=0 OR expires_at>?) ORDER BY publish_at, id`,
+		now.Unix(), now.Unix())
+}
+
+// MarkAnnounced flags a paper as announced
+func (s *SQLiteRepository) MarkAnnounced(id int) error {
+	_, err := s.db.Exec(`UPDATE paper SET announced=1 WHERE id=?`, id)
+	return err
+}
+
+// SetHash stores the perceptual hash of a paper
+func (s *SQLiteRepository) SetHash(id int, hash string) error {
+	_, err := s.db.Exec(`UPDATE paper SET hash=? WHERE id=?`, hash, id)
+	return err
+}
+
+// ValidCategory reports whether c is a known category
+func ValidCategory(c string) bool {
+	for _, v := range Categories {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidQuality reports whether q is a stored quality or a rendered variant
+func ValidQuality(q string) bool {
+	if q == QualityHigh || q == QualityLow {
+		return true
+	}
+	for _, spec := range VariantSpecs {
+		if spec.Name == q {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package futurepaper
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"gosse/imaging"
+)
+
+// DuplicateDistance is the largest number of differing hash bits at which two
+// papers count as the same image; re-scans and small crops stay well below it
+var DuplicateDistance = 10
+
+// Duplicate is a stored paper that resembles an image
+type Duplicate struct {
+	Paper    Paper `json:"paper"`
+	Distance int   `json:"distance"`
+}
+
+// FindDuplicates returns the papers, hidden ones included, whose hash is
+// within maxDist bits of hash, closest first
+func FindDuplicates(repo Repository, hash uint64, maxDist int) ([]Duplicate, error) {
+	papers, _, err := repo.List(ListFilter{Hidden: true})
+	if err != nil {
+		return nil, err
+	}
+	var out []Duplicate
+	for _, p := range papers {
+		h, err := imaging.ParseHash(p.Hash)
+		if err != nil {
+			continue
+		}
+		if d := imaging.HashDistance(hash, h); d <= maxDist {
+			out = append(out, Duplicate{Paper: p, Distance: d})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Distance < out[j].Distance })
+	return out, nil
+}
+
+// Cluster is a group of papers that are near-duplicates of each other
+type Cluster struct {
+	Papers []Paper `json:"papers"`
+	// MaxDistance is the largest hash distance between two papers of the cluster
+	MaxDistance int `json:"max_distance"`
+}
+
+// Clusters groups the hashed papers that are within maxDist of each other,
+// directly or through other papers, and returns the groups of two or more
+func Clusters(papers []Paper, maxDist int) []Cluster {
+	var hashed []Paper
+	var hashes []uint64
+	for _, p := range papers {
+		if h, err := imaging.ParseHash(p.Hash); err == nil {
+			hashed = append(hashed, p)
+			hashes = append(hashes, h)
+		}
+	}
+	parent := make([]int, len(hashed))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range hashed {
+		for j := i + 1; j < len(hashed); j++ {
+			if imaging.HashDistance(hashes[i], hashes[j]) <= maxDist {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+	members := map[int][]int{}
+	var roots []int
+	for i := range hashed {
+		r := find(i)
+		if members[r] == nil {
+			roots = append(roots, r)
+		}
+		members[r] = append(members[r], i)
+	}
+	var out []Cluster
+	for _, r := range roots {
+		idx := members[r]
+		if len(idx) < 2 {
+			continue
+		}
+		c := Cluster{}
+		for a, i := range idx {
+			c.Papers = append(c.Papers, hashed[i])
+			for _, j := range idx[a+1:] {
+				if d := imaging.HashDistance(hashes[i], hashes[j]); d > c.MaxDistance {
+					c.MaxDistance = d
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// BackfillHashes computes the hash of every paper that has none from its
+// stored image, preferring the high quality one, and returns how many it set
+func BackfillHashes(repo Repository) (int, error) {
+	papers, _, err := repo.List(ListFilter{Hidden: true})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, p := range papers {
+		if p.Hash != "" {
+			continue
+		}
+		rel, ok := p.Variants[QualityHigh]
+		if !ok {
+			rel, ok = p.Variants[QualityLow]
+		}
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(ImageDir, filepath.FromSlash(rel)))
+		if err != nil {
+			log.Printf("futurepaper: hash paper %d: %v", p.ID, err)
+			continue
+		}
+		img, _, err := imaging.Decode(data)
+		if err != nil {
+			log.Printf("futurepaper: hash paper %d: %v", p.ID, err)
+			continue
+		}
+		if err := repo.SetHash(p.ID, imaging.FormatHash(imaging.DHash(img))); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// DuplicatesHandler handles GET /futurepaper/duplicates?distance=&category= and
+// lists the clusters of near-duplicate papers, hidden ones included, so they
+// can be cleaned up. distance defaults to DuplicateDistance.
+func DuplicatesHandler(repo Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		maxDist := DuplicateDistance
+		if v := q.Get("distance"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > 64 {
+				http.Error(w, "Invalid distance parameter", http.StatusBadRequest)
+				return
+			}
+			maxDist = n
+		}
+		category := q.Get("category")
+		if category != "" && !ValidCategory(category) {
+			http.Error(w, "Invalid category parameter", http.StatusBadRequest)
+			return
+		}
+		papers, _, err := repo.List(ListFilter{Category: category, Hidden: true})
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		clusters := Clusters(papers, maxDist)
+		base := imageBaseURL(r)
+		for _, c := range clusters {
+			for i := range c.Papers {
+				c.Papers[i] = c.Papers[i].WithURLs(base)
+			}
+		}
+		if clusters == nil {
+			clusters = []Cluster{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   "success",
+			"distance": maxDist,
+			"clusters": clusters,
+		})
+	}
+}
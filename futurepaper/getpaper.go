@@ -2,117 +2,133 @@ package futurepaper
 
 import (
 	"encoding/json"
+	"gosse/audit"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
+	"strconv"
+	"time"
 )
 
-// GetLowPaperHandler returns JSON with image URLs in low/daily, low/weekly, low/calendar
-func GetLowPaperHandler(w http.ResponseWriter, r *http.Request) {
-	serveSplitPaper(w, r, "low")
+// GetLowPaperHandler returns JSON with the low quality image URLs of the published daily, weekly and calendar papers
+func GetLowPaperHandler(repo Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveSplitPaper(w, r, repo, QualityLow)
+	}
 }
 
-// GetHighPaperHandler returns JSON with image URLs in high/daily, high/weekly, high/calendar
-func GetHighPaperHandler(w http.ResponseWriter, r *http.Request) {
-	serveSplitPaper(w, r, "high")
+// GetHighPaperHandler returns JSON with the high quality image URLs of the published daily, weekly and calendar papers
+func GetHighPaperHandler(repo Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveSplitPaper(w, r, repo, QualityHigh)
+	}
 }
 
-// serveSplitPaper is a helper to serve images from base/daily, base/weekly, base/calendar
-func serveSplitPaper(w http.ResponseWriter, r *http.Request, base string) {
-	dirs := []string{"daily", "weekly", "calendar"}
-	exts := map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".bmp": true, ".webp": true}
-	scheme := "http"
-	if r.TLS != nil {
-		scheme = "https"
+// serveSplitPaper lists the published catalog per category in the shape older
+// app versions expect; limit and offset apply to every category
+func serveSplitPaper(w http.ResponseWriter, r *http.Request, repo Repository, quality string) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
 	}
-	host := r.Host
-	result := map[string][]string{"daily": {}, "weekly": {}, "calendar": {}}
-	for _, dir := range dirs {
-		fullDir := filepath.Join("futurepaper/images", base, dir)
-		entries, err := os.ReadDir(fullDir)
+	base := imageBaseURL(r)
+	result := map[string][]string{}
+	for _, category := range Categories {
+		papers, _, err := repo.List(ListFilter{Category: category, Quality: quality, At: time.Now(), Limit: limit, Offset: offset})
 		if err != nil {
-			result[dir] = []string{}
-			continue
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		var urls []string
-		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
-			}
-			ext := strings.ToLower(filepath.Ext(entry.Name()))
-			if exts[ext] {
-				url := scheme + "://" + host + "/futurepaper/images/" + base + "/" + dir + "/" + entry.Name()
-				urls = append(urls, url)
-			}
+		urls := []string{}
+		for _, p := range papers {
+			urls = append(urls, base+p.Variants[quality])
 		}
-		result[dir] = urls
+		result[category] = urls
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
 }
 
-// GetPaperHandler returns a JSON list of image URLs in futurepaper/images/
-func GetPaperHandler(w http.ResponseWriter, r *http.Request) {
-	imageDir := "futurepaper/images"
-	exts := map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".bmp": true, ".webp": true}
-	var files []string
-	entries, err := os.ReadDir(imageDir)
+// PapersHandler handles GET /futurepaper/papers?category=&quality=&limit=&offset=
+// and returns the published catalog entries with their variant URLs; the number
+// of matching papers is returned in the X-Total-Count header
+func PapersHandler(repo Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		servePapers(w, r, repo, ListFilter{At: time.Now()})
+	}
+}
+
+// ArchiveHandler handles GET /futurepaper/archive with the parameters of
+// PapersHandler and returns the papers whose expiry time has passed
+func ArchiveHandler(repo Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		servePapers(w, r, repo, ListFilter{At: time.Now(), Archived: true})
+	}
+}
+
+func servePapers(w http.ResponseWriter, r *http.Request, repo Repository, f ListFilter) {
+	q := r.URL.Query()
+	f.Category, f.Quality = q.Get("category"), q.Get("quality")
+	if f.Category != "" && !ValidCategory(f.Category) {
+		http.Error(w, "Invalid category parameter", http.StatusBadRequest)
+		return
+	}
+	if f.Quality != "" && !ValidQuality(f.Quality) {
+		http.Error(w, "Invalid quality parameter", http.StatusBadRequest)
+		return
+	}
+	var ok bool
+	if f.Limit, f.Offset, ok = parsePage(w, r); !ok {
+		return
+	}
+	papers, total, err := repo.List(f)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	scheme := "http"
-	if r.TLS != nil {
-		scheme = "https"
-	}
-	host := r.Host
-	baseURL := scheme + "://" + host + "/futurepaper/images/"
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		ext := strings.ToLower(filepath.Ext(entry.Name()))
-		if exts[ext] {
-			files = append(files, baseURL+entry.Name())
-		}
+	base := imageBaseURL(r)
+	for i := range papers {
+		papers[i] = papers[i].WithURLs(base)
 	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(files)
+	json.NewEncoder(w).Encode(papers)
 }
 
-// GetAllPaperHandler returns JSON with image URLs in daily/ and weekly/ folders
-func GetAllPaperHandler(w http.ResponseWriter, r *http.Request) {
-	dirs := []string{"daily", "weekly", "calendar"}
-	exts := map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".bmp": true, ".webp": true}
-
-	scheme := "http"
-	if r.TLS != nil {
-		scheme = "https"
+// WithURLs returns a copy of p whose variants are URLs below base
+func (p Paper) WithURLs(base string) Paper {
+	variants := make(map[string]string, len(p.Variants))
+	for quality, v := range p.Variants {
+		variants[quality] = base + v
 	}
-	host := r.Host
+	p.Variants = variants
+	return p
+}
 
-	result := map[string][]string{"daily": {}, "weekly": {}, "calendar": {}}
-	for _, dir := range dirs {
-		fullDir := filepath.Join("futurepaper/images", dir)
-		entries, err := os.ReadDir(fullDir)
-		if err != nil {
-			result[dir] = []string{}
-			continue
+// imageBaseURL returns the absolute URL prefix of ImageDir as served under /futurepaper/images/
+func imageBaseURL(r *http.Request) string {
+	return audit.Scheme(r) + "://" + r.Host + ImagePath
+}
+
+// parsePage reads the limit and offset parameters; limit 0 means everything
+func parsePage(w http.ResponseWriter, r *http.Request) (limit, offset int, ok bool) {
+	q := r.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 1000 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset parameter", http.StatusBadRequest)
+			return 0, 0, false
 		}
-		var urls []string
-		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
-			}
-			ext := strings.ToLower(filepath.Ext(entry.Name()))
-			if exts[ext] {
-				url := scheme + "://" + host + "/futurepaper/images/" + dir + "/" + entry.Name()
-				urls = append(urls, url)
-			}
+		offset = n
+		if limit == 0 {
+			limit = 50
 		}
-		result[dir] = urls
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	return limit, offset, true
 }
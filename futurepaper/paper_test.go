@@ -0,0 +1,355 @@
+package futurepaper_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gosse/blob"
+	"gosse/futurepaper"
+	"gosse/storage"
+	"gosse/upload"
+)
+
+func pngImage(w, h int) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)))
+	return buf.Bytes()
+}
+
+func setup(t *testing.T) *futurepaper.SQLiteRepository {
+	t.Helper()
+	dir := t.TempDir()
+	old := futurepaper.ImageDir
+	futurepaper.ImageDir = dir
+	t.Cleanup(func() { futurepaper.ImageDir = old })
+	for _, f := range []string{"high/daily/a.jpg", "low/daily/a.jpg", "high/calendar/c.png", "legacy.jpg", "high/daily/notes.txt"} {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte("img"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return futurepaper.NewSQLiteRepository(db)
+}
+
+func TestSeedFromDisk(t *testing.T) {
+	repo := setup(t)
+	n, err := futurepaper.SeedFromDisk(repo, futurepaper.ImageDir)
+	if err != nil || n != 3 {
+		t.Fatalf("seeded %d papers, %v; want 3", n, err)
+	}
+	if n, _ := futurepaper.SeedFromDisk(repo, futurepaper.ImageDir); n != 0 {
+		t.Errorf("second seed created %d papers", n)
+	}
+	all, total, err := repo.List(futurepaper.ListFilter{Hidden: true})
+	if err != nil || total != 3 {
+		t.Fatalf("total = %d, %v", total, err)
+	}
+	for _, p := range all {
+		switch p.Title {
+		case "a":
+			if p.Variants["high"] != "high/daily/a.jpg" || p.Variants["low"] != "low/daily/a.jpg" || !p.Visible {
+				t.Errorf("paper a = %+v", p)
+			}
+		case "legacy":
+			if p.Visible || p.Category != "daily" {
+				t.Errorf("legacy upload = %+v", p)
+			}
+		}
+	}
+}
+
+func TestUploadAndList(t *testing.T) {
+	repo := setup(t)
+	futurepaper.SeedFromDisk(repo, futurepaper.ImageDir)
+	blobs := blob.NewStore(filepath.Join(futurepaper.ImageDir, futurepaper.BlobDir), futurepaper.BlobRefs(repo))
+	add := futurepaper.UploadPaperImageHandler(repo, upload.New(upload.DefaultLimits, nil), blobs, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/futurepaper/addpaper?category=weekly&title=Week+34&issue_date=2025/08/18&sort_order=-1", bytes.NewReader(pngImage(1000, 500)))
+	req.Header.Set("Content-Type", "image/png")
+	rec := httptest.NewRecorder()
+	add(rec, req)
+	var resp struct {
+		Paper futurepaper.Paper `json:"paper"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("upload status = %d, %v", rec.Code, err)
+	}
+	p := resp.Paper
+	if p.Category != "weekly" || p.IssueDate != "2025-08-18" || !strings.HasPrefix(p.Variants["high"], "blobs/") {
+		t.Fatalf("uploaded paper = %+v", p)
+	}
+	// the low and thumb variants are rendered from the original
+	for _, q := range []string{"high", "low", "thumb"} {
+		f, err := os.Open(filepath.Join(futurepaper.ImageDir, filepath.FromSlash(p.Variants[q])))
+		if err != nil {
+			t.Fatalf("%s variant: %v", q, err)
+		}
+		cfg, _, err := image.DecodeConfig(f)
+		f.Close()
+		want := map[string]int{"high": 1000, "low": 720, "thumb": 240}[q]
+		if err != nil || cfg.Width != want {
+			t.Errorf("%s variant %s: width %d, %v", q, p.Variants[q], cfg.Width, err)
+		}
+	}
+
+	// replace the low variant by hand; the same bytes twice are stored once
+	for i := 0; i < 2; i++ {
+		req = httptest.NewRequest(http.MethodPost, "/futurepaper/addpaper?quality=low&paper_id=4", bytes.NewReader(pngImage(300, 150)))
+		rec = httptest.NewRecorder()
+		add(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("variant upload status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+	counts, err := blobs.RefCounts()
+	if err != nil || len(counts) != 3 {
+		t.Errorf("blob refs = %v, %v", counts, err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/futurepaper/addpaper", strings.NewReader("not an image"))
+	rec = httptest.NewRecorder()
+	add(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("garbage upload status = %d, want 415", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	futurepaper.GetLowPaperHandler(repo)(rec, httptest.NewRequest(http.MethodGet, "/futurepaper/getallpaper/low", nil))
+	var split map[string][]string
+	json.NewDecoder(rec.Body).Decode(&split)
+	if len(split["daily"]) != 1 || len(split["weekly"]) != 1 || len(split["calendar"]) != 0 {
+		t.Errorf("low listing = %v", split)
+	}
+	if !strings.HasPrefix(split["weekly"][0], "http://example.com/futurepaper/images/blobs/") {
+		t.Errorf("weekly url = %q", split["weekly"][0])
+	}
+
+	rec = httptest.NewRecorder()
+	futurepaper.PapersHandler(repo)(rec, httptest.NewRequest(http.MethodGet, "/futurepaper/papers?limit=1", nil))
+	var page []futurepaper.Paper
+	json.NewDecoder(rec.Body).Decode(&page)
+	if rec.Header().Get("X-Total-Count") != "3" || len(page) != 1 || page[0].ID != 4 {
+		t.Errorf("page = %+v, total %s", page, rec.Header().Get("X-Total-Count"))
+	}
+}
+
+func TestUpdatePaper(t *testing.T) {
+	repo := setup(t)
+	futurepaper.SeedFromDisk(repo, futurepaper.ImageDir)
+	h := futurepaper.UpdatePaperHandler(repo, nil)
+	post := func(url, body string) int {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, url, strings.NewReader(body)))
+		return rec.Code
+	}
+	if got := post("/futurepaper/update?id=1", `{"visible":false,"title":"Daily 1"}`); got != http.StatusOK {
+		t.Fatalf("status = %d", got)
+	}
+	if p, _ := repo.Get(1); p.Visible || p.Title != "Daily 1" || p.Category != "daily" {
+		t.Errorf("updated paper = %+v", p)
+	}
+	if _, total, _ := repo.List(futurepaper.ListFilter{}); total != 1 {
+		t.Errorf("%d visible papers, want 1", total)
+	}
+	if got := post("/futurepaper/update?id=1", `{"category":"monthly"}`); got != http.StatusBadRequest {
+		t.Errorf("bad category: status = %d", got)
+	}
+	if got := post("/futurepaper/update?id=99", `{}`); got != http.StatusNotFound {
+		t.Errorf("missing paper: status = %d", got)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	repo := setup(t)
+	now := time.Now()
+	at := func(d time.Duration) string { return now.Add(d).Format(time.RFC3339) }
+	for _, p := range []futurepaper.Paper{
+		{Category: "weekly", Title: "current", Visible: true, PublishAt: at(-time.Hour), ExpiresAt: at(time.Hour)},
+		{Category: "weekly", Title: "next", Visible: true, PublishAt: at(2 * time.Hour)},
+		{Category: "weekly", Title: "old", Visible: true, PublishAt: at(-48 * time.Hour), ExpiresAt: at(-24 * time.Hour)},
+	} {
+		p.IssueDate = "2025-08-18"
+		p.Variants = map[string]string{"low": "blobs/" + p.Title + ".jpg"}
+		if _, err := repo.Create(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	titles := func(h http.HandlerFunc, url string) []string {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		var papers []futurepaper.Paper
+		json.NewDecoder(rec.Body).Decode(&papers)
+		var out []string
+		for _, p := range papers {
+			out = append(out, p.Title)
+		}
+		return out
+	}
+	if got := titles(futurepaper.PapersHandler(repo), "/futurepaper/papers"); len(got) != 1 || got[0] != "current" {
+		t.Errorf("published = %v", got)
+	}
+	if got := titles(futurepaper.ArchiveHandler(repo), "/futurepaper/archive?category=weekly"); len(got) != 1 || got[0] != "old" {
+		t.Errorf("archive = %v", got)
+	}
+
+	var announced []string
+	pub := futurepaper.NewPublisher(repo, func(p futurepaper.Paper) { announced = append(announced, p.Title) })
+	pub.Now = func() time.Time { return now }
+	if n := pub.PublishDue(); n != 1 || announced[0] != "current" {
+		t.Fatalf("announced %v", announced)
+	}
+	pub.Now = func() time.Time { return now.Add(3 * time.Hour) }
+	if n := pub.PublishDue(); n != 1 || announced[1] != "next" {
+		t.Fatalf("announced %v", announced)
+	}
+	if n := pub.PublishDue(); n != 0 {
+		t.Errorf("announced %d papers twice", n)
+	}
+
+	// rescheduling a paper announces it again when the new time comes
+	rec := httptest.NewRecorder()
+	body := `{"publish_at":"` + at(4*time.Hour) + `","expires_at":"` + at(5*time.Hour) + `"}`
+	futurepaper.UpdatePaperHandler(repo, pub)(rec, httptest.NewRequest(http.MethodPost, "/futurepaper/update?id=2", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reschedule status = %d: %s", rec.Code, rec.Body)
+	}
+	pub.Now = func() time.Time { return now.Add(4*time.Hour + time.Minute) }
+	if n := pub.PublishDue(); n != 1 {
+		t.Errorf("rescheduled paper announced %d times", n)
+	}
+	rec = httptest.NewRecorder()
+	body = `{"expires_at":"` + at(3*time.Hour) + `"}`
+	futurepaper.UpdatePaperHandler(repo, pub)(rec, httptest.NewRequest(http.MethodPost, "/futurepaper/update?id=2", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expiry before publish: status = %d", rec.Code)
+	}
+}
+
+func TestBundle(t *testing.T) {
+	repo := setup(t)
+	futurepaper.SeedFromDisk(repo, futurepaper.ImageDir)
+	h := futurepaper.BundleHandler(repo)
+	get := func(url, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+	entries := func(rec *httptest.ResponseRecorder) []string {
+		zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		if err != nil {
+			t.Fatalf("bundle is not a zip: %v", err)
+		}
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+
+	rec := get("/futurepaper/bundle?category=daily&quality=low", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("status %d, headers %v", rec.Code, rec.Header())
+	}
+	if got := entries(rec); len(got) != 1 || got[0] != "01-a.jpg" {
+		t.Errorf("entries = %v", got)
+	}
+	if rec := get("/futurepaper/bundle?category=daily&quality=low", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", rec.Code)
+	}
+
+	// a new paper changes the set and the ETag
+	os.WriteFile(filepath.Join(futurepaper.ImageDir, "low", "daily", "b.jpg"), []byte("img b"), 0644)
+	repo.Create(futurepaper.Paper{Category: "daily", Title: "b", IssueDate: "2025-08-19", SortOrder: -1, Visible: true,
+		Variants: map[string]string{"low": "low/daily/b.jpg"}})
+	rec = get("/futurepaper/bundle?category=daily&quality=low", etag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Fatalf("after change: status %d, etag %s", rec.Code, rec.Header().Get("ETag"))
+	}
+	if got := entries(rec); len(got) != 2 || got[0] != "01-b.jpg" {
+		t.Errorf("entries = %v", got)
+	}
+
+	if rec := get("/futurepaper/bundle?category=calendar", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("empty set status = %d, want 404", rec.Code)
+	}
+	if rec := get("/futurepaper/bundle?category=monthly", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad category status = %d, want 400", rec.Code)
+	}
+}
+
+func scenePNG(w, h int, phase float64, crop int) []byte {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			img.Pix[y*img.Stride+x] = uint8(128 + 60*math.Sin(7*fx+phase)*math.Cos(5*fy) + 60*math.Sin(11*fx*fy+2*phase))
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img.SubImage(image.Rect(crop, crop, w-crop, h-crop)))
+	return buf.Bytes()
+}
+
+func TestDuplicateUploads(t *testing.T) {
+	repo := setup(t)
+	blobs := blob.NewStore(filepath.Join(futurepaper.ImageDir, futurepaper.BlobDir), futurepaper.BlobRefs(repo))
+	add := futurepaper.UploadPaperImageHandler(repo, upload.New(upload.DefaultLimits, nil), blobs, nil)
+	post := func(query string, body []byte) (int, map[string]json.RawMessage) {
+		rec := httptest.NewRecorder()
+		add(rec, httptest.NewRequest(http.MethodPost, "/futurepaper/addpaper?category=calendar"+query, bytes.NewReader(body)))
+		var resp map[string]json.RawMessage
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	if code, _ := post("", scenePNG(600, 400, 0, 0)); code != http.StatusOK {
+		t.Fatalf("first upload status = %d", code)
+	}
+	// the same scan with a slightly different crop is refused, then kept when forced
+	rescan := scenePNG(600, 400, 0, 10)
+	code, resp := post("", rescan)
+	if code != http.StatusConflict || resp["duplicates"] == nil {
+		t.Fatalf("near-duplicate status = %d, %v", code, resp)
+	}
+	code, resp = post("&force=true", rescan)
+	if code != http.StatusOK || resp["duplicates"] == nil {
+		t.Fatalf("forced upload status = %d, %v", code, resp)
+	}
+	if code, _ := post("", scenePNG(600, 400, 2, 0)); code != http.StatusOK {
+		t.Errorf("different image status = %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	futurepaper.DuplicatesHandler(repo)(rec, httptest.NewRequest(http.MethodGet, "/futurepaper/duplicates", nil))
+	var report struct {
+		Clusters []futurepaper.Cluster `json:"clusters"`
+	}
+	json.NewDecoder(rec.Body).Decode(&report)
+	if len(report.Clusters) != 1 || len(report.Clusters[0].Papers) != 2 {
+		t.Fatalf("clusters = %+v", report.Clusters)
+	}
+	if !strings.HasPrefix(report.Clusters[0].Papers[0].Variants["high"], "http://example.com/futurepaper/images/blobs/") {
+		t.Errorf("cluster paper = %+v", report.Clusters[0].Papers[0])
+	}
+}
@@ -0,0 +1,114 @@
+package futurepaper
+
+import (
+	"log"
+	"time"
+
+	"gosse/dates"
+)
+
+// PaperNotifier is called when a paper becomes public
+type PaperNotifier func(Paper)
+
+// ParseTime reads a publish or expiry time: an RFC 3339 instant, or a date in
+// any format dates accepts, meaning midnight of that day in Yangon
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return dates.Parse(s)
+}
+
+// formatTime renders t as stored in Paper, in Yangon time; the zero time is ""
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(dates.Location()).Format(time.RFC3339)
+}
+
+func formatUnix(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return formatTime(time.Unix(n, 0))
+}
+
+// scheduleUnix converts the schedule of p to unix seconds
+func scheduleUnix(p Paper) (publishAt, expiresAt int64, err error) {
+	for _, f := range []struct {
+		s   string
+		out *int64
+	}{{p.PublishAt, &publishAt}, {p.ExpiresAt, &expiresAt}} {
+		if f.s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, f.s)
+		if err != nil {
+			return 0, 0, err
+		}
+		*f.out = t.Unix()
+	}
+	return publishAt, expiresAt, nil
+}
+
+// Publisher announces papers when their publish time comes
+type Publisher struct {
+	repo   Repository
+	notify PaperNotifier
+	wake   chan struct{}
+
+	PollInterval time.Duration
+	Now          func() time.Time
+}
+
+// NewPublisher checks repo for due papers and passes each to notify once
+func NewPublisher(repo Repository, notify PaperNotifier) *Publisher {
+	return &Publisher{repo: repo, notify: notify, wake: make(chan struct{}, 1), PollInterval: 15 * time.Second, Now: time.Now}
+}
+
+// Wake makes a running publisher check for due papers now; handlers call it
+// after changing the catalog. It is safe on a nil publisher.
+func (p *Publisher) Wake() {
+	if p == nil {
+		return
+	}
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start announces due papers until stop is closed
+func (p *Publisher) Start(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.PollInterval)
+	defer ticker.Stop()
+	for {
+		p.PublishDue()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+	}
+}
+
+// PublishDue announces every due paper and returns how many it announced
+func (p *Publisher) PublishDue() int {
+	due, err := p.repo.Due(p.Now())
+	if err != nil {
+		log.Printf("futurepaper: load due papers: %v", err)
+		return 0
+	}
+	for _, paper := range due {
+		if err := p.repo.MarkAnnounced(paper.ID); err != nil {
+			log.Printf("futurepaper: mark paper %d announced: %v", paper.ID, err)
+			return 0
+		}
+		if p.notify != nil {
+			p.notify(paper)
+		}
+	}
+	return len(due)
+}
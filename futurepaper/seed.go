@@ -0,0 +1,96 @@
+package futurepaper
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gosse/dates"
+)
+
+var imageExts = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".bmp": true, ".webp": true}
+
+// SeedFromDisk registers the images under dir that are not in the catalog yet
+// and returns how many papers it created. Files in <quality>/<category>/ with
+// the same name become the quality variants of one visible paper. Older uploads
+// in the root of dir have no category; they are registered hidden under daily
+// so an admin can review them. The issue date is the file's modification day,
+// and the sort order keeps the alphabetical order the directory listing had.
+func SeedFromDisk(repo Repository, dir string) (int, error) {
+	type key struct{ category, name string }
+	found := map[key]*Paper{}
+	var order []key
+	add := func(k key, quality, rel string, visible bool) error {
+		known, err := repo.HasPath(rel)
+		if err != nil || known {
+			return err
+		}
+		p := found[k]
+		if p == nil {
+			info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rel)))
+			if err != nil {
+				return err
+			}
+			p = &Paper{
+				Category:  k.category,
+				Title:     strings.TrimSuffix(k.name, filepath.Ext(k.name)),
+				IssueDate: dates.Day(info.ModTime()).Format(dates.Layout),
+				Visible:   visible,
+				Variants:  map[string]string{},
+			}
+			found[k] = p
+			order = append(order, k)
+		}
+		p.Variants[quality] = rel
+		return nil
+	}
+
+	for _, quality := range Qualities {
+		for _, category := range Categories {
+			for _, name := range imageFiles(filepath.Join(dir, quality, category)) {
+				if err := add(key{category, name}, quality, path.Join(quality, category, name), true); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	for _, name := range imageFiles(dir) {
+		if err := add(key{"", name}, QualityHigh, name, false); err != nil {
+			return 0, err
+		}
+	}
+
+	created := 0
+	next := map[string]int{}
+	for _, k := range order {
+		p := found[k]
+		if p.Category == "" {
+			p.Category = CategoryDaily
+		}
+		p.SortOrder = next[p.Category]
+		next[p.Category]++
+		if _, err := repo.Create(*p); err != nil {
+			return created, err
+		}
+		created++
+	}
+	return created, nil
+}
+
+// imageFiles returns the image file names directly inside dir, sorted
+func imageFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && imageExts[strings.ToLower(filepath.Ext(e.Name()))] {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,55 +1,89 @@
 package gift
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"gosse/audit"
+	"gosse/blob"
+	"gosse/imaging"
+	"gosse/upload"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// Gift struct with category support
-
-// GiftDataHandler handles GET /giftdata and returns rows as JSON, filtered by id and/or category if provided
-func GiftDataHandler(db *sql.DB) http.HandlerFunc {
+// GiftDataHandler handles GET /gift?id=&category=&limit=&offset= and returns the
+// active gifts in catalog order; all=true includes inactive ones. The number of
+// matching gifts is returned in the X-Total-Count header.
+func GiftDataHandler(repo Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.URL.Query().Get("id")
-		category := r.URL.Query().Get("category")
-		var rows *sql.Rows
-		var err error
-		if id != "" && category != "" {
-			rows, err = db.Query(`SELECT id, category, name, url FROM gift WHERE id=? AND category=?`, id, category)
-		} else if id != "" {
-			rows, err = db.Query(`SELECT id, category, name, url FROM gift WHERE id=?`, id)
-		} else if category != "" {
-			rows, err = db.Query(`SELECT id, category, name, url FROM gift WHERE category=?`, category)
-		} else {
-			rows, err = db.Query(`SELECT id, category, name, url FROM gift`)
+		q := r.URL.Query()
+		f := ListFilter{ID: q.Get("id"), Category: q.Get("category"), Inactive: q.Get("all") == "true"}
+		var ok bool
+		if f.Limit, f.Offset, ok = parsePage(w, r); !ok {
+			return
 		}
+		all, total, err := repo.List(f)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-		var all []Gift
-		for rows.Next() {
-			var g Gift
-			err := rows.Scan(&g.ID, &g.Category, &g.Name, &g.URL)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			all = append(all, g)
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(all)
+	}
+}
+
+// CategoriesHandler handles GET /gift/categories and returns the categories
+// that have active gifts with their gift counts
+func CategoriesHandler(repo Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all, err := repo.Categories()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(all)
 	}
 }
 
-// AddImageHandler handles POST /addimage to upload an image to the images folder
-func AddGiftHandler(db *sql.DB) http.HandlerFunc {
+// parsePage reads the limit and offset parameters; limit 0 means everything
+func parsePage(w http.ResponseWriter, r *http.Request) (limit, offset int, ok bool) {
+	q := r.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 1000 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset parameter", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		offset = n
+		if limit == 0 {
+			limit = 50
+		}
+	}
+	return limit, offset, true
+}
+
+// AddGiftHandler handles POST /addgift/?id=&category= to upload a gift image.
+// The image is stored without its metadata, next to one resized JPEG per VariantSpecs entry.
+// display_name, price, sort_order, active and animation set the catalog entry.
+// The body is checked and charged to the uploader's quota by up, and the files are
+// stored content-addressed in blobs.
+func AddGiftHandler(repo Repository, up *upload.Uploader, blobs *blob.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if rec := recover(); rec != nil {
@@ -62,92 +96,90 @@ func AddGiftHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Insert or update gift table with id and category
+		q := r.URL.Query()
+		id := q.Get("id")
+		if id == "" {
+			id = fmt.Sprintf("%d", time.Now().UnixNano())
+		}
+		category := q.Get("category")
+		if category == "" {
+			category = "default"
+		}
+		audit.SetTarget(r, category+"/"+id)
+
+		// A replaced image keeps the gift's catalog settings unless they are given again
+		g, found, err := repo.Get(id, category)
+		if err != nil {
+			http.Error(w, "DB error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			g = Gift{ID: id, Category: category, DisplayName: id, Active: true}
+		}
+		if err := applyQuery(&g, q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Create images folder if not exists
 		if err := ensureImagesDir(); err != nil {
 			http.Error(w, "Failed to create images dir: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// Read all image data from body
-		body, err := io.ReadAll(r.Body)
+		file, err := up.Receive(w, r)
 		if err != nil {
-			http.Error(w, "Failed to read image: "+err.Error(), http.StatusBadRequest)
+			upload.WriteError(w, err)
 			return
 		}
 
-		// Use extension from Content-Type if possible, else default to .png
-		ext := ".png"
-		switch r.Header.Get("Content-Type") {
-		case "image/jpeg":
-			ext = ".jpg"
-		case "image/png":
-			ext = ".png"
-		case "image/gif":
-			ext = ".gif"
-		}
-
-		fname := fmt.Sprintf("%f%s", float64(time.Now().UnixNano())/1e9, ext)
-		fpath := "gift/images/" + fname
-		out, err := os.Create(fpath)
+		// Strip metadata and render the resized variants
+		img, err := imaging.ProcessDecoded(file.Data, file.Image, file.Format, VariantSpecs)
 		if err != nil {
-			http.Error(w, "Failed to save image: "+err.Error(), http.StatusInternalServerError)
+			file.Release()
+			http.Error(w, "Invalid image: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer out.Close()
-		_, err = out.Write(body)
+
+		// Files are named by content, so re-uploading an image stores it once
+		fname, _, err := blobs.Put(img.Original, imaging.Ext(img.Format))
 		if err != nil {
+			file.Release()
 			http.Error(w, "Failed to write image: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		// Insert or update gift table with id and category
-		id := r.URL.Query().Get("id")
-		if id == "" {
-			id = fmt.Sprintf("%d", time.Now().UnixNano())
-		}
-		category := r.URL.Query().Get("category")
-		if category == "" {
-			category = "default"
-		}
-		name := fname
-		relUrl := "/gift/images/" + fname
-		scheme := "http"
-		host := r.Host
-		fullUrl := scheme + "://" + host + relUrl
-
-		// If id+category exists, get old url and delete old file after update
-		var oldUrl string
-		var oldFilename string
-		err = db.QueryRow("SELECT url FROM gift WHERE id=? AND category=?", id, category).Scan(&oldUrl)
-		if err == nil && oldUrl != "" {
-			// Always extract filename after last slash
-			lastSlash := -1
-			for i := len(oldUrl) - 1; i >= 0; i-- {
-				if oldUrl[i] == '/' {
-					lastSlash = i
-					break
-				}
-			}
-			if lastSlash != -1 && lastSlash+1 < len(oldUrl) {
-				oldFilename = oldUrl[lastSlash+1:]
+		variantFiles := map[string]string{}
+		for _, v := range img.Variants {
+			name, _, err := blobs.Put(v.Data, ".jpg")
+			if err != nil {
+				file.Release()
+				http.Error(w, "Failed to write image: "+err.Error(), http.StatusInternalServerError)
+				return
 			}
+			variantFiles[v.Spec.Name] = name
 		}
 
-		res, err := db.Exec("UPDATE gift SET url=?, name=? WHERE id=? AND category=?", fullUrl, name, id, category)
-		rowsAffected, _ := res.RowsAffected()
-		if err != nil || rowsAffected == 0 {
-			// Insert if update did not affect any row
-			_, err := db.Exec("INSERT OR REPLACE INTO gift (id, category, name, url) VALUES (?, ?, ?, ?)", id, category, name, fullUrl)
-			if err != nil {
-				http.Error(w, "DB error: "+err.Error(), http.StatusInternalServerError)
-				return
+		baseURL := audit.Scheme(r) + "://" + r.Host + "/gift/images/"
+		fullUrl := baseURL + fname
+		variants := map[string]string{}
+		for v, stored := range variantFiles {
+			variants[v] = baseURL + stored
+		}
+
+		g.FileName, g.URL, g.Variants = fname, fullUrl, variants
+		if g.Animation == "" {
+			g.Animation = AnimationNone
+			if img.Format == imaging.FormatGIF {
+				g.Animation = AnimationGIF
 			}
 		}
 
-		// Delete old file if needed
-		if oldFilename != "" && oldFilename != fname {
-			oldPath := "gift/images/" + oldFilename
-			_ = os.Remove(oldPath)
+		// Replaced images are left to the blob collector once nothing references them
+		if err := repo.Save(g); err != nil {
+			file.Release()
+			http.Error(w, "DB error: "+err.Error(), http.StatusInternalServerError)
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -157,11 +189,141 @@ func AddGiftHandler(db *sql.DB) http.HandlerFunc {
 			"id":        id,
 			"category":  category,
 			"url":       fullUrl,
+			"variants":  variants,
+			"gift":      g,
 		})
 	}
 }
 
+// UpdateGiftHandler handles POST /gift/update?id=&category= with any of
+// display_name, price, sort_order, active and animation to edit a catalog entry
+func UpdateGiftHandler(repo Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		id, category := q.Get("id"), q.Get("category")
+		if id == "" || category == "" {
+			http.Error(w, "Missing id or category parameter", http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			DisplayName *string `json:"display_name"`
+			Price       *int    `json:"price"`
+			SortOrder   *int    `json:"sort_order"`
+			Active      *bool   `json:"active"`
+			Animation   *string `json:"animation"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		audit.SetTarget(r, category+"/"+id)
+		g, found, err := repo.Get(id, category)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Gift not found", http.StatusNotFound)
+			return
+		}
+		if req.DisplayName != nil {
+			g.DisplayName = strings.TrimSpace(*req.DisplayName)
+		}
+		if req.Price != nil {
+			g.Price = *req.Price
+		}
+		if req.SortOrder != nil {
+			g.SortOrder = *req.SortOrder
+		}
+		if req.Active != nil {
+			g.Active = *req.Active
+		}
+		if req.Animation != nil {
+			g.Animation = *req.Animation
+		}
+		if err := validate(g); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := repo.Save(g); err != nil {
+			http.Error(w, "Database update error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "updated", "gift": g})
+	}
+}
+
+// applyQuery sets the catalog fields given as upload parameters
+func applyQuery(g *Gift, q url.Values) error {
+	if v := q.Get("display_name"); v != "" {
+		g.DisplayName = strings.TrimSpace(v)
+	}
+	for _, f := range []struct {
+		name string
+		out  *int
+	}{{"price", &g.Price}, {"sort_order", &g.SortOrder}} {
+		if v := q.Get(f.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("Invalid %s parameter", f.name)
+			}
+			*f.out = n
+		}
+	}
+	if v := q.Get("active"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.New("Invalid active parameter")
+		}
+		g.Active = b
+	}
+	if v := q.Get("animation"); v != "" {
+		g.Animation = v
+	}
+	if g.Animation == "" {
+		// chosen from the image format once it is decoded
+		return validate(Gift{DisplayName: g.DisplayName, Price: g.Price, Animation: AnimationNone})
+	}
+	return validate(*g)
+}
+
+// validate checks the catalog fields of g
+func validate(g Gift) error {
+	switch {
+	case g.DisplayName == "":
+		return errors.New("display_name must not be empty")
+	case g.Price < 0:
+		return errors.New("price must not be negative")
+	case !ValidAnimation(g.Animation):
+		return errors.New("animation must be one of " + strings.Join(Animations, ", "))
+	}
+	return nil
+}
+
 // ensureImagesDir creates the images directory if it doesn't exist
 func ensureImagesDir() error {
-	return os.MkdirAll("gift/images", 0755)
+	return os.MkdirAll(ImageDir, 0755)
+}
+
+// BlobRefs lists the blob behind the image and every variant of each gift
+func BlobRefs(repo Repository) blob.RefSource {
+	return func() ([]string, error) {
+		gifts, _, err := repo.List(ListFilter{Inactive: true})
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, g := range gifts {
+			names = append(names, g.FileName)
+			for _, u := range g.Variants {
+				names = append(names, path.Base(u))
+			}
+		}
+		return names, nil
+	}
 }
@@ -0,0 +1,234 @@
+package gift_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"gosse/blob"
+	"gosse/chat"
+	"gosse/gift"
+	"gosse/storage"
+	"gosse/upload"
+	"gosse/user"
+)
+
+func TestGiftDataHandlerFilters(t *testing.T) {
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h := gift.GiftDataHandler(gift.NewSQLiteRepository(db))
+
+	cases := []struct {
+		query string
+		want  int
+	}{
+		{"", 3},
+		{"?category=flower", 2},
+		{"?id=car", 1},
+		{"?id=car&category=flower", 0},
+		{"?category=flower&all=true", 3},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/gift"+c.query, nil))
+		var got []gift.Gift
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("%s: %v", c.query, err)
+		}
+		if len(got) != c.want {
+			t.Errorf("%s: got %d gifts, want %d", c.query, len(got), c.want)
+		}
+	}
+}
+
+func TestGiftDataHandlerCatalog(t *testing.T) {
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := gift.NewSQLiteRepository(db)
+	h := gift.GiftDataHandler(repo)
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/gift?category=flower&limit=1&offset=1", nil))
+	var page []map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if rec.Header().Get("X-Total-Count") != "2" || len(page) != 1 {
+		t.Fatalf("page = %v, total %q", page, rec.Header().Get("X-Total-Count"))
+	}
+	// sort_order puts tulip first, so the second entry is the rose
+	want := map[string]interface{}{
+		"id": "rose", "category": "flower", "display_name": "Red Rose", "file_name": "rose.png",
+		"price": 10.0, "sort_order": 2.0, "active": true, "animation": "none",
+	}
+	for k, v := range want {
+		if page[0][k] != v {
+			t.Errorf("%s = %v, want %v", k, page[0][k], v)
+		}
+	}
+	for _, bad := range []string{"?limit=-1", "?offset=x"} {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/gift"+bad, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", bad, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	gift.CategoriesHandler(repo)(rec, httptest.NewRequest(http.MethodGet, "/gift/categories", nil))
+	var cats []gift.CategoryCount
+	json.NewDecoder(rec.Body).Decode(&cats)
+	if len(cats) != 2 || cats[1] != (gift.CategoryCount{Category: "flower", Count: 2}) {
+		t.Errorf("categories = %+v", cats)
+	}
+
+	update := gift.UpdateGiftHandler(repo)
+	for body, code := range map[string]int{
+		`{"price": -1}`:            http.StatusBadRequest,
+		`{"animation": "sparkle"}`: http.StatusBadRequest,
+		`{"display_name": " "}`:    http.StatusBadRequest,
+		`{"active": true, "price": 7, "animation": "lottie"}`: http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		update(rec, httptest.NewRequest(http.MethodPost, "/gift/update?id=lily&category=flower", strings.NewReader(body)))
+		if rec.Code != code {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, code)
+		}
+	}
+	g, _, _ := repo.Get("lily", "flower")
+	if !g.Active || g.Price != 7 || g.Animation != gift.AnimationLottie || g.DisplayName != "lily" {
+		t.Errorf("updated gift = %+v", g)
+	}
+}
+
+func TestAddGiftHandlerVariants(t *testing.T) {
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	dir := t.TempDir()
+	old := gift.ImageDir
+	gift.ImageDir = dir
+	defer func() { gift.ImageDir = old }()
+	repo := gift.NewSQLiteRepository(db)
+	blobs := blob.NewStore(dir, gift.BlobRefs(repo))
+	h := gift.AddGiftHandler(repo, upload.New(upload.DefaultLimits, nil), blobs)
+
+	send := func(size int) map[string]interface{} {
+		var buf bytes.Buffer
+		img := image.NewGray(image.Rect(0, 0, size, size))
+		for i := range img.Pix {
+			img.Pix[i] = uint8(size)
+		}
+		png.Encode(&buf, img)
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/addgift/?id=rose&category=flower&price=3", &buf))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		var resp map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp
+	}
+	first := send(512)
+	if again := send(512); again["imagename"] != first["imagename"] {
+		t.Errorf("identical uploads stored as %v and %v", first["imagename"], again["imagename"])
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 3 {
+		t.Errorf("%d files after identical uploads, want 3", len(files))
+	}
+
+	send(300)
+	g, ok, err := repo.Get("rose", "flower")
+	if err != nil || !ok || len(g.Variants) != 2 || !blob.IsBlob(path.Base(g.Variants["small"])) {
+		t.Fatalf("gift = %+v, %v", g, err)
+	}
+	if g.Price != 3 || g.DisplayName != "rose" || !g.Active || g.Animation != gift.AnimationNone {
+		t.Errorf("catalog fields = %+v", g)
+	}
+	// the replaced original and variants are only referenced by nothing now
+	st, err := blobs.GC(0)
+	if err != nil || st.Removed != 3 || st.Blobs != 3 {
+		t.Errorf("gc = %+v, %v", st, err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 3 {
+		t.Errorf("%d files left in the image dir, want 3", len(files))
+	}
+}
+
+func TestSendGift(t *testing.T) {
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	old := gift.FreeGiftsPerDay
+	gift.FreeGiftsPerDay = 2
+	defer func() { gift.FreeGiftsPerDay = old }()
+	txs := gift.NewSQLiteTransactionRepository(db)
+	var sent []gift.Sent
+	h := gift.SendGiftHandler(gift.NewSQLiteRepository(db), txs, user.NewSQLiteRepository(db), chat.NewSQLiteRoomRepository(db), func(s gift.Sent) { sent = append(sent, s) })
+
+	send := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/gift/send", strings.NewReader(body)))
+		return rec
+	}
+	for body, code := range map[string]int{
+		`{"sender": "u1", "recipient": "u1", "gift_id": "rose"}`:                    http.StatusBadRequest,
+		`{"sender": "u1", "recipient": "nobody", "gift_id": "rose"}`:                http.StatusNotFound,
+		`{"sender": "u1", "recipient": "u2", "gift_id": "lily"}`:                    http.StatusNotFound,
+		`{"sender": "u1", "recipient": "u2"}`:                                       http.StatusBadRequest,
+		`{"sender": "u1", "recipient": "u2", "gift_id": "rose", "room": "nowhere"}`: http.StatusNotFound,
+	} {
+		if rec := send(body); rec.Code != code {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, code)
+		}
+	}
+	for _, body := range []string{
+		`{"sender": "u1", "recipient": "u2", "gift_id": "car", "room": "2d"}`,
+		`{"sender": "u1", "recipient": "u3", "gift_id": "rose"}`,
+		`{"sender": "u2", "recipient": "u3", "gift_id": "rose"}`,
+	} {
+		if rec := send(body); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+	rec := send(`{"sender": "u1", "recipient": "u2", "gift_id": "rose"}`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("over quota: status = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if len(sent) != 3 || sent[0].Room != "2d" || sent[1].Room != "general" || sent[0].Gift.Animation != gift.AnimationGIF || sent[0].Transaction.Price != 100 {
+		t.Fatalf("notified %+v", sent)
+	}
+
+	lb := gift.LeaderboardHandler(txs)
+	board := func(query string) []gift.Rank {
+		rec := httptest.NewRecorder()
+		lb(rec, httptest.NewRequest(http.MethodGet, "/gift/leaderboard"+query, nil))
+		var resp struct{ Ranks []gift.Rank }
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp.Ranks
+	}
+	if got := board(""); len(got) != 2 || got[0] != (gift.Rank{UserID: "u1", Gifts: 2, Coins: 110}) {
+		t.Errorf("senders = %+v", got)
+	}
+	if got := board("?role=recipient&limit=1"); len(got) != 1 || got[0] != (gift.Rank{UserID: "u3", Gifts: 2, Coins: 20}) {
+		t.Errorf("recipients = %+v", got)
+	}
+	if got := board("?day=2001-01-01"); len(got) != 0 {
+		t.Errorf("other day = %+v", got)
+	}
+}
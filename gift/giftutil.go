@@ -2,34 +2,96 @@ package gift
 
 import (
 	"database/sql"
+	"gosse/dbutil"
+	"gosse/imaging"
 	"log"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// Gift represents a row in the gift table
+// Animation types a client plays a gift with
+const (
+	AnimationNone       = "none"
+	AnimationGIF        = "gif"
+	AnimationLottie     = "lottie"
+	AnimationFullscreen = "fullscreen"
+)
+
+// Animations lists the known animation types
+var Animations = []string{AnimationNone, AnimationGIF, AnimationLottie, AnimationFullscreen}
+
+// Gift is one entry of the gift catalog. FileName is the stored image, while
+// DisplayName is what users see; Price is in coins.
 type Gift struct {
-	ID       string
-	Name     string
-	URL      string
-	Category string // Optional, if you want to categorize gifts
+	ID          string `json:"id"`
+	Category    string `json:"category"`
+	DisplayName string `json:"display_name"`
+	FileName    string `json:"file_name"`
+	URL         string `json:"url"`
+	Price       int    `json:"price"`
+	SortOrder   int    `json:"sort_order"`
+	Active      bool   `json:"active"`
+	Animation   string `json:"animation"`
+	// Variants maps a variant name from VariantSpecs to the url of the resized image
+	Variants map[string]string `json:"variants"`
+}
+
+// ValidAnimation reports whether a is a known animation type
+func ValidAnimation(a string) bool {
+	for _, v := range Animations {
+		if v == a {
+			return true
+		}
+	}
+	return false
+}
+
+// VariantSpecs are the resized copies rendered from every uploaded gift image
+var VariantSpecs = []imaging.Spec{
+	{Name: "small", Width: 96, Quality: 80},
+	{Name: "medium", Width: 256, Quality: 85},
 }
 
+// ImageDir is the directory gift images are stored in
+var ImageDir = "gift/images"
+
 // InitGiftDB creates the 'gift' table if it does not exist
 func InitGiftDB(dbPath string) *sql.DB {
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		log.Fatalf("failed to open db: %v", err)
 	}
+	if err := InitGiftTable(db); err != nil {
+		log.Fatalf("failed to create gift table: %v", err)
+	}
+	if err := InitTransactionTable(db); err != nil {
+		log.Fatalf("failed to create gift_transaction table: %v", err)
+	}
+	return db
+}
+
+// InitGiftTable creates the gift table on an already open database
+func InitGiftTable(db *sql.DB) error {
 	createTable := `CREATE TABLE IF NOT EXISTS gift (
         id TEXT PRIMARY KEY,
         name TEXT,
         url TEXT,
 		category TEXT
     );`
-	_, err = db.Exec(createTable)
-	if err != nil {
-		log.Fatalf("failed to create gift table: %v", err)
+	if _, err := db.Exec(createTable); err != nil {
+		return err
 	}
-	return db
+	for _, col := range []struct{ name, decl string }{
+		{"variants", "TEXT"},
+		{"display_name", "TEXT NOT NULL DEFAULT ''"},
+		{"price", "INTEGER NOT NULL DEFAULT 0"},
+		{"sort_order", "INTEGER NOT NULL DEFAULT 0"},
+		{"active", "INTEGER NOT NULL DEFAULT 1"},
+		{"animation", "TEXT NOT NULL DEFAULT 'none'"},
+	} {
+		if err := dbutil.AddColumn(db, "gift", col.name, col.decl); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,154 @@
+package gift
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+)
+
+// ListFilter narrows List; empty fields match everything
+type ListFilter struct {
+	ID       string
+	Category string
+	// Inactive includes gifts that are switched off
+	Inactive bool
+	Limit    int
+	Offset   int
+}
+
+// CategoryCount is one gift category with the number of active gifts in it
+type CategoryCount struct {
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+}
+
+// Repository is the storage contract for the gift catalog
+type Repository interface {
+	// List returns one page of matching gifts in catalog order along with the
+	// number of matching gifts
+	List(f ListFilter) ([]Gift, int, error)
+	// Get returns one gift; ok is false when it does not exist
+	Get(id, category string) (g Gift, ok bool, err error)
+	// Save updates the gift matching g.ID and g.Category or inserts it
+	Save(g Gift) error
+	// Categories returns the categories that have active gifts, in catalog order
+	Categories() ([]CategoryCount, error)
+}
+
+// SQLiteRepository implements Repository on top of the gift table
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository wraps an open database handle
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{db: db}
+}
+
+// Gifts stored before the catalog had display names show their id
+const selectGift = `SELECT id, category, COALESCE(NULLIF(display_name, ''), id), name, url, variants, price, sort_order, active, animation FROM gift`
+
+// List returns the matching rows of the gift table ordered by sort order, then name
+func (s *SQLiteRepository) List(f ListFilter) ([]Gift, int, error) {
+	var where []string
+	var args []any
+	if f.ID != "" {
+		where = append(where, "id=?")
+		args = append(args, f.ID)
+	}
+	if f.Category != "" {
+		where = append(where, "category=?")
+		args = append(args, f.Category)
+	}
+	if !f.Inactive {
+		where = append(where, "active=1")
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM gift`+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	all, err := s.query(selectGift+cond+` ORDER BY sort_order, COALESCE(NULLIF(display_name, ''), id), id LIMIT ? OFFSET ?`, append(args, limit, f.Offset)...)
+	return all, total, err
+}
+
+func (s *SQLiteRepository) query(query string, args ...any) ([]Gift, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := []Gift{}
+	for rows.Next() {
+		var g Gift
+		var name, url, variants sql.NullString
+		if err := rows.Scan(&g.ID, &g.Category, &g.DisplayName, &name, &url, &variants, &g.Price, &g.SortOrder, &g.Active, &g.Animation); err != nil {
+			return nil, err
+		}
+		g.FileName, g.URL = name.String, url.String
+		g.Variants = map[string]string{}
+		if variants.String != "" {
+			if err := json.Unmarshal([]byte(variants.String), &g.Variants); err != nil {
+				return nil, err
+			}
+		}
+		all = append(all, g)
+	}
+	return all, rows.Err()
+}
+
+// Get looks up the gift stored for id and category, active or not
+func (s *SQLiteRepository) Get(id, category string) (Gift, bool, error) {
+	all, _, err := s.List(ListFilter{ID: id, Category: category, Inactive: true})
+	if err != nil || len(all) == 0 {
+		return Gift{}, false, err
+	}
+	return all[0], true, nil
+}
+
+// Save updates an existing row or inserts a new one
+func (s *SQLiteRepository) Save(g Gift) error {
+	variants, err := json.Marshal(g.Variants)
+	if err != nil {
+		return err
+	}
+	if g.Animation == "" {
+		g.Animation = AnimationNone
+	}
+	res, err := s.db.Exec(`UPDATE gift SET url=?, name=?, variants=?, display_name=?, price=?, sort_order=?, active=?, animation=? WHERE id=? AND category=?`,
+		g.URL, g.FileName, string(variants), g.DisplayName, g.Price, g.SortOrder, g.Active, g.Animation, g.ID, g.Category)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n > 0 {
+			return nil
+		}
+	}
+	// Insert if update did not affect any row
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO gift (id, category, name, url, variants, display_name, price, sort_order, active, animation) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		g.ID, g.Category, g.FileName, g.URL, string(variants), g.DisplayName, g.Price, g.SortOrder, g.Active, g.Animation)
+	return err
+}
+
+// Categories counts the active gifts per category, ordered like the gifts in them
+func (s *SQLiteRepository) Categories() ([]CategoryCount, error) {
+	rows, err := s.db.Query(`SELECT category, COUNT(*) FROM gift WHERE active=1 GROUP BY category ORDER BY MIN(sort_order), category`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []CategoryCount{}
+	for rows.Next() {
+		var c CategoryCount
+		if err := rows.Scan(&c.Category, &c.Count); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,174 @@
+package gift
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gosse/chat"
+	"gosse/dates"
+	"gosse/user"
+)
+
+// Sent is the gift event broadcast to the viewers of a chat room: who sent
+// what to whom, with the catalog entry so clients can play its animation
+type Sent struct {
+	Room        string      `json:"room"`
+	Transaction Transaction `json:"transaction"`
+	Gift        Gift        `json:"gift"`
+}
+
+// SendNotifier is told about every gift sent
+type SendNotifier func(s Sent)
+
+// SendGiftHandler handles POST /gift/send with a JSON body naming a sender,
+// a recipient, a gift_id and the chat room it is sent in (default general).
+// Both users must be registered, the gift must be active, and every sender
+// may send FreeGiftsPerDay gifts per Yangon day.
+//
+// The sender is taken from the body as given: like the chat endpoints this
+// has no user authentication, so anyone who knows a user id can send gifts
+// in that user's name and use up their daily quota.
+func SendGiftHandler(repo Repository, txs TransactionRepository, users user.Repository, rooms chat.RoomRepository, notify SendNotifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Sender    string `json:"sender"`
+			Recipient string `json:"recipient"`
+			GiftID    string `json:"gift_id"`
+			Room      string `json:"room"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Sender, req.Recipient, req.GiftID = strings.TrimSpace(req.Sender), strings.TrimSpace(req.Recipient), strings.TrimSpace(req.GiftID)
+		if req.Sender == "" || req.Recipient == "" || req.GiftID == "" {
+			http.Error(w, "sender, recipient and gift_id are required", http.StatusBadRequest)
+			return
+		}
+		if req.Sender == req.Recipient {
+			http.Error(w, "Cannot send a gift to yourself", http.StatusBadRequest)
+			return
+		}
+		if req.Room = strings.TrimSpace(req.Room); req.Room == "" {
+			req.Room = chat.DefaultRoom
+		}
+		if _, ok, err := rooms.Room(req.Room); err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		} else if !ok {
+			http.Error(w, "Unknown room "+req.Room, http.StatusNotFound)
+			return
+		}
+		for _, id := range []string{req.Sender, req.Recipient} {
+			_, ok, err := users.Get(id)
+			if err != nil {
+				http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "Unknown user "+id, http.StatusNotFound)
+				return
+			}
+		}
+		gifts, _, err := repo.List(ListFilter{ID: req.GiftID})
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(gifts) == 0 {
+			http.Error(w, "Gift not found", http.StatusNotFound)
+			return
+		}
+		g := gifts[0]
+
+		t, used, err := txs.Record(Transaction{
+			Sender:    req.Sender,
+			Recipient: req.Recipient,
+			GiftID:    g.ID,
+			Category:  g.Category,
+			Price:     g.Price,
+			Day:       dates.Today(),
+		}, FreeGiftsPerDay)
+		if errors.Is(err, ErrQuotaExceeded) {
+			retry := time.Until(dates.Day(time.Now()).AddDate(0, 0, 1))
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  "quota exceeded",
+				"limit":   FreeGiftsPerDay,
+				"used":    used,
+				"message": "daily free gifts used up",
+			})
+			return
+		}
+		if err != nil {
+			http.Error(w, "Database insert error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if notify != nil {
+			notify(Sent{Room: req.Room, Transaction: t, Gift: g})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":      "sent",
+			"transaction": t,
+			"remaining":   FreeGiftsPerDay - used,
+		})
+	}
+}
+
+// LeaderboardHandler handles GET /gift/leaderboard?day=&role=&limit= and ranks
+// the top senders, or with role=recipient the top recipients, of a day.
+// day defaults to today and limit to 10.
+func LeaderboardHandler(txs TransactionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		day := dates.Today()
+		if v := q.Get("day"); v != "" {
+			d, err := dates.Normalize(v)
+			if err != nil {
+				http.Error(w, "Invalid day parameter", http.StatusBadRequest)
+				return
+			}
+			day = d
+		}
+		role := q.Get("role")
+		if role == "" {
+			role = RoleSender
+		}
+		if role != RoleSender && role != RoleRecipient {
+			http.Error(w, "role must be sender or recipient", http.StatusBadRequest)
+			return
+		}
+		limit := 10
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 100 {
+				http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		ranks, err := txs.Leaderboard(day, role, limit)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"day":    day,
+			"role":   role,
+			"ranks":  ranks,
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package gift
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"gosse/dates"
+)
+
+// FreeGiftsPerDay is how many gifts a user may send per Yangon day
+var FreeGiftsPerDay = 20
+
+// ErrQuotaExceeded is returned by Record when the sender has used up the day's gifts
+var ErrQuotaExceeded = errors.New("daily gift quota exceeded")
+
+// Transaction is one gift sent from one user to another. Price is the gift's
+// price when it was sent and Day the Yangon date it was sent on.
+type Transaction struct {
+	ID        int64  `json:"id"`
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	GiftID    string `json:"gift_id"`
+	Category  string `json:"category"`
+	Price     int    `json:"price"`
+	Day       string `json:"day"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Rank is one row of a daily leaderboard
+type Rank struct {
+	UserID string `json:"user_id"`
+	Gifts  int    `json:"gifts"`
+	Coins  int    `json:"coins"`
+}
+
+// Leaderboard roles
+const (
+	RoleSender    = "sender"
+	RoleRecipient = "recipient"
+)
+
+// InitTransactionTable creates the gift_transaction table if it does not exist
+func InitTransactionTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS gift_transaction (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		sender TEXT NOT NULL,
+		recipient TEXT NOT NULL,
+		gift_id TEXT NOT NULL,
+		category TEXT NOT NULL,
+		price INTEGER NOT NULL DEFAULT 0,
+		day TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS gift_transaction_sender_day ON gift_transaction (sender, day);
+	CREATE INDEX IF NOT EXISTS gift_transaction_recipient_day ON gift_transaction (recipient, day);`)
+	return err
+}
+
+// TransactionRepository is the storage contract for sent gifts
+type TransactionRepository interface {
+	// Record stores t unless its sender already sent limit gifts on t.Day, in
+	// which case it returns ErrQuotaExceeded; used is the sender's count for the
+	// day including t when it was stored
+	Record(t Transaction, limit int) (saved Transaction, used int, err error)
+	// Sent returns how many gifts sender sent on day
+	Sent(sender, day string) (int, error)
+	// Leaderboard returns the top limit senders or recipients of day
+	Leaderboard(day, role string, limit int) ([]Rank, error)
+}
+
+// SQLiteTransactionRepository implements TransactionRepository on top of the gift_transaction table
+type SQLiteTransactionRepository struct {
+	db *sql.DB
+	// mu makes the quota check and the insert of Record one step
+	mu sync.Mutex
+}
+
+// NewSQLiteTransactionRepository wraps an open database handle
+func NewSQLiteTransactionRepository(db *sql.DB) *SQLiteTransactionRepository {
+	return &SQLiteTransactionRepository{db: db}
+}
+
+// Record inserts t, stamping its id and creation time
+func (s *SQLiteTransactionRepository) Record(t Transaction, limit int) (Transaction, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	used, err := s.Sent(t.Sender, t.Day)
+	if err != nil {
+		return t, 0, err
+	}
+	if used >= limit {
+		return t, used, ErrQuotaExceeded
+	}
+	now := time.Now()
+	res, err := s.db.Exec(`INSERT INTO gift_transaction (sender, recipient, gift_id, category, price, day, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.Sender, t.Recipient, t.GiftID, t.Category, t.Price, t.Day, now.Unix())
+	if err != nil {
+		return t, used, err
+	}
+	if t.ID, err = res.LastInsertId(); err != nil {
+		return t, used, err
+	}
+	t.CreatedAt = now.In(dates.Location()).Format(time.RFC3339)
+	return t, used + 1, nil
+}
+
+// Sent counts the rows of sender on day
+func (s *SQLiteTransactionRepository) Sent(sender, day string) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM gift_transaction WHERE sender=? AND day=?`, sender, day).Scan(&n)
+	return n, err
+}
+
+// Leaderboard ranks users by gifts, then coins, then id
+func (s *SQLiteTransactionRepository) Leaderboard(day, role string, limit int) ([]Rank, error) {
+	col := "sender"
+	if role == RoleRecipient {
+		col = "recipient"
+	}
+	rows, err := s.db.Query(`SELECT `+col+`, COUNT(*), COALESCE(SUM(price), 0) FROM gift_transaction WHERE day=?
+		GROUP BY `+col+` ORDER BY COUNT(*) DESC, SUM(price) DESC, `+col+` LIMIT ?`, day, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Rank{}
+	for rows.Next() {
+		var r Rank
+		if err := rows.Scan(&r.UserID, &r.Gifts, &r.Coins); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,68 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"strconv"
+)
+
+// dHash grid: 9x8 cells give 8 horizontal comparisons per row
+const (
+	hashCols = 9
+	hashRows = 8
+	// samples per cell along each axis; big images are sampled, not resized
+	hashSamples = 16
+)
+
+// DHash returns the difference hash of img: the image is reduced to a 9x8
+// grey grid and each bit records whether a cell is brighter than its right
+// neighbour. Re-encoded, rescaled or slightly cropped copies of an image have
+// hashes that differ in only a few bits.
+func DHash(img image.Image) uint64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return 0
+	}
+	var grid [hashRows][hashCols]float64
+	for cy := 0; cy < hashRows; cy++ {
+		for cx := 0; cx < hashCols; cx++ {
+			var sum float64
+			for sy := 0; sy < hashSamples; sy++ {
+				y := b.Min.Y + ((cy*hashSamples+sy)*2+1)*h/(2*hashRows*hashSamples)
+				for sx := 0; sx < hashSamples; sx++ {
+					x := b.Min.X + ((cx*hashSamples+sx)*2+1)*w/(2*hashCols*hashSamples)
+					sum += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+				}
+			}
+			grid[cy][cx] = sum
+		}
+	}
+	var hash uint64
+	for y := 0; y < hashRows; y++ {
+		for x := 0; x < hashCols-1; x++ {
+			hash <<= 1
+			if grid[y][x] > grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HashDistance returns the number of bits in which two hashes differ
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash renders a hash as 16 hex digits
+func FormatHash(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
+
+// ParseHash reads a hash written by FormatHash
+func ParseHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
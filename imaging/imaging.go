@@ -0,0 +1,290 @@
+// Package imaging decodes uploaded images, strips their metadata and renders
+// resized JPEG variants.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Formats as reported by Decode
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+)
+
+// ErrUnsupported is returned for data that is not a JPEG, PNG or GIF image
+var ErrUnsupported = errors.New("unsupported image format, use JPEG, PNG or GIF")
+
+// Spec describes one resized variant: the image is scaled down to Width pixels
+// (never up) and encoded as JPEG at Quality
+type Spec struct {
+	Name    string
+	Width   int
+	Quality int
+}
+
+// ParseSpecs reads a comma separated list of name:width:quality triples such as
+// "low:720:70,thumb:240:60"
+func ParseSpecs(s string) ([]Spec, error) {
+	var specs []Spec
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		f := strings.Split(part, ":")
+		if len(f) != 3 || f[0] == "" {
+			return nil, fmt.Errorf("variant %q: want name:width:quality", part)
+		}
+		width, err := strconv.Atoi(f[1])
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("variant %q: invalid width", part)
+		}
+		quality, err := strconv.Atoi(f[2])
+		if err != nil || quality < 1 || quality > 100 {
+			return nil, fmt.Errorf("variant %q: quality must be 1-100", part)
+		}
+		specs = append(specs, Spec{Name: f[0], Width: width, Quality: quality})
+	}
+	return specs, nil
+}
+
+// Ext returns the file extension for a format
+func Ext(format string) string {
+	switch format {
+	case FormatJPEG:
+		return ".jpg"
+	case FormatGIF:
+		return ".gif"
+	}
+	return ".png"
+}
+
+// Variant is one rendered variant
+type Variant struct {
+	Spec   Spec
+	Data   []byte
+	Width  int
+	Height int
+}
+
+// Result is a processed upload: the original without metadata and its variants
+type Result struct {
+	Format   string
+	Original []byte
+	Width    int
+	Height   int
+	Variants []Variant
+}
+
+// Process decodes data, strips its metadata and renders one JPEG per spec
+func Process(data []byte, specs []Spec) (Result, error) {
+	img, format, err := Decode(data)
+	if err != nil {
+		return Result{}, err
+	}
+	return ProcessDecoded(data, img, format, specs)
+}
+
+// ProcessDecoded is Process for data that the caller already decoded into img
+// with Decode. A JPEG with an EXIF orientation is stored re-encoded from the
+// upright img, since stripping the metadata drops the orientation tag.
+func ProcessDecoded(data []byte, img image.Image, format string, specs []Spec) (Result, error) {
+	var err error
+	b := img.Bounds()
+	res := Result{Format: format, Width: b.Dx(), Height: b.Dy()}
+	if format == FormatJPEG && Orientation(data) > 1 {
+		if res.Original, err = reencode(img, format); err != nil {
+			return Result{}, err
+		}
+	} else if res.Original, err = StripMetadata(data, format); err != nil {
+		// the pixels decoded fine, so store a clean re-encoding instead
+		if res.Original, err = reencode(img, format); err != nil {
+			return Result{}, err
+		}
+	}
+	for _, spec := range specs {
+		small := Resize(img, spec.Width)
+		out, err := EncodeJPEG(small, spec.Quality)
+		if err != nil {
+			return Result{}, fmt.Errorf("render %s: %w", spec.Name, err)
+		}
+		sb := small.Bounds()
+		res.Variants = append(res.Variants, Variant{Spec: spec, Data: out, Width: sb.Dx(), Height: sb.Dy()})
+	}
+	return res, nil
+}
+
+// Decode reads a JPEG, PNG or GIF image; for animated GIFs the first frame is
+// returned. JPEGs are turned upright according to their EXIF orientation.
+func Decode(data []byte) (image.Image, string, error) {
+	var img image.Image
+	var err error
+	format := Sniff(data)
+	switch format {
+	case FormatJPEG:
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case FormatPNG:
+		img, err = png.Decode(bytes.NewReader(data))
+	case FormatGIF:
+		img, err = gif.Decode(bytes.NewReader(data))
+	default:
+		return nil, "", ErrUnsupported
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("decode %s: %w", format, err)
+	}
+	if format == FormatJPEG {
+		img = Orient(img, Orientation(data))
+	}
+	return img, format, nil
+}
+
+// Sniff returns the format indicated by the leading magic bytes, or ""
+func Sniff(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return FormatJPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return FormatGIF
+	}
+	return ""
+}
+
+// EncodeJPEG encodes img at quality, flattening transparency onto white
+func EncodeJPEG(img image.Image, quality int) ([]byte, error) {
+	b := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, b.Min, draw.Over)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// reencode writes img again in its own format, which drops every metadata block
+func reencode(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatJPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 92})
+	case FormatGIF:
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+// Resize scales img down to width pixels, keeping the aspect ratio, by
+// averaging the source pixels each target pixel covers. Images that are
+// already narrow enough are returned unchanged.
+func Resize(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if width <= 0 || width >= sw || sh == 0 {
+		return img
+	}
+	height := int(math.Round(float64(sh) * float64(width) / float64(sw)))
+	if height < 1 {
+		height = 1
+	}
+	src := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	// horizontal pass into a float buffer of width x sh, then vertical pass
+	xs := contributions(sw, width)
+	tmp := make([]float32, width*sh*4)
+	for y := 0; y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, c := range xs {
+			var r, g, bl, a float32
+			for i, w := range c.weights {
+				p := row[(c.start+i)*4:]
+				r += w * float32(p[0])
+				g += w * float32(p[1])
+				bl += w * float32(p[2])
+				a += w * float32(p[3])
+			}
+			o := (y*width + x) * 4
+			tmp[o], tmp[o+1], tmp[o+2], tmp[o+3] = r, g, bl, a
+		}
+	}
+	ys := contributions(sh, height)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, c := range ys {
+		for x := 0; x < width; x++ {
+			var px [4]float32
+			for i, w := range c.weights {
+				o := ((c.start+i)*width + x) * 4
+				px[0] += w * tmp[o]
+				px[1] += w * tmp[o+1]
+				px[2] += w * tmp[o+2]
+				px[3] += w * tmp[o+3]
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			for i := range px {
+				d[i] = clamp(px[i])
+			}
+		}
+	}
+	return dst
+}
+
+// contribution lists the weights of the source pixels starting at start that make up one target pixel
+type contribution struct {
+	start   int
+	weights []float32
+}
+
+func contributions(src, dst int) []contribution {
+	scale := float64(src) / float64(dst)
+	out := make([]contribution, dst)
+	for i := range out {
+		lo, hi := float64(i)*scale, float64(i+1)*scale
+		start, end := int(lo), int(math.Ceil(hi))
+		if end > src {
+			end = src
+		}
+		ws := make([]float32, end-start)
+		var sum float64
+		for j := start; j < end; j++ {
+			w := math.Min(hi, float64(j+1)) - math.Max(lo, float64(j))
+			ws[j-start] = float32(w)
+			sum += w
+		}
+		for j := range ws {
+			ws[j] /= float32(sum)
+		}
+		out[i] = contribution{start: start, weights: ws}
+	}
+	return out
+}
+
+func clamp(v float32) uint8 {
+	v = float32(math.Round(float64(v)))
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
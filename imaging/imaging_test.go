@@ -0,0 +1,212 @@
+package imaging_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+
+	"gosse/imaging"
+)
+
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	return img
+}
+
+// withEXIF inserts an APP1 segment and a comment right after the SOI marker
+func withEXIF(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	exif := append([]byte{0xFF, 0xE1, 0x00, 0x10}, []byte("Exif\x00\x00GPS-DATA")...)
+	comment := append([]byte{0xFF, 0xFE, 0x00, 0x07}, []byte("hello")...)
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), append(exif, comment...)...), data[2:]...)
+}
+
+func TestProcess(t *testing.T) {
+	data := withEXIF(t, testImage(200, 100))
+	res, err := imaging.Process(data, []imaging.Spec{{Name: "low", Width: 50, Quality: 70}, {Name: "big", Width: 400, Quality: 80}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Format != imaging.FormatJPEG || res.Width != 200 || res.Height != 100 {
+		t.Errorf("result = %s %dx%d", res.Format, res.Width, res.Height)
+	}
+	if bytes.Contains(res.Original, []byte("GPS-DATA")) || bytes.Contains(res.Original, []byte("hello")) {
+		t.Error("metadata was not stripped")
+	}
+	if len(res.Original) != len(data)-16-2-9 {
+		t.Errorf("stripped %d bytes, want %d", len(data)-len(res.Original), 16+2+9)
+	}
+	if _, _, err := imaging.Decode(res.Original); err != nil {
+		t.Errorf("stripped original does not decode: %v", err)
+	}
+	// variants are scaled down but never up
+	want := [][2]int{{50, 25}, {200, 100}}
+	for i, v := range res.Variants {
+		if v.Width != want[i][0] || v.Height != want[i][1] {
+			t.Errorf("%s: %dx%d, want %v", v.Spec.Name, v.Width, v.Height, want[i])
+		}
+		if imaging.Sniff(v.Data) != imaging.FormatJPEG {
+			t.Errorf("%s is not a JPEG", v.Spec.Name)
+		}
+	}
+}
+
+// withOrientation inserts an APP1 segment whose IFD0 holds only the orientation tag
+func withOrientation(t *testing.T, img image.Image, o byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00")
+	tiff = append(tiff, o, 0, 0, 0, 0, 0, 0)
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := append([]byte{0xFF, 0xE1, 0, byte(len(seg) + 2)}, seg...)
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+func TestProcessAppliesOrientation(t *testing.T) {
+	// left half red, right half blue, stored sideways
+	img := image.NewRGBA(image.Rect(0, 0, 80, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 80; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= 40 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	red := func(im image.Image, x, y int) bool {
+		r, _, b, _ := im.At(x, y).RGBA()
+		return r > b
+	}
+
+	// 6: rotate clockwise, so the left half ends up on top; 8: on the bottom
+	for o, topRed := range map[byte]bool{6: true, 8: false} {
+		data := withOrientation(t, img, o)
+		if got := imaging.Orientation(data); got != int(o) {
+			t.Fatalf("Orientation = %d, want %d", got, o)
+		}
+		res, err := imaging.Process(data, []imaging.Spec{{Name: "low", Width: 20, Quality: 90}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Width != 40 || res.Height != 80 {
+			t.Errorf("orientation %d: result is %dx%d, want 40x80", o, res.Width, res.Height)
+		}
+		orig, _, err := imaging.Decode(res.Original)
+		if err != nil {
+			t.Fatal(err)
+		}
+		low, _, err := imaging.Decode(res.Variants[0].Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := orig.Bounds(); b.Dx() != 40 || b.Dy() != 80 || red(orig, 20, 10) != topRed || red(orig, 20, 70) == topRed {
+			t.Errorf("orientation %d: original %v is not upright", o, b)
+		}
+		if b := low.Bounds(); b.Dx() != 20 || b.Dy() != 40 || red(low, 10, 5) != topRed {
+			t.Errorf("orientation %d: variant %v is not upright", o, b)
+		}
+	}
+}
+
+func TestResizeAveragesPixels(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			c := color.RGBA{0, 0, 0, 255}
+			if x%2 == 0 {
+				c = color.RGBA{200, 100, 50, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	small := imaging.Resize(img, 2)
+	if b := small.Bounds(); b.Dx() != 2 || b.Dy() != 1 {
+		t.Fatalf("bounds = %v", b)
+	}
+	if got := small.At(1, 0).(color.RGBA); got != (color.RGBA{100, 50, 25, 255}) {
+		t.Errorf("pixel = %v", got)
+	}
+}
+
+func TestPNGAndRejects(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage(10, 10))
+	if _, err := imaging.Process(buf.Bytes(), nil); err != nil {
+		t.Errorf("png: %v", err)
+	}
+	for _, data := range [][]byte{[]byte("not an image"), buf.Bytes()[:40]} {
+		if _, err := imaging.Process(data, nil); err == nil {
+			t.Errorf("%q accepted", data[:8])
+		}
+	}
+}
+
+func TestParseSpecs(t *testing.T) {
+	specs, err := imaging.ParseSpecs("low:720:70, thumb:240:60")
+	if err != nil || len(specs) != 2 || specs[1] != (imaging.Spec{Name: "thumb", Width: 240, Quality: 60}) {
+		t.Errorf("specs = %+v, %v", specs, err)
+	}
+	for _, bad := range []string{"low:720", "low:0:70", "low:720:101", ":720:70"} {
+		if _, err := imaging.ParseSpecs(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+// scene draws smooth light and dark areas, like a scanned page
+func scene(w, h int, phase float64) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			v := 128 + 60*math.Sin(7*fx+phase)*math.Cos(5*fy) + 60*math.Sin(11*fx*fy+2*phase)
+			img.SetGray(x, y, color.Gray{uint8(v)})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	orig := scene(800, 600, 0)
+	h := imaging.DHash(orig)
+
+	// a smaller JPEG re-encoding and a slightly cropped copy stay close
+	small, _ := imaging.EncodeJPEG(imaging.Resize(orig, 300), 60)
+	decoded, _, err := imaging.Decode(small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cropped := orig.SubImage(image.Rect(16, 12, 784, 588))
+	for name, img := range map[string]image.Image{"resized": decoded, "cropped": cropped} {
+		if d := imaging.HashDistance(h, imaging.DHash(img)); d > 6 {
+			t.Errorf("%s copy: distance %d", name, d)
+		}
+	}
+	if d := imaging.HashDistance(h, imaging.DHash(scene(800, 600, 2))); d < 16 {
+		t.Errorf("different image: distance %d", d)
+	}
+
+	s := imaging.FormatHash(h)
+	if back, err := imaging.ParseHash(s); err != nil || back != h || len(s) != 16 {
+		t.Errorf("hash %s round trip = %x, %v", s, back, err)
+	}
+}
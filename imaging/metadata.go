@@ -0,0 +1,106 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("malformed image structure")
+
+// StripMetadata removes EXIF, XMP, IPTC and comments from a JPEG and the text,
+// time and EXIF chunks from a PNG without re-encoding the pixels. JFIF, ICC
+// colour profiles and Adobe colour markers are kept. GIF data is returned as is.
+func StripMetadata(data []byte, format string) ([]byte, error) {
+	switch format {
+	case FormatJPEG:
+		return stripJPEG(data)
+	case FormatPNG:
+		return stripPNG(data)
+	}
+	return data, nil
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformed
+	}
+	var out bytes.Buffer
+	out.Write(data[:2])
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, errMalformed
+		}
+		// skip fill bytes
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			return nil, errMalformed
+		}
+		marker := data[i+1]
+		if marker == 0xD9 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return nil, errMalformed
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + n
+		if n < 2 || end > len(data) {
+			return nil, errMalformed
+		}
+		if marker == 0xDA {
+			// start of scan: the rest is entropy-coded data
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+		keep := true
+		switch {
+		case marker == 0xFE: // comment
+			keep = false
+		case marker >= 0xE0 && marker <= 0xEF:
+			// APP0 JFIF, APP2 ICC profile and APP14 Adobe affect decoding
+			keep = marker == 0xE0 || marker == 0xE2 || marker == 0xEE
+		}
+		if keep {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return out.Bytes(), nil
+}
+
+var pngDrop = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+func stripPNG(data []byte) ([]byte, error) {
+	const sigLen = 8
+	if len(data) < sigLen {
+		return nil, errMalformed
+	}
+	var out bytes.Buffer
+	out.Write(data[:sigLen])
+	i := sigLen
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + n
+		if n < 0 || end > len(data) {
+			return nil, errMalformed
+		}
+		typ := string(data[i+4 : i+8])
+		if !pngDrop[typ] {
+			out.Write(data[i:end])
+		}
+		i = end
+		if typ == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
@@ -1,16 +1,20 @@
 package lottosociety
 
 import (
-	"database/sql"
 	"encoding/json"
+	"fmt"
+	"gosse/audit"
+	"gosse/dates"
 	"net/http"
 )
 
-// LottoSociety represents a lottery entry
-// LottoSociety represents the structure of the lottery data
+// ResultNotifier is told about every draw stored or updated through the API
+type ResultNotifier func(l LottoSociety)
 
-// AddOrUpdateLottoHandler handles POST /addlotto to update by date or insert new row
-func AddOrUpdateLottoHandler(db *sql.DB) http.HandlerFunc {
+// AddOrUpdateLottoHandler handles POST /addlotto to update by date or insert new row.
+// The body may carry the structured "prizes" table; older clients that only send
+// fnum and snum get the first prize, adjacent prizes and 2-digit back derived from them.
+func AddOrUpdateLottoHandler(repo Repository, notify ResultNotifier) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -21,53 +25,116 @@ func AddOrUpdateLottoHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		explicit := req.Prizes != nil
+		if err := normalizeDraw(&req); err != nil {
+			http.Error(w, "Invalid prizes: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 		if req.Date != "" {
-			if req.Date == "Invalid Date" {
+			// Clients send dates in many shapes (including JavaScript's "Invalid Date"); store ISO
+			date, err := dates.Normalize(req.Date)
+			if err != nil {
 				http.Error(w, "Invalid date value", http.StatusBadRequest)
 				return
 			}
+			req.Date = date
+			audit.SetTarget(r, req.Date)
 			// Check if date exists
-			var exists string
-			err := db.QueryRow("SELECT date FROM lottosociety WHERE date=?", req.Date).Scan(&exists)
-			if err == nil {
+			exists, err := repo.ExistsByDate(req.Date)
+			if err != nil {
+				http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if exists {
 				// Date exists, update row
-				_, err := db.Exec("UPDATE lottosociety SET thaidate=?, fnum=?, snum=?, id=?, text=? WHERE date=?", req.ThaiDate, req.FNum, req.SNum, req.ID, req.Text, req.Date)
-				if err != nil {
+				if !explicit {
+					if err := keepStoredTiers(repo, &req); err != nil {
+						http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+						return
+					}
+				}
+				if err := repo.UpdateByDate(req); err != nil {
 					http.Error(w, "Database update error: "+err.Error(), http.StatusInternalServerError)
 					return
 				}
+				if notify != nil {
+					notify(req)
+				}
 				w.Header().Set("Content-Type", "application/json")
 				json.NewEncoder(w).Encode(map[string]interface{}{
 					"status": "updated",
 					"date":   req.Date,
 				})
 				return
-			} else if err == sql.ErrNoRows {
-				// Date not found, insert new row
-				_, err := db.Exec("INSERT INTO lottosociety (date, thaidate, fnum, snum, id, text) VALUES (?, ?, ?, ?, ?, ?)", req.Date, req.ThaiDate, req.FNum, req.SNum, req.ID, req.Text)
-				if err != nil {
-					http.Error(w, "Database insert error: "+err.Error(), http.StatusInternalServerError)
-					return
-				}
-				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(map[string]interface{}{
-					"status": "inserted",
-				})
-				return
-			} else {
-				http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
-				return
 			}
 		}
-		// No date, insert new row
-		_, err := db.Exec("INSERT INTO lottosociety (date, thaidate, fnum, snum, id, text) VALUES (?, ?, ?, ?, ?, ?)", req.Date, req.ThaiDate, req.FNum, req.SNum, req.ID, req.Text)
-		if err != nil {
+		// Date not found or no date, insert new row
+		if err := repo.Insert(req); err != nil {
 			http.Error(w, "Database insert error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if notify != nil {
+			notify(req)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status": "inserted",
 		})
 	}
 }
+
+// keepStoredTiers merges the tiers derived from a legacy update into the stored
+// prize table, so older clients do not wipe the lists they cannot send
+func keepStoredTiers(repo Repository, l *LottoSociety) error {
+	stored, err := repo.ByDate(l.Date)
+	if err != nil || len(stored) == 0 || stored[0].Prizes == nil {
+		return err
+	}
+	merged := *stored[0].Prizes
+	if l.Prizes != nil {
+		if l.Prizes.First != "" {
+			merged.First, merged.Adjacent = l.Prizes.First, l.Prizes.Adjacent
+		}
+		if l.Prizes.Back2 != "" {
+			merged.Back2 = l.Prizes.Back2
+		}
+	}
+	l.Prizes = &merged
+	return nil
+}
+
+// normalizeDraw validates the prize tiers of l and keeps them in step with the
+// legacy fields; legacy fields that contradict the tiers are rejected
+func normalizeDraw(l *LottoSociety) error {
+	if l.FNum != "" && !isDigits(l.FNum, 6) {
+		return fmt.Errorf("fnum %q is not a 6-digit number", l.FNum)
+	}
+	if l.SNum != "" && !isDigits(l.SNum, 2) {
+		return fmt.Errorf("snum %q is not a 2-digit number", l.SNum)
+	}
+	if l.Prizes == nil {
+		if p := legacyPrizes(l.FNum, l.SNum); !p.IsEmpty() {
+			l.Prizes = &p
+		}
+		return nil
+	}
+	p, err := l.Prizes.Normalize()
+	if err != nil {
+		return err
+	}
+	l.Prizes = &p
+	if l.FNum != "" && p.First != "" && l.FNum != p.First {
+		return fmt.Errorf("fnum %q does not match prizes.first %q", l.FNum, p.First)
+	}
+	if l.SNum != "" && p.Back2 != "" && l.SNum != p.Back2 {
+		return fmt.Errorf("snum %q does not match prizes.back2 %q", l.SNum, p.Back2)
+	}
+	// Older app versions only read fnum and snum
+	if l.FNum == "" {
+		l.FNum = p.First
+	}
+	if l.SNum == "" {
+		l.SNum = p.Back2
+	}
+	return nil
+}
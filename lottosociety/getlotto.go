@@ -1,45 +1,63 @@
 package lottosociety
 
 import (
-	"database/sql"
 	"encoding/json"
+	"gosse/dates"
 	"net/http"
 )
 
-// GetLottoHandler handles GET /getlotto?date=... or ?last=true to return lotto rows by date, all, or just the latest
-func GetLottoHandler(db *sql.DB) http.HandlerFunc {
+// GetLottoHandler handles GET /getlotto?date=... or ?last=true to return lotto rows by date, all, or just the latest.
+// Without date, optional from and to parameters limit the dates returned. Dates
+// are written as 2025/08/15 unless date_format=iso is given.
+func GetLottoHandler(repo Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		layout, err := dates.OutputLayout(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		date := r.URL.Query().Get("date")
+		if date != "" {
+			if date, err = dates.Normalize(date); err != nil {
+				http.Error(w, "Invalid date value", http.StatusBadRequest)
+				return
+			}
+		}
 		last := r.URL.Query().Get("last")
-		var rows *sql.Rows
-		var err error
 		if last == "true" {
-			rows, err = db.Query("SELECT date, thaidate, fnum, snum, id, text FROM lottosociety ORDER BY date DESC LIMIT 1")
-		} else if date != "" {
-			rows, err = db.Query("SELECT date, thaidate, fnum, snum, id, text FROM lottosociety WHERE date=?", date)
+			l, ok, err := repo.Latest()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if ok {
+				l.Date = dates.Format(l.Date, layout)
+				json.NewEncoder(w).Encode(l)
+			} else {
+				json.NewEncoder(w).Encode([]LottoSociety(nil))
+			}
+			return
+		}
+		var all []LottoSociety
+		if date != "" {
+			all, err = repo.ByDate(date)
 		} else {
-			rows, err = db.Query("SELECT date, thaidate, fnum, snum, id, text FROM lottosociety ORDER BY date DESC")
+			from, to, rerr := dates.ParseRange(r)
+			if rerr != nil {
+				http.Error(w, rerr.Error(), http.StatusBadRequest)
+				return
+			}
+			all, err = repo.Between(from, to)
 		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-		var all []LottoSociety
-		for rows.Next() {
-			var l LottoSociety
-			err := rows.Scan(&l.Date, &l.ThaiDate, &l.FNum, &l.SNum, &l.ID, &l.Text)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			all = append(all, l)
+		for i := range all {
+			all[i].Date = dates.Format(all[i].Date, layout)
 		}
 		w.Header().Set("Content-Type", "application/json")
-		if last == "true" && len(all) > 0 {
-			json.NewEncoder(w).Encode(all[0])
-		} else {
-			json.NewEncoder(w).Encode(all)
-		}
+		json.NewEncoder(w).Encode(all)
 	}
 }
@@ -0,0 +1,173 @@
+package lottosociety_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gosse/lottosociety"
+	"gosse/storage"
+)
+
+func newRepo(t *testing.T) *lottosociety.SQLiteRepository {
+	t.Helper()
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return lottosociety.NewSQLiteRepository(db)
+}
+
+func TestGetLottoHandlerLast(t *testing.T) {
+	repo := newRepo(t)
+	rec := httptest.NewRecorder()
+	lottosociety.GetLottoHandler(repo)(rec, httptest.NewRequest(http.MethodGet, "/lottosociety/getlotto?last=true", nil))
+	var got lottosociety.LottoSociety
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Date != "2025/08/01" || got.FNum != "994865" {
+		t.Fatalf("unexpected latest draw: %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	lottosociety.GetLottoHandler(repo)(rec, httptest.NewRequest(http.MethodGet, "/lottosociety/getlotto?last=true&date_format=iso", nil))
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Date != "2025-08-01" {
+		t.Fatalf("iso date = %q", got.Date)
+	}
+}
+
+func TestGetLottoHandlerByDate(t *testing.T) {
+	repo := newRepo(t)
+	rec := httptest.NewRecorder()
+	lottosociety.GetLottoHandler(repo)(rec, httptest.NewRequest(http.MethodGet, "/lottosociety/getlotto?date=2025/07/16", nil))
+	var got []lottosociety.LottoSociety
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].SNum != "46" {
+		t.Fatalf("unexpected rows: %+v", got)
+	}
+}
+
+func TestAddOrUpdateLottoHandler(t *testing.T) {
+	repo := newRepo(t)
+	h := lottosociety.AddOrUpdateLottoHandler(repo, nil)
+
+	post := func(body string) map[string]any {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/lottosociety/addlotto", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+		}
+		var resp map[string]any
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp
+	}
+
+	if resp := post(`{"date":"2025-08-01","fnum":"111111","snum":"11"}`); resp["status"] != "updated" {
+		t.Fatalf("status = %v, want updated", resp["status"])
+	}
+	if resp := post(`{"date":"2025-08-16","fnum":"222222","snum":"22"}`); resp["status"] != "inserted" {
+		t.Fatalf("status = %v, want inserted", resp["status"])
+	}
+	rows, err := repo.ByDate("2025-08-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].FNum != "111111" {
+		t.Fatalf("update not applied: %+v", rows)
+	}
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/lottosociety/addlotto", strings.NewReader(`{"date":"Invalid Date"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestStructuredPrizes(t *testing.T) {
+	repo := newRepo(t)
+	h := lottosociety.AddOrUpdateLottoHandler(repo, nil)
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/lottosociety/addlotto", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	full := `{"date":"2025-08-16","thaidate":"16 ส.ค. 2568","prizes":{
+		"first":"000000","front3":["123","456"],"back3":["789","012"],"back2":"34",
+		"second":["111111","222222"]}}`
+	if got := post(full); got != http.StatusOK {
+		t.Fatalf("insert status = %d", got)
+	}
+	rows, err := repo.ByDate("2025-08-16")
+	if err != nil || len(rows) != 1 || rows[0].Prizes == nil {
+		t.Fatalf("ByDate = %+v, %v", rows, err)
+	}
+	got := rows[0]
+	if got.FNum != "000000" || got.SNum != "34" {
+		t.Errorf("legacy fields not filled: %+v", got)
+	}
+	p := got.Prizes
+	if p.Adjacent[0] != "999999" || p.Adjacent[1] != "000001" || len(p.Second) != 2 || len(p.Fifth) != 0 {
+		t.Errorf("unexpected prizes: %+v", p)
+	}
+
+	// A legacy update changes the first prize but keeps the other tiers
+	if got := post(`{"date":"2025-08-16","fnum":"654321","snum":"34"}`); got != http.StatusOK {
+		t.Fatalf("legacy update status = %d", got)
+	}
+	rows, _ = repo.ByDate("2025-08-16")
+	if p := rows[0].Prizes; p.First != "654321" || p.Adjacent[1] != "654322" || len(p.Front3) != 2 {
+		t.Errorf("legacy update lost tiers: %+v", p)
+	}
+
+	for _, bad := range []string{
+		`{"date":"2025-09-01","prizes":{"first":"12345"}}`,
+		`{"date":"2025-09-01","prizes":{"front3":["123","456","789"]}}`,
+		`{"date":"2025-09-01","prizes":{"second":["111111","111111"]}}`,
+		`{"date":"2025-09-01","fnum":"12ab56"}`,
+		`{"date":"2025-09-01","fnum":"111111","prizes":{"first":"222222"}}`,
+		`{"date":"2025-09-01","snum":"11","prizes":{"back2":"22"}}`,
+	} {
+		if got := post(bad); got != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", bad, got)
+		}
+	}
+}
+
+func TestPrizeMigration(t *testing.T) {
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Fixtures are loaded after the schema, so run the migration again as a restart would
+	if err := lottosociety.InitPrizeTable(db); err != nil {
+		t.Fatal(err)
+	}
+	l, ok, err := lottosociety.NewSQLiteRepository(db).Latest()
+	if err != nil || !ok || l.Prizes == nil {
+		t.Fatalf("Latest = %+v, %v, %v", l, ok, err)
+	}
+	if l.Prizes.First != "994865" || l.Prizes.Back2 != "30" || len(l.Prizes.Adjacent) != 2 {
+		t.Fatalf("unexpected migrated prizes: %+v", l.Prizes)
+	}
+	// every listed draw gets its own tiers
+	all, err := lottosociety.NewSQLiteRepository(db).List()
+	if err != nil || len(all) < 2 {
+		t.Fatalf("List = %+v, %v", all, err)
+	}
+	for _, d := range all {
+		if d.Prizes == nil || d.Prizes.First != d.FNum {
+			t.Errorf("%s: prizes %+v, fnum %s", d.Date, d.Prizes, d.FNum)
+		}
+	}
+}
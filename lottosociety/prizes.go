@@ -0,0 +1,314 @@
+package lottosociety
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Prize tiers of the Thai government lottery
+const (
+	TierFirst    = "first"
+	TierAdjacent = "adjacent"
+	TierFront3   = "front3"
+	TierBack3    = "back3"
+	TierBack2    = "back2"
+	TierSecond   = "second"
+	TierThird    = "third"
+	TierFourth   = "fourth"
+	TierFifth    = "fifth"
+)
+
+// Prizes is the full prize table of one draw. Lists may be shorter than
+// their maximum while a draw is still being announced.
+type Prizes struct {
+	First    string   `json:"first"`
+	Adjacent []string `json:"adjacent"`
+	Front3   []string `json:"front3"`
+	Back3    []string `json:"back3"`
+	Back2    string   `json:"back2"`
+	Second   []string `json:"second"`
+	Third    []string `json:"third"`
+	Fourth   []string `json:"fourth"`
+	Fifth    []string `json:"fifth"`
+}
+
+// tierSpec describes how many numbers of how many digits a tier holds
+type tierSpec struct {
+	name   string
+	digits int
+	max    int
+}
+
+var tierSpecs = []tierSpec{
+	{TierFirst, 6, 1},
+	{TierAdjacent, 6, 2},
+	{TierFront3, 3, 2},
+	{TierBack3, 3, 2},
+	{TierBack2, 2, 1},
+	{TierSecond, 6, 5},
+	{TierThird, 6, 10},
+	{TierFourth, 6, 50},
+	{TierFifth, 6, 100},
+}
+
+// Tiers returns the numbers of every tier keyed by tier name
+func (p Prizes) Tiers() map[string][]string {
+	one := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return []string{s}
+	}
+	return map[string][]string{
+		TierFirst:    one(p.First),
+		TierAdjacent: p.Adjacent,
+		TierFront3:   p.Front3,
+		TierBack3:    p.Back3,
+		TierBack2:    one(p.Back2),
+		TierSecond:   p.Second,
+		TierThird:    p.Third,
+		TierFourth:   p.Fourth,
+		TierFifth:    p.Fifth,
+	}
+}
+
+// setTier stores numbers under the named tier
+func (p *Prizes) setTier(tier string, numbers []string) {
+	first := func() string {
+		if len(numbers) == 0 {
+			return ""
+		}
+		return numbers[0]
+	}
+	switch tier {
+	case TierFirst:
+		p.First = first()
+	case TierAdjacent:
+		p.Adjacent = numbers
+	case TierFront3:
+		p.Front3 = numbers
+	case TierBack3:
+		p.Back3 = numbers
+	case TierBack2:
+		p.Back2 = first()
+	case TierSecond:
+		p.Second = numbers
+	case TierThird:
+		p.Third = numbers
+	case TierFourth:
+		p.Fourth = numbers
+	case TierFifth:
+		p.Fifth = numbers
+	}
+}
+
+// IsEmpty reports whether no tier holds a number
+func (p Prizes) IsEmpty() bool {
+	for _, nums := range p.Tiers() {
+		if len(nums) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Normalize trims every number, fills the adjacent prizes from the first prize
+// when they are missing, and checks digit counts and list lengths.
+func (p Prizes) Normalize() (Prizes, error) {
+	var out Prizes
+	for _, spec := range tierSpecs {
+		nums := p.Tiers()[spec.name]
+		if len(nums) > spec.max {
+			return Prizes{}, fmt.Errorf("%s: at most %d numbers allowed, got %d", spec.name, spec.max, len(nums))
+		}
+		clean := make([]string, 0, len(nums))
+		seen := map[string]bool{}
+		for _, n := range nums {
+			n = strings.TrimSpace(n)
+			if !isDigits(n, spec.digits) {
+				return Prizes{}, fmt.Errorf("%s: %q is not a %d-digit number", spec.name, n, spec.digits)
+			}
+			if seen[n] {
+				return Prizes{}, fmt.Errorf("%s: %s is listed twice", spec.name, n)
+			}
+			seen[n] = true
+			clean = append(clean, n)
+		}
+		out.setTier(spec.name, clean)
+	}
+	if out.First != "" && len(out.Adjacent) == 0 {
+		out.Adjacent = AdjacentNumbers(out.First)
+	}
+	return out, nil
+}
+
+// Match returns the tiers a six-digit ticket wins, in tier order. Front and
+// back three-digit prizes match the ticket's first and last three digits, the
+// two-digit prize its last two digits; every other tier needs the full number.
+func (p Prizes) Match(ticket string) []string {
+	if !isDigits(ticket, 6) {
+		return nil
+	}
+	tiers := p.Tiers()
+	var won []string
+	for _, spec := range tierSpecs {
+		part := ticket
+		switch spec.name {
+		case TierFront3:
+			part = ticket[:3]
+		case TierBack3:
+			part = ticket[3:]
+		case TierBack2:
+			part = ticket[4:]
+		}
+		for _, n := range tiers[spec.name] {
+			if n == part {
+				won = append(won, spec.name)
+				break
+			}
+		}
+	}
+	return won
+}
+
+// AdjacentNumbers returns the numbers one below and one above a six-digit first prize, wrapping at 000000/999999
+func AdjacentNumbers(first string) []string {
+	n, err := strconv.Atoi(first)
+	if err != nil || len(first) != 6 {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("%06d", (n+999999)%1000000),
+		fmt.Sprintf("%06d", (n+1)%1000000),
+	}
+}
+
+// legacyPrizes derives the tiers old clients could express: fnum is the first prize, snum the 2-digit back
+func legacyPrizes(fnum, snum string) Prizes {
+	var p Prizes
+	if fnum = strings.TrimSpace(fnum); isDigits(fnum, 6) {
+		p.First = fnum
+		p.Adjacent = AdjacentNumbers(fnum)
+	}
+	if snum = strings.TrimSpace(snum); isDigits(snum, 2) {
+		p.Back2 = snum
+	}
+	return p
+}
+
+// ValidTicket reports whether s is a six-digit Thai lottery number
+func ValidTicket(s string) bool {
+	return isDigits(s, 6)
+}
+
+func isDigits(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// InitPrizeTable creates the lottosociety_prize table and fills it from the
+// legacy fnum/snum columns of draws that have no structured prizes yet
+func InitPrizeTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS lottosociety_prize (
+        date TEXT NOT NULL,
+        tier TEXT NOT NULL,
+        position INTEGER NOT NULL,
+        number TEXT NOT NULL,
+        PRIMARY KEY (date, tier, position)
+    );`)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(`SELECT date, fnum, snum FROM lottosociety
+        WHERE date IS NOT NULL AND date != '' AND date NOT IN (SELECT DISTINCT date FROM lottosociety_prize)`)
+	if err != nil {
+		return err
+	}
+	legacy := map[string]Prizes{}
+	for rows.Next() {
+		var date string
+		var fnum, snum sql.NullString
+		if err := rows.Scan(&date, &fnum, &snum); err != nil {
+			rows.Close()
+			return err
+		}
+		if p := legacyPrizes(fnum.String, snum.String); !p.IsEmpty() {
+			legacy[date] = p
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for date, p := range legacy {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := writePrizes(tx, date, p); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePrizes replaces the stored tiers of date with p
+func writePrizes(tx *sql.Tx, date string, p Prizes) error {
+	if _, err := tx.Exec(`DELETE FROM lottosociety_prize WHERE date=?`, date); err != nil {
+		return err
+	}
+	for tier, nums := range p.Tiers() {
+		for i, n := range nums {
+			if _, err := tx.Exec(`INSERT INTO lottosociety_prize (date, tier, position, number) VALUES (?, ?, ?, ?)`, date, tier, i, n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readPrizes loads the stored tiers of every date in the inclusive range
+// from..to in one query; dates without tiers are missing from the map
+func readPrizes(db *sql.DB, from, to string) (map[string]Prizes, error) {
+	rows, err := db.Query(`SELECT date, tier, number FROM lottosociety_prize WHERE date BETWEEN ? AND ? ORDER BY date, tier, position`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byDate := map[string]map[string][]string{}
+	for rows.Next() {
+		var date, tier, n string
+		if err := rows.Scan(&date, &tier, &n); err != nil {
+			return nil, err
+		}
+		if byDate[date] == nil {
+			byDate[date] = map[string][]string{}
+		}
+		byDate[date][tier] = append(byDate[date][tier], n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	all := make(map[string]Prizes, len(byDate))
+	for date, byTier := range byDate {
+		var p Prizes
+		for _, spec := range tierSpecs {
+			p.setTier(spec.name, append([]string{}, byTier[spec.name]...))
+		}
+		all[date] = p
+	}
+	return all, nil
+}
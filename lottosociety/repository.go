@@ -0,0 +1,150 @@
+package lottosociety
+
+import (
+	"database/sql"
+
+	"gosse/dates"
+)
+
+// Repository is the storage contract for lotto society draws
+type Repository interface {
+	// List returns every draw, newest date first
+	List() ([]LottoSociety, error)
+	// Between returns the draws dated within the inclusive ISO range, newest first
+	Between(from, to string) ([]LottoSociety, error)
+	// Latest returns the newest draw; ok is false when the table is empty
+	Latest() (l LottoSociety, ok bool, err error)
+	// ByDate returns the draws stored for date
+	ByDate(date string) ([]LottoSociety, error)
+	// ExistsByDate reports whether a draw is stored for date
+	ExistsByDate(date string) (bool, error)
+	// Insert stores a new draw
+	Insert(l LottoSociety) error
+	// UpdateByDate overwrites the draw stored for l.Date
+	UpdateByDate(l LottoSociety) error
+}
+
+// SQLiteRepository implements Repository on top of the lottosociety table
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository wraps an open database handle
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{db: db}
+}
+
+const selectLotto = "SELECT date, thaidate, fnum, snum, id, text FROM lottosociety"
+
+func (s *SQLiteRepository) query(query string, args ...any) ([]LottoSociety, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []LottoSociety
+	for rows.Next() {
+		var l LottoSociety
+		if err := rows.Scan(&l.Date, &l.ThaiDate, &l.FNum, &l.SNum, &l.ID, &l.Text); err != nil {
+			return nil, err
+		}
+		all = append(all, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	// Load the tiers of the whole date span at once and attach them by date
+	from, to := "", ""
+	for _, l := range all {
+		if l.Date == "" {
+			continue
+		}
+		if from == "" || l.Date < from {
+			from = l.Date
+		}
+		if l.Date > to {
+			to = l.Date
+		}
+	}
+	if from == "" {
+		return all, nil
+	}
+	prizes, err := readPrizes(s.db, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for i := range all {
+		if p, ok := prizes[all[i].Date]; ok {
+			all[i].Prizes = &p
+		}
+	}
+	return all, nil
+}
+
+// List returns all rows ordered by date descending
+func (s *SQLiteRepository) List() ([]LottoSociety, error) {
+	return s.Between("", "")
+}
+
+// Between returns the rows whose date lies in the inclusive range
+func (s *SQLiteRepository) Between(from, to string) ([]LottoSociety, error) {
+	query := selectLotto
+	cond, args := dates.RangeClause("date", from, to)
+	if cond != "" {
+		query += " WHERE " + cond
+	}
+	return s.query(query+" ORDER BY date DESC", args...)
+}
+
+// Latest returns the row with the greatest date
+func (s *SQLiteRepository) Latest() (LottoSociety, bool, error) {
+	all, err := s.query(selectLotto + " ORDER BY date DESC LIMIT 1")
+	if err != nil || len(all) == 0 {
+		return LottoSociety{}, false, err
+	}
+	return all[0], true, nil
+}
+
+// ByDate returns the rows whose date equals date
+func (s *SQLiteRepository) ByDate(date string) ([]LottoSociety, error) {
+	return s.query(selectLotto+" WHERE date=?", date)
+}
+
+// ExistsByDate reports whether a row with the given date exists
+func (s *SQLiteRepository) ExistsByDate(date string) (bool, error) {
+	var exists string
+	err := s.db.QueryRow("SELECT date FROM lottosociety WHERE date=?", date).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Insert adds a new row together with its prize tiers
+func (s *SQLiteRepository) Insert(l LottoSociety) error {
+	return s.write(l, "INSERT INTO lottosociety (date, thaidate, fnum, snum, id, text) VALUES (?, ?, ?, ?, ?, ?)", l.Date, l.ThaiDate, l.FNum, l.SNum, l.ID, l.Text)
+}
+
+// UpdateByDate replaces the row matching l.Date and its prize tiers
+func (s *SQLiteRepository) UpdateByDate(l LottoSociety) error {
+	return s.write(l, "UPDATE lottosociety SET thaidate=?, fnum=?, snum=?, id=?, text=? WHERE date=?", l.ThaiDate, l.FNum, l.SNum, l.ID, l.Text, l.Date)
+}
+
+// write runs the row statement and replaces the prize tiers in one transaction
+func (s *SQLiteRepository) write(l LottoSociety, query string, args ...any) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+	if l.Prizes != nil && l.Date != "" {
+		if err := writePrizes(tx, l.Date, *l.Prizes); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -12,6 +12,8 @@ type LottoSociety struct {
 	SNum     string `json:"snum"`
 	ID       string `json:"id"`
 	Text     string `json:"text"`
+	// Prizes is the structured prize table; fnum and snum are kept for older app versions
+	Prizes *Prizes `json:"prizes,omitempty"`
 }
 
 // InitLottoSocietyTable creates the lottosociety table if it does not exist
@@ -9,16 +9,27 @@ package main
 import (
 	"fmt"
 	"gosse/Live"
+	"gosse/admin"
+	"gosse/audit"
+	"gosse/blob"
 	"gosse/chat"
+	"gosse/check"
 	"gosse/futurepaper"
 	"gosse/gift"
+	"gosse/imaging"
 	"gosse/lottosociety"
+	"gosse/media"
+	"gosse/storage"
 	"gosse/threedata"
 	"gosse/twoddata"
+	"gosse/upload"
 	"gosse/user"
+	"gosse/wallet"
+	"gosse/webhook"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/debug"
 	"time"
@@ -59,19 +70,57 @@ func main() {
 	// Initialize twoddata database
 
 	db := twoddata.InitDB("twoddata.db")
-	chat.InitBanTable(db) // Initialize the ban table in the database
-	chat.InitReportTable(db)
 	// Initialize other databases
 	giftDB := gift.InitGiftDB("twoddata.db")
 	threedDB := threedata.InitThreedDB("twoddata.db")
 	defer db.Close()
 	defer giftDB.Close()
 
-	lottosociety.InitLottoSocietyTable(db)
+	// Create the remaining tables (ban, report, lottosociety, useraccount, ...)
+	if err := storage.InitTables(db); err != nil {
+		log.Fatal("Failed to create tables:", err)
+	}
+
+	// Repositories used by the handlers
+	twodRepo := twoddata.NewSQLiteRepository(db)
+	threedRepo := threedata.NewSQLiteRepository(threedDB)
+	giftRepo := gift.NewSQLiteRepository(giftDB)
+	giftTxRepo := gift.NewSQLiteTransactionRepository(giftDB)
+	lottoRepo := lottosociety.NewSQLiteRepository(db)
+	userRepo := user.NewSQLiteRepository(db)
+	banRepo := chat.NewSQLiteBanRepository(db)
+	reportRepo := chat.NewSQLiteReportRepository(db)
+	messageRepo := chat.NewSQLiteMessageRepository(db)
+	roomRepo := chat.NewSQLiteRoomRepository(db)
+	paperRepo := futurepaper.NewSQLiteRepository(db)
+	walletStore := wallet.NewSQLiteStore(db)
+
+	// Resized upload variants can be configured as name:width:quality lists
+	for env, specs := range map[string]*[]imaging.Spec{"GOSSE_PAPER_VARIANTS": &futurepaper.VariantSpecs, "GOSSE_GIFT_VARIANTS": &gift.VariantSpecs} {
+		if v := os.Getenv(env); v != "" {
+			parsed, err := imaging.ParseSpecs(v)
+			if err != nil {
+				log.Fatalf("Invalid %s: %v", env, err)
+			}
+			*specs = parsed
+		}
+	}
 
-	es := user.CreateUserAccountTable(db)
-	if es != nil {
-		log.Fatal("Failed to create useraccount table:", es)
+	// Register paper images that predate the catalog
+	if n, err := futurepaper.SeedFromDisk(paperRepo, futurepaper.ImageDir); err != nil {
+		log.Printf("Failed to seed paper catalog: %v", err)
+	} else if n > 0 {
+		log.Printf("Registered %d papers from disk", n)
+	}
+	if n, err := futurepaper.BackfillHashes(paperRepo); err != nil {
+		log.Printf("Failed to hash papers: %v", err)
+	} else if n > 0 {
+		log.Printf("Hashed %d papers for duplicate detection", n)
+	}
+	if report, err := walletStore.Check(); err != nil {
+		log.Printf("Failed to check the wallet ledger: %v", err)
+	} else if !report.Consistent {
+		log.Printf("Wallet ledger is inconsistent: sum %d, unbalanced %v, overdrawn %v", report.Sum, report.Unbalanced, report.Overdrawn)
 	}
 	/// check go routine count
 	go func() {
@@ -87,41 +136,147 @@ func main() {
 	brokerr.Start()
 	go brokerr.StartBroadcastingTime()
 
+	// --- WebSocket Broker Setup (NEW) ---
+	wsBroker := Live.NewWebSocketBroker()         // Initialize the new WebSocket broker
+	wsBroker.Start()                              // Start the WebSocket broker's main loop
+	go wsBroker.StartBroadcastingTimeAndClients() // Start broadcasting WS data
+
+	// Admin endpoints are authorized by the tokens in GOSSE_ADMIN_TOKENS
+	auth := admin.FromEnv()
+	notifyCorrection := Live.BroadcastCorrection(brokerr, wsBroker)
+
+	// Client addresses come from X-Forwarded-For only behind the proxies in GOSSE_TRUSTED_PROXIES
+	proxies, err := audit.ParseTrustedProxies(os.Getenv(audit.EnvTrustedProxies))
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", audit.EnvTrustedProxies, err)
+	}
+	audit.TrustedProxies = proxies
+
+	// Every administrative mutation is recorded in the append-only audit log
+	auditLog := audit.NewSQLiteLogger(db)
+	audited := audit.NewRecorder(auditLog, auth)
+
+	// Uploads are size checked, fully decoded and charged to a shared daily quota
+	uploadQuota := upload.NewQuota(200, 500<<20, 24*time.Hour)
+	paperUploads := upload.New(upload.Limits{MaxBytes: 15 << 20, MaxWidth: 10000, MaxHeight: 10000, MaxPixels: 50_000_000}, uploadQuota)
+	giftUploads := upload.New(upload.Limits{MaxBytes: 2 << 20, MaxWidth: 2048, MaxHeight: 2048, MaxPixels: 4_194_304}, uploadQuota)
+	paperUploads.Auth, giftUploads.Auth = auth, auth
+
+	// Uploaded files are stored by content hash; unreferenced ones are collected after a day
+	giftBlobs := blob.NewStore(gift.ImageDir, gift.BlobRefs(giftRepo))
+	paperBlobs := blob.NewStore(filepath.Join(futurepaper.ImageDir, futurepaper.BlobDir), futurepaper.BlobRefs(paperRepo))
+	go blob.StartGC([]*blob.Store{giftBlobs, paperBlobs}, 24*time.Hour, time.Hour, nil)
+
+	// Scheduled papers are announced to live clients when their publish time comes
+	paperPublisher := futurepaper.NewPublisher(paperRepo, func(p futurepaper.Paper) {
+		p = p.WithURLs(futurepaper.ImagePath)
+		if err := brokerr.PublishEvent("paper", p); err != nil {
+			log.Printf("failed to publish paper %d: %v", p.ID, err)
+		}
+		if err := wsBroker.PublishEvent("paper", p); err != nil {
+			log.Printf("failed to publish paper %d: %v", p.ID, err)
+		}
+	})
+	go paperPublisher.Start(nil)
+
+	// Outbound webhooks for results and moderation events
+	hooks := webhook.NewDispatcher(webhook.NewSQLiteStore(db))
+	go hooks.Start(nil)
+	notifyBan := func(b chat.Ban) { hooks.Emit(webhook.EventChatBan, b) }
+
 	http.HandleFunc("/live", brokerr.SSEHandler)
-	http.HandleFunc("/history", Live.TwoddataHandler(db))
-	http.HandleFunc("/addlive", Live.AddLiveDataHandler)
+	http.HandleFunc("/history", Live.TwoddataHandler(twodRepo))
+	http.HandleFunc("/addlive", audited.Wrap("live.ingest", Live.AddLiveDataHandler(twodRepo, func(d twoddata.TwodData) { hooks.Emit(webhook.EventTwodFinal, d) })))
 	http.HandleFunc("/livess", Live.LiveDataPageHandler)
 	http.HandleFunc("/livedata/sse", Live.LiveDataSSEHandler)
-	http.HandleFunc("/threed", threedata.ThreedDataHandler(threedDB))
-	http.HandleFunc("/gift", gift.GiftDataHandler(giftDB))
-	http.HandleFunc("/addgift/", gift.AddGiftHandler(giftDB))
-	http.HandleFunc("/futurepaper/getallpaper/", futurepaper.GetLowPaperHandler)
-	http.HandleFunc("/futurepaper/getallpaper/low", futurepaper.GetLowPaperHandler)
-	http.HandleFunc("/futurepaper/getallpaper/high", futurepaper.GetHighPaperHandler)
-
-	http.HandleFunc("/chat/sendmessage", chat.SendMessageHandler(db))
-	http.HandleFunc("/chat/sse", chat.ChatSSEHandler)
-	http.HandleFunc("/register", user.RegisterUserHandler(db))
-	http.HandleFunc("/chat/ban", chat.BanHandler(db)) // Alias for ban handler
-	http.HandleFunc("/chat/report", chat.ReportHandler(db))
-	http.HandleFunc("/futurepaper/addpaper", futurepaper.UploadPaperImageHandler)       // Alias for add paper handler
-	http.HandleFunc("/lottosociety/addlotto", lottosociety.AddOrUpdateLottoHandler(db)) // Alias for add lotto handler
-	http.HandleFunc("/lottosociety/getlotto", lottosociety.GetLottoHandler(db))         // Alias for get lotto handler
+	http.HandleFunc("/threed", threedata.ThreedDataHandler(threedRepo))
+	http.HandleFunc("/gift", gift.GiftDataHandler(giftRepo))
+	http.HandleFunc("/gift/categories", gift.CategoriesHandler(giftRepo))
+	http.HandleFunc("/addgift/", audited.Wrap("gift.upload", gift.AddGiftHandler(giftRepo, giftUploads, giftBlobs)))
+	http.HandleFunc("/gift/send", gift.SendGiftHandler(giftRepo, giftTxRepo, userRepo, roomRepo, func(s gift.Sent) { chat.PublishRoomEvent(s.Room, "gift", s) }))
+	http.HandleFunc("/gift/leaderboard", gift.LeaderboardHandler(giftTxRepo))
+	http.HandleFunc("/gift/update", auth.Require(audited.Wrap("gift.update", gift.UpdateGiftHandler(giftRepo))))
+	http.HandleFunc("/futurepaper/getallpaper/", futurepaper.GetLowPaperHandler(paperRepo))
+	http.HandleFunc("/futurepaper/getallpaper/low", futurepaper.GetLowPaperHandler(paperRepo))
+	http.HandleFunc("/futurepaper/getallpaper/high", futurepaper.GetHighPaperHandler(paperRepo))
+	http.HandleFunc("/futurepaper/papers", futurepaper.PapersHandler(paperRepo))
+	http.HandleFunc("/futurepaper/archive", futurepaper.ArchiveHandler(paperRepo))
+	http.HandleFunc("/futurepaper/bundle", futurepaper.BundleHandler(paperRepo))
+	http.HandleFunc("/futurepaper/duplicates", auth.Require(futurepaper.DuplicatesHandler(paperRepo)))
+	http.HandleFunc("/futurepaper/update", auth.Require(audited.Wrap("paper.update", futurepaper.UpdatePaperHandler(paperRepo, paperPublisher))))
+
+	http.HandleFunc("/chat/sendmessage", chat.SendMessageHandler(banRepo, messageRepo, userRepo, roomRepo, chat.NewThrottle()))
+	http.HandleFunc("/chat/sse", chat.ChatSSEHandler(messageRepo, roomRepo))
+	http.HandleFunc("/chat/history", chat.HistoryHandler(messageRepo, roomRepo))
+	http.HandleFunc("/chat/rooms", chat.RoomsHandler(roomRepo))
+	http.HandleFunc("/chat/delete", auth.Require(audited.Wrap("chat.delete", chat.DeleteMessageHandler(messageRepo, roomRepo))))
+	http.HandleFunc("/admin/chat/rooms", auth.Require(chat.AdminRoomsHandler(roomRepo)))
+	http.HandleFunc("/admin/chat/rooms/save", auth.Require(audited.Wrap("chat.room", chat.SaveRoomHandler(roomRepo))))
+	http.HandleFunc("/admin/chat/rooms/slowmode", auth.Require(audited.Wrap("chat.slowmode", chat.SlowModeHandler(roomRepo))))
+	http.HandleFunc("/admin/chat/rooms/moderator", auth.Require(audited.Wrap("chat.moderator", chat.ModeratorHandler(roomRepo))))
+	http.HandleFunc("/register", user.RegisterUserHandler(userRepo))
+	http.HandleFunc("/chat/ban", audited.Wrap("chat.ban", chat.BanHandler(banRepo, messageRepo, notifyBan))) // Alias for ban handler
+	http.HandleFunc("/chat/report", chat.ReportHandler(reportRepo, func(r chat.Report) { hooks.Emit(webhook.EventChatReport, r) }))
+	http.HandleFunc("/futurepaper/addpaper", audited.Wrap("paper.upload", futurepaper.UploadPaperImageHandler(paperRepo, paperUploads, paperBlobs, paperPublisher)))                                        // Alias for add paper handler
+	http.HandleFunc("/lottosociety/addlotto", audited.Wrap("lotto.upsert", lottosociety.AddOrUpdateLottoHandler(lottoRepo, func(l lottosociety.LottoSociety) { hooks.Emit(webhook.EventLottoResult, l) }))) // Alias for add lotto handler
+	http.HandleFunc("/lottosociety/getlotto", lottosociety.GetLottoHandler(lottoRepo))                                                                                                                      // Alias for get lotto handler
 	// Alias for delete all lotto handler
 	// Alias for login handler
 	// Alias for report handler
-	// --- WebSocket Broker Setup (NEW) ---
-	wsBroker := Live.NewWebSocketBroker()             // Initialize the new WebSocket broker
-	wsBroker.Start()                                  // Start the WebSocket broker's main loop
-	go wsBroker.StartBroadcastingTimeAndClients()     // Start broadcasting WS data
 	http.HandleFunc("/ws", wsBroker.WebSocketHandler) // Handle WebSocket connections
 
-	// Serve static images from /images/
-	http.Handle("/images/", http.StripPrefix("/images/", http.FileServer(http.Dir("images"))))
-	http.Handle("/gift/images/", http.StripPrefix("/gift/images/", http.FileServer(http.Dir("gift/images"))))
-	http.Handle("/futurepaper/images/daily/", http.StripPrefix("/futurepaper/images/daily/", http.FileServer(http.Dir("futurepaper/images/daily"))))
-	http.Handle("/futurepaper/images/weekly/", http.StripPrefix("/futurepaper/images/weekly/", http.FileServer(http.Dir("futurepaper/images/weekly"))))
-	http.Handle("/futurepaper/images/calendar/", http.StripPrefix("/futurepaper/images/calendar/", http.FileServer(http.Dir("futurepaper/images/calendar"))))
+	// Admin corrections for archived 2D results
+	http.HandleFunc("/admin/twod/edit", auth.Require(audited.Wrap("twod.edit", Live.TwodEditHandler(twodRepo, notifyCorrection))))
+	http.HandleFunc("/admin/twod/void", auth.Require(audited.Wrap("twod.void", Live.TwodVoidHandler(twodRepo, notifyCorrection))))
+	http.HandleFunc("/admin/twod/reinsert", auth.Require(audited.Wrap("twod.reinsert", Live.TwodReinsertHandler(twodRepo, notifyCorrection))))
+	http.HandleFunc("/admin/twod/corrections", auth.Require(Live.TwodCorrectionsHandler(twodRepo)))
+	http.HandleFunc("/admin/audit", auth.Require(audit.QueryHandler(auditLog)))
+
+	// Live 3D draw-day channel; results entered below are pushed immediately
+	threedLive := Live.NewThreedLive(threedRepo, wsBroker)
+	threedLive.Broker().Start()
+	go threedLive.StartBroadcasting()
+	publishThreed := func(d threedata.ThreedData) {
+		threedLive.PublishResult(d)
+		hooks.Emit(webhook.EventThreedResult, d)
+	}
+	http.HandleFunc("/threed/live", threedLive.Broker().SSEHandler)
+	http.HandleFunc("/threed/stats", threedata.ThreedStatsHandler(threedRepo))
+	http.HandleFunc("/threed/lookup", threedata.ThreedLookupHandler(threedRepo))
+
+	// Admin write API for 3D results
+	http.HandleFunc("/threed/add", auth.Require(audited.Wrap("threed.create", threedata.ThreedCreateHandler(threedRepo, publishThreed))))
+	http.HandleFunc("/threed/update", auth.Require(audited.Wrap("threed.update", threedata.ThreedUpdateHandler(threedRepo, publishThreed))))
+	http.HandleFunc("/threed/delete", auth.Require(audited.Wrap("threed.delete", threedata.ThreedDeleteHandler(threedRepo))))
+
+	// Ticket checker across 2D, 3D and Thai lottery results
+	http.HandleFunc("/check", check.CheckHandler(check.NewChecker(twodRepo, threedRepo, lottoRepo)))
+
+	// Webhook endpoints, delivery log and dead-letter requeue
+	http.HandleFunc("/admin/webhooks", auth.Require(webhook.EndpointsHandler(hooks.Store())))
+	http.HandleFunc("/admin/webhooks/create", auth.Require(audited.Wrap("webhook.create", webhook.CreateEndpointHandler(hooks.Store()))))
+	http.HandleFunc("/admin/webhooks/delete", auth.Require(audited.Wrap("webhook.delete", webhook.DeleteEndpointHandler(hooks.Store()))))
+	http.HandleFunc("/admin/webhooks/deliveries", auth.Require(webhook.DeliveriesHandler(hooks.Store())))
+	http.HandleFunc("/admin/webhooks/delivery", auth.Require(webhook.DeliveryHandler(hooks.Store())))
+	http.HandleFunc("/admin/webhooks/redeliver", auth.Require(audited.Wrap("webhook.redeliver", webhook.RedeliverHandler(hooks))))
+
+	// Coin wallets; every change goes through the ledger. Users are not
+	// authenticated, so balances and statements are for admins only.
+	http.HandleFunc("/wallet/balance", auth.Require(wallet.BalanceHandler(walletStore)))
+	http.HandleFunc("/wallet/statement", auth.Require(wallet.StatementHandler(walletStore)))
+	http.HandleFunc("/admin/wallet/topup", auth.Require(audited.Wrap("wallet.topup", wallet.TopUpHandler(walletStore, userRepo))))
+	http.HandleFunc("/admin/wallet/spend", auth.Require(audited.Wrap("wallet.spend", wallet.SpendHandler(walletStore))))
+	http.HandleFunc("/admin/wallet/refund", auth.Require(audited.Wrap("wallet.refund", wallet.RefundHandler(walletStore))))
+	http.HandleFunc("/admin/wallet/check", auth.Require(wallet.CheckHandler(walletStore)))
+
+	// Uploaded images are served with long-lived cache headers and Range support
+	mediaServer := media.NewServer()
+	mediaServer.Mount("/images/", "images", media.DefaultMaxAge)
+	mediaServer.Mount("/gift/images/", gift.ImageDir, media.DefaultMaxAge)
+	mediaServer.Mount(futurepaper.ImagePath, futurepaper.ImageDir, media.DefaultMaxAge)
+	for _, prefix := range mediaServer.Prefixes() {
+		http.Handle(prefix, mediaServer)
+	}
 
 	log.Println("SSE server started on :1411")
 	fmt.Println(http.ListenAndServe(":1411", nil))
@@ -0,0 +1,165 @@
+// Package media serves uploaded images. Each logical URL prefix is mounted on
+// a storage directory; files are served with cache headers, a content ETag
+// and Range support, and directories are never listed. Only content-addressed
+// blobs are cached for long; other files can be replaced under the same name.
+package media
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gosse/blob"
+)
+
+const (
+	// DefaultMaxAge is how long clients may cache a blob; it is named by its
+	// content hash, so the file behind its URL never changes
+	DefaultMaxAge = 365 * 24 * time.Hour
+	// MutableMaxAge is the longest clients may cache any other file before
+	// revalidating it with its ETag
+	MutableMaxAge = 5 * time.Minute
+)
+
+type mount struct {
+	prefix string
+	dir    string
+	maxAge time.Duration
+}
+
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// Server maps URL prefixes to storage directories
+type Server struct {
+	mounts []mount
+
+	mu    sync.Mutex
+	etags map[string]etagEntry
+}
+
+// NewServer returns a Server without mounts
+func NewServer() *Server {
+	return &Server{etags: map[string]etagEntry{}}
+}
+
+// Mount serves the files below dir under prefix, which must end in a slash.
+// Blobs are cacheable for maxAge and marked immutable; other files for at
+// most MutableMaxAge.
+func (s *Server) Mount(prefix, dir string, maxAge time.Duration) {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	s.mounts = append(s.mounts, mount{prefix: prefix, dir: dir, maxAge: maxAge})
+	// longest prefix first, so nested mounts win
+	sort.SliceStable(s.mounts, func(i, j int) bool { return len(s.mounts[i].prefix) > len(s.mounts[j].prefix) })
+}
+
+// Prefixes returns the mounted URL prefixes for registering the server on a mux
+func (s *Server) Prefixes() []string {
+	out := make([]string, len(s.mounts))
+	for i, m := range s.mounts {
+		out[i] = m.prefix
+	}
+	return out
+}
+
+// Resolve returns the mount and slash-separated file name a URL path refers
+// to. Directories, hidden files and paths leaving the mount do not resolve.
+func (s *Server) Resolve(urlPath string) (dir, name string, maxAge time.Duration, ok bool) {
+	for _, m := range s.mounts {
+		if !strings.HasPrefix(urlPath, m.prefix) {
+			continue
+		}
+		name = strings.TrimPrefix(urlPath, m.prefix)
+		if name == "" || strings.HasSuffix(name, "/") || path.Clean("/"+name) != "/"+name {
+			return "", "", 0, false
+		}
+		for _, part := range strings.Split(name, "/") {
+			// temporary upload files and dotfiles are never public
+			if strings.HasPrefix(part, ".") {
+				return "", "", 0, false
+			}
+		}
+		return m.dir, name, m.maxAge, true
+	}
+	return "", "", 0, false
+}
+
+// ServeHTTP serves GET and HEAD requests for mounted files
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	dir, name, maxAge, ok := s.Resolve(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// OpenInRoot also refuses symlinks that point outside the mount
+	f, err := os.OpenInRoot(dir, filepath.FromSlash(name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	etag, err := s.etag(filepath.Join(dir, name), info, f)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	if blob.IsBlob(path.Base(name)) {
+		h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(maxAge/time.Second)))
+	} else {
+		h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(min(maxAge, MutableMaxAge)/time.Second)))
+	}
+	h.Set("X-Content-Type-Options", "nosniff")
+	// ServeContent answers conditional and Range requests and sets Last-Modified
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// etag returns the quoted content hash of f, cached until its size or
+// modification time changes, and rewinds f
+func (s *Server) etag(key string, info fs.FileInfo, f *os.File) (string, error) {
+	s.mu.Lock()
+	e, ok := s.etags[key]
+	s.mu.Unlock()
+	if ok && e.size == info.Size() && e.modTime.Equal(info.ModTime()) {
+		return e.etag, nil
+	}
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(sum.Sum(nil))[:32] + `"`
+	s.mu.Lock()
+	s.etags[key] = etagEntry{size: info.Size(), modTime: info.ModTime(), etag: etag}
+	s.mu.Unlock()
+	return etag, nil
+}
@@ -0,0 +1,100 @@
+package media_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gosse/media"
+)
+
+func setup(t *testing.T) *media.Server {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"high/daily/a.jpg": "0123456789",
+		"low/daily/a.jpg":  "low",
+		"blobs/" + strings.Repeat("ab", 32) + ".jpg": "blob",
+		".upload-123":   "partial",
+		"../secret.txt": "outside",
+	} {
+		p := filepath.Join(dir, "papers", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := media.NewServer()
+	s.Mount("/futurepaper/images/", filepath.Join(dir, "papers"), time.Hour)
+	return s
+}
+
+func get(s *media.Server, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.URL.Path = path
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServe(t *testing.T) {
+	s := setup(t)
+	rec := get(s, "/futurepaper/images/high/daily/a.jpg", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Fatalf("status %d, body %q", rec.Code, rec.Body)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" || rec.Header().Get("Last-Modified") == "" || rec.Header().Get("Cache-Control") != "public, max-age=300" {
+		t.Errorf("headers = %v", rec.Header())
+	}
+	if rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("content type = %q", rec.Header().Get("Content-Type"))
+	}
+	// only content-addressed files are immutable
+	if rec := get(s, "/futurepaper/images/blobs/"+strings.Repeat("ab", 32)+".jpg", nil); rec.Header().Get("Cache-Control") != "public, max-age=3600, immutable" {
+		t.Errorf("blob cache control = %q", rec.Header().Get("Cache-Control"))
+	}
+	if rec := get(s, "/futurepaper/images/low/daily/a.jpg", nil); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("low variant: %d, etag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	if rec := get(s, "/futurepaper/images/high/daily/a.jpg", map[string]string{"If-None-Match": etag}); rec.Code != http.StatusNotModified {
+		t.Errorf("conditional request = %d, want 304", rec.Code)
+	}
+	rec = get(s, "/futurepaper/images/high/daily/a.jpg", map[string]string{"Range": "bytes=2-4"})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "234" || !strings.HasPrefix(rec.Header().Get("Content-Range"), "bytes 2-4/10") {
+		t.Errorf("range request = %d %q %q", rec.Code, rec.Body, rec.Header().Get("Content-Range"))
+	}
+}
+
+func TestServeRejects(t *testing.T) {
+	s := setup(t)
+	for _, path := range []string{
+		"/futurepaper/images/",
+		"/futurepaper/images/high/",
+		"/futurepaper/images/high/daily",
+		"/futurepaper/images/.upload-123",
+		"/futurepaper/images/../secret.txt",
+		"/futurepaper/images/high/../../secret.txt",
+		"/futurepaper/images/missing.jpg",
+		"/other/a.jpg",
+	} {
+		if rec := get(s, path, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/futurepaper/images/high/daily/a.jpg", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d, want 405", rec.Code)
+	}
+}
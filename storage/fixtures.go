@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Fixtures maps a table name to the rows that should be inserted into it.
+// Each row maps a column name to its value.
+type Fixtures map[string][]map[string]any
+
+// LoadFixtures inserts every row of f into db
+func LoadFixtures(db *sql.DB, f Fixtures) error {
+	tables := make([]string, 0, len(f))
+	for t := range f {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		for i, row := range f[table] {
+			cols := make([]string, 0, len(row))
+			for c := range row {
+				cols = append(cols, c)
+			}
+			sort.Strings(cols)
+			args := make([]any, len(cols))
+			for j, c := range cols {
+				args[j] = row[c]
+			}
+			query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(cols, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "))
+			if _, err := db.Exec(query, args...); err != nil {
+				return fmt.Errorf("fixture %s[%d]: %w", table, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadFixtureFile reads a JSON fixture file and inserts its rows into db
+func LoadFixtureFile(db *sql.DB, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var f Fixtures
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return LoadFixtures(db, f)
+}
+
+// OpenMemoryWithFixtures opens an in-memory database and loads the given fixture files into it
+func OpenMemoryWithFixtures(paths ...string) (*sql.DB, error) {
+	db, err := OpenMemory()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		if err := LoadFixtureFile(db, p); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
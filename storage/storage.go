@@ -0,0 +1,103 @@
+// Package storage wires the per-package tables together so the whole schema
+// can be created on one database, either the on-disk file or an in-memory copy.
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"gosse/audit"
+	"gosse/chat"
+	"gosse/dates"
+	"gosse/futurepaper"
+	"gosse/gift"
+	"gosse/lottosociety"
+	"gosse/threedata"
+	"gosse/twoddata"
+	"gosse/user"
+	"gosse/wallet"
+	"gosse/webhook"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// InitTables creates every table used by the server if it does not exist
+func InitTables(db *sql.DB) error {
+	inits := []struct {
+		name string
+		fn   func(*sql.DB) error
+	}{
+		{"twoddata", twoddata.InitTwodDataTable},
+		{"twoddata_correction", twoddata.InitCorrectionTable},
+		{"threeddata", threedata.InitThreedTable},
+		{"gift", gift.InitGiftTable},
+		{"gift_transaction", gift.InitTransactionTable},
+		{"paper", futurepaper.InitPaperTables},
+		{"ban", chat.InitBanTable},
+		{"report", chat.InitReportTable},
+		{"chat_message", chat.InitMessageTable},
+		{"chat_room", chat.InitRoomTables},
+		{"lottosociety", lottosociety.InitLottoSocietyTable},
+		{"lottosociety_prize", lottosociety.InitPrizeTable},
+		{"useraccount", user.CreateUserAccountTable},
+		{"audit_log", audit.InitAuditTable},
+		{"webhook", webhook.InitWebhookTables},
+		{"wallet", wallet.InitWalletTables},
+	}
+	for _, in := range inits {
+		if err := in.fn(db); err != nil {
+			return fmt.Errorf("init %s table: %w", in.name, err)
+		}
+	}
+	return MigrateDates(db)
+}
+
+// dateColumns lists every column holding a draw date
+var dateColumns = [][2]string{
+	{"twoddata", "date"},
+	{"threeddata", "date"},
+	{"lottosociety", "date"},
+	{"lottosociety_prize", "date"},
+	{"paper", "issue_date"},
+}
+
+// MigrateDates rewrites the draw dates of every result table into ISO form.
+// Rows that are already ISO are left alone, so it is cheap to run on every start.
+// Rows whose date collides with another row are logged and left for an admin.
+func MigrateDates(db *sql.DB) error {
+	for _, tc := range dateColumns {
+		changed, skipped, conflicts, err := dates.MigrateColumn(db, tc[0], tc[1])
+		if err != nil {
+			return err
+		}
+		for _, c := range conflicts {
+			log.Printf("not migrating %s.%s row %d: %q is %s, which another row already holds", tc[0], tc[1], c.RowID, c.Value, c.Date)
+		}
+		if changed > 0 || skipped > 0 || len(conflicts) > 0 {
+			log.Printf("migrated %d %s.%s values to ISO dates, %d left unparsed, %d conflicting", changed, tc[0], tc[1], skipped, len(conflicts))
+		}
+	}
+	return nil
+}
+
+var memSeq atomic.Int64
+
+// OpenMemory opens a private in-memory SQLite database with the full schema.
+// Every call returns an independent database, so tests do not share state.
+func OpenMemory() (*sql.DB, error) {
+	name := fmt.Sprintf("file:gosse_mem_%d?mode=memory&cache=shared", memSeq.Add(1))
+	db, err := sql.Open("sqlite3", name)
+	if err != nil {
+		return nil, err
+	}
+	// The in-memory database lives as long as one connection stays open
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+	if err := InitTables(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
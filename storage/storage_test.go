@@ -0,0 +1,46 @@
+package storage_test
+
+import (
+	"testing"
+
+	"gosse/storage"
+)
+
+func TestOpenMemoryIsIsolated(t *testing.T) {
+	a, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	var n int
+	if err := a.QueryRow("SELECT COUNT(*) FROM gift").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("fixture db has %d gifts, want 1", n)
+	}
+	if err := b.QueryRow("SELECT COUNT(*) FROM gift").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("fresh db has %d gifts, want 0", n)
+	}
+}
+
+func TestLoadFixturesUnknownTable(t *testing.T) {
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = storage.LoadFixtures(db, storage.Fixtures{"nosuchtable": {{"id": "x"}}})
+	if err == nil {
+		t.Fatal("expected error for unknown table")
+	}
+}
@@ -0,0 +1,137 @@
+package threedata
+
+import (
+	"database/sql"
+	"errors"
+
+	"gosse/dates"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrNotFound is returned when no draw is stored for a date
+	ErrNotFound = errors.New("no 3D result for this date")
+	// ErrDuplicate is returned when a draw already exists for a date
+	ErrDuplicate = errors.New("a 3D result for this date already exists")
+)
+
+// Repository is the storage contract for 3D draw results
+type Repository interface {
+	// All returns every stored draw, newest first
+	All() ([]ThreedData, error)
+	// Get returns the draw stored for date, or ErrNotFound
+	Get(date string) (ThreedData, error)
+	// Page returns draws dated within the inclusive ISO range, newest first, skipping
+	// offset and returning at most limit (0 means no limit), with the total in range
+	Page(from, to string, limit, offset int) (page []ThreedData, total int, err error)
+	// Create stores a new draw; it fails with ErrDuplicate if the date is taken
+	Create(d ThreedData) error
+	// Update replaces the draw stored for date with d
+	Update(date string, d ThreedData) error
+	// Delete removes the draw stored for date
+	Delete(date string) error
+}
+
+// SQLiteRepository implements Repository on top of the threeddata table
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository wraps an open database handle
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{db: db}
+}
+
+// All returns every row of the threeddata table
+func (s *SQLiteRepository) All() ([]ThreedData, error) {
+	return s.query(`SELECT date, result FROM threeddata ORDER BY date DESC`)
+}
+
+// Get returns the row for date
+func (s *SQLiteRepository) Get(date string) (ThreedData, error) {
+	var d ThreedData
+	err := s.db.QueryRow(`SELECT date, result FROM threeddata WHERE date=?`, date).Scan(&d.Date, &d.Result)
+	if err == sql.ErrNoRows {
+		return ThreedData{}, ErrNotFound
+	}
+	return d, err
+}
+
+// Page returns one page of rows ordered by date descending
+func (s *SQLiteRepository) Page(from, to string, limit, offset int) ([]ThreedData, int, error) {
+	where := ""
+	cond, args := dates.RangeClause("date", from, to)
+	if cond != "" {
+		where = " WHERE " + cond
+	}
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM threeddata`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	page, err := s.query(`SELECT date, result FROM threeddata`+where+` ORDER BY date DESC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	return page, total, err
+}
+
+func (s *SQLiteRepository) query(query string, args ...any) ([]ThreedData, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []ThreedData
+	for rows.Next() {
+		var d ThreedData
+		if err := rows.Scan(&d.Date, &d.Result); err != nil {
+			return nil, err
+		}
+		all = append(all, d)
+	}
+	return all, rows.Err()
+}
+
+// Create inserts a new row
+func (s *SQLiteRepository) Create(d ThreedData) error {
+	_, err := s.db.Exec(`INSERT INTO threeddata (date, result) VALUES (?, ?)`, d.Date, d.Result)
+	return mapConstraint(err)
+}
+
+// Update rewrites the row for date
+func (s *SQLiteRepository) Update(date string, d ThreedData) error {
+	res, err := s.db.Exec(`UPDATE threeddata SET date=?, result=? WHERE date=?`, d.Date, d.Result, date)
+	if err != nil {
+		return mapConstraint(err)
+	}
+	return requireRow(res)
+}
+
+// Delete removes the row for date
+func (s *SQLiteRepository) Delete(date string) error {
+	res, err := s.db.Exec(`DELETE FROM threeddata WHERE date=?`, date)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+func requireRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func mapConstraint(err error) error {
+	var se sqlite3.Error
+	if errors.As(err, &se) && se.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrDuplicate
+	}
+	return err
+}
@@ -0,0 +1,238 @@
+package threedata
+
+import (
+	"sort"
+
+	"gosse/dates"
+)
+
+// NumberCount is how often a three-digit number was drawn
+type NumberCount struct {
+	Number   string `json:"number"`
+	Count    int    `json:"count"`
+	LastSeen string `json:"last_seen,omitempty"`
+}
+
+// DigitCount is how often a digit was drawn in one position
+type DigitCount struct {
+	Digit string `json:"digit"`
+	Count int    `json:"count"`
+}
+
+// Stats summarizes a window of draws
+type Stats struct {
+	Window    int             `json:"window"`
+	Draws     int             `json:"draws"`
+	From      string          `json:"from,omitempty"`
+	To        string          `json:"to,omitempty"`
+	Numbers   []NumberCount   `json:"numbers"`
+	Positions [3][]DigitCount `json:"positions"`
+	Hot       []NumberCount   `json:"hot"`
+	Cold      []NumberCount   `json:"cold"`
+	HotDigits [3][]DigitCount `json:"hot_digits"`
+}
+
+// Lookup describes the history of one number
+type Lookup struct {
+	Number       string       `json:"number"`
+	Count        int          `json:"count"`
+	LastSeen     string       `json:"last_seen,omitempty"`
+	DrawsSince   int          `json:"draws_since"`
+	Dates        []string     `json:"dates"`
+	Box          []ThreedData `json:"box"`
+	BoxCount     int          `json:"box_count"`
+	BoxLastSeen  string       `json:"box_last_seen,omitempty"`
+	Permutations []string     `json:"permutations"`
+}
+
+// IsBoxMatch reports whether a and b hold the same digits in any order, so 123 matches 321
+func IsBoxMatch(a, b string) bool {
+	return len(a) == 3 && len(b) == 3 && boxKey(a) == boxKey(b)
+}
+
+// Match types of a 3D ticket against a result
+const (
+	MatchStraight = "straight"
+	MatchBox      = "box"
+)
+
+// MatchTicket returns MatchStraight when ticket equals result, MatchBox when
+// it is another permutation of result, and "" otherwise
+func MatchTicket(result, ticket string) string {
+	switch {
+	case !ValidResult(result) || !ValidResult(ticket):
+		return ""
+	case ticket == result:
+		return MatchStraight
+	case IsBoxMatch(result, ticket):
+		return MatchBox
+	}
+	return ""
+}
+
+func boxKey(n string) string {
+	b := []byte(n)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	return string(b)
+}
+
+// Permutations returns the distinct orderings of a three-digit number, ascending
+func Permutations(n string) []string {
+	if len(n) != 3 {
+		return nil
+	}
+	seen := map[string]bool{}
+	idx := [][3]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}}
+	var out []string
+	for _, p := range idx {
+		s := string([]byte{n[p[0]], n[p[1]], n[p[2]]})
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ComputeStats summarizes draws, which must be ordered newest first.
+// window limits the summary to the newest draws; 0 uses all of them.
+// top is the length of the hot and cold lists.
+func ComputeStats(draws []ThreedData, window, top int) Stats {
+	all := draws
+	if window > 0 && window < len(draws) {
+		draws = draws[:window]
+	}
+	st := Stats{Window: window, Draws: len(draws)}
+	if len(draws) > 0 {
+		st.To, st.From = draws[0].Date, draws[len(draws)-1].Date
+	}
+
+	counts := map[string]*NumberCount{}
+	var digits [3][10]int
+	for _, d := range draws {
+		if len(d.Result) != 3 {
+			continue
+		}
+		nc, ok := counts[d.Result]
+		if !ok {
+			nc = &NumberCount{Number: d.Result, LastSeen: d.Date}
+			counts[d.Result] = nc
+		}
+		nc.Count++
+		for i := 0; i < 3; i++ {
+			if c := d.Result[i]; c >= '0' && c <= '9' {
+				digits[i][c-'0']++
+			}
+		}
+	}
+
+	st.Numbers = make([]NumberCount, 0, len(counts))
+	for _, nc := range counts {
+		st.Numbers = append(st.Numbers, *nc)
+	}
+	sort.Slice(st.Numbers, func(i, j int) bool {
+		a, b := st.Numbers[i], st.Numbers[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.LastSeen > b.LastSeen
+	})
+	st.Hot = head(st.Numbers, top)
+
+	// Cold numbers have been drawn before but least often in the window,
+	// the longest-absent first
+	lastSeen := map[string]string{}
+	for _, d := range all {
+		if _, ok := lastSeen[d.Result]; !ok && len(d.Result) == 3 {
+			lastSeen[d.Result] = d.Date
+		}
+	}
+	cold := make([]NumberCount, 0, len(lastSeen))
+	for n, seen := range lastSeen {
+		nc := NumberCount{Number: n, LastSeen: seen}
+		if c, ok := counts[n]; ok {
+			nc.Count = c.Count
+		}
+		cold = append(cold, nc)
+	}
+	sort.Slice(cold, func(i, j int) bool {
+		a, b := cold[i], cold[j]
+		if a.Count != b.Count {
+			return a.Count < b.Count
+		}
+		if a.LastSeen != b.LastSeen {
+			return a.LastSeen < b.LastSeen
+		}
+		return a.Number < b.Number
+	})
+	st.Cold = head(cold, top)
+
+	for pos := 0; pos < 3; pos++ {
+		list := make([]DigitCount, 10)
+		for dg := 0; dg < 10; dg++ {
+			list[dg] = DigitCount{Digit: string(rune('0' + dg)), Count: digits[pos][dg]}
+		}
+		st.Positions[pos] = list
+		hot := append([]DigitCount(nil), list...)
+		sort.SliceStable(hot, func(i, j int) bool { return hot[i].Count > hot[j].Count })
+		st.HotDigits[pos] = hot[:3]
+	}
+	return st
+}
+
+// LookupNumber finds every straight and box appearance of n in draws, which must be ordered newest first
+func LookupNumber(draws []ThreedData, n string) Lookup {
+	l := Lookup{Number: n, Dates: []string{}, Box: []ThreedData{}, Permutations: Permutations(n), DrawsSince: -1}
+	for i, d := range draws {
+		if d.Result == n {
+			if l.Count == 0 {
+				l.LastSeen, l.DrawsSince = d.Date, i
+			}
+			l.Count++
+			l.Dates = append(l.Dates, d.Date)
+		}
+		if IsBoxMatch(d.Result, n) {
+			if l.BoxCount == 0 {
+				l.BoxLastSeen = d.Date
+			}
+			l.BoxCount++
+			l.Box = append(l.Box, d)
+		}
+	}
+	return l
+}
+
+// formatDates rewrites the dates of st from the storage Layout into layout
+func (st *Stats) formatDates(layout string) {
+	st.From, st.To = dates.Format(st.From, layout), dates.Format(st.To, layout)
+	for _, list := range [][]NumberCount{st.Numbers, st.Hot, st.Cold} {
+		formatLastSeen(list, layout)
+	}
+}
+
+// formatDates rewrites the dates of l from the storage Layout into layout
+func (l *Lookup) formatDates(layout string) {
+	l.LastSeen, l.BoxLastSeen = dates.Format(l.LastSeen, layout), dates.Format(l.BoxLastSeen, layout)
+	for i := range l.Dates {
+		l.Dates[i] = dates.Format(l.Dates[i], layout)
+	}
+	for i := range l.Box {
+		l.Box[i].Date = dates.Format(l.Box[i].Date, layout)
+	}
+}
+
+// formatLastSeen is a no-op for entries already formatted, as Hot shares
+// its entries with Numbers
+func formatLastSeen(list []NumberCount, layout string) {
+	for i := range list {
+		list[i].LastSeen = dates.Format(list[i].LastSeen, layout)
+	}
+}
+
+func head(list []NumberCount, n int) []NumberCount {
+	if n <= 0 || n > len(list) {
+		n = len(list)
+	}
+	return list[:n]
+}
@@ -0,0 +1,109 @@
+package threedata_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gosse/threedata"
+)
+
+var history = []threedata.ThreedData{
+	{Date: "2025-08-16", Result: "321"},
+	{Date: "2025-08-01", Result: "123"},
+	{Date: "2025-07-16", Result: "508"},
+	{Date: "2025-07-01", Result: "123"},
+	{Date: "2025-06-16", Result: "777"},
+}
+
+func TestPermutationsAndBox(t *testing.T) {
+	if got := threedata.Permutations("123"); !reflect.DeepEqual(got, []string{"123", "132", "213", "231", "312", "321"}) {
+		t.Errorf("Permutations(123) = %v", got)
+	}
+	if got := threedata.Permutations("112"); !reflect.DeepEqual(got, []string{"112", "121", "211"}) {
+		t.Errorf("Permutations(112) = %v", got)
+	}
+	if !threedata.IsBoxMatch("123", "321") || threedata.IsBoxMatch("123", "124") || threedata.IsBoxMatch("112", "122") {
+		t.Error("IsBoxMatch gave a wrong answer")
+	}
+}
+
+func TestLookupNumber(t *testing.T) {
+	l := threedata.LookupNumber(history, "123")
+	if l.Count != 2 || l.LastSeen != "2025-08-01" || l.DrawsSince != 1 {
+		t.Errorf("straight matches: %+v", l)
+	}
+	if l.BoxCount != 3 || l.BoxLastSeen != "2025-08-16" {
+		t.Errorf("box matches: %+v", l)
+	}
+	if l := threedata.LookupNumber(history, "999"); l.Count != 0 || l.DrawsSince != -1 || len(l.Dates) != 0 {
+		t.Errorf("absent number: %+v", l)
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	st := threedata.ComputeStats(history, 4, 2)
+	if st.Draws != 4 || st.From != "2025-07-01" || st.To != "2025-08-16" {
+		t.Fatalf("window: %+v", st)
+	}
+	if st.Hot[0].Number != "123" || st.Hot[0].Count != 2 {
+		t.Errorf("hot: %+v", st.Hot)
+	}
+	// 777 was drawn before the window, so it is the coldest
+	if st.Cold[0].Number != "777" || st.Cold[0].Count != 0 {
+		t.Errorf("cold: %+v", st.Cold)
+	}
+	// First position over 321, 123, 508, 123: digit 1 twice
+	if st.Positions[0][1].Count != 2 || st.HotDigits[0][0].Digit != "1" {
+		t.Errorf("positions: %+v", st.Positions[0])
+	}
+}
+
+func TestThreedLookupHandler(t *testing.T) {
+	h := threedata.ThreedLookupHandler(newRepo(t))
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/threed/lookup?number=321", nil))
+	var l threedata.Lookup
+	if err := json.NewDecoder(rec.Body).Decode(&l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Count != 0 || l.BoxCount != 1 || l.Box[0].Result != "123" {
+		t.Fatalf("unexpected lookup %+v", l)
+	}
+	// dates follow date_format like /threed
+	if !strings.Contains(l.BoxLastSeen, "/") || l.Box[0].Date != l.BoxLastSeen {
+		t.Errorf("legacy dates: %+v", l)
+	}
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/threed/lookup?number=321&date_format=iso", nil))
+	l = threedata.Lookup{}
+	json.NewDecoder(rec.Body).Decode(&l)
+	if !strings.Contains(l.BoxLastSeen, "-") || l.Box[0].Date != l.BoxLastSeen {
+		t.Errorf("iso dates: %+v", l)
+	}
+	for _, target := range []string{"/threed/lookup?number=12", "/threed/lookup?number=321&date_format=unix"} {
+		rec = httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", target, rec.Code)
+		}
+	}
+}
+
+func TestThreedStatsHandlerDates(t *testing.T) {
+	h := threedata.ThreedStatsHandler(newRepo(t))
+	for target, sep := range map[string]string{"/threed/stats": "/", "/threed/stats?date_format=iso": "-"} {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var st threedata.Stats
+		if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(st.From, sep) || !strings.Contains(st.To, sep) || !strings.Contains(st.Hot[0].LastSeen, sep) || !strings.Contains(st.Cold[0].LastSeen, sep) {
+			t.Errorf("%s: dates %s..%s, hot %+v, cold %+v", target, st.From, st.To, st.Hot[0], st.Cold[0])
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package threedata
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gosse/dates"
+)
+
+// ThreedStatsHandler handles GET /threed/stats?window=24&top=10 and returns
+// per-number and per-position frequencies with hot and cold lists over the
+// newest window draws (0 or missing window means the whole history). Dates
+// follow date_format like /threed.
+func ThreedStatsHandler(repo Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		layout, err := dates.OutputLayout(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		window, ok := intParam(w, r, "window", 0)
+		if !ok {
+			return
+		}
+		top, ok := intParam(w, r, "top", 10)
+		if !ok {
+			return
+		}
+		all, err := repo.All()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		st := ComputeStats(all, window, top)
+		st.formatDates(layout)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	}
+}
+
+// ThreedLookupHandler handles GET /threed/lookup?number=123 and returns every
+// straight and box (permutation) appearance of the number. Dates follow
+// date_format like /threed.
+func ThreedLookupHandler(repo Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		layout, err := dates.OutputLayout(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n := strings.TrimSpace(r.URL.Query().Get("number"))
+		if !ValidResult(n) {
+			http.Error(w, "number must be exactly three digits", http.StatusBadRequest)
+			return
+		}
+		all, err := repo.All()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		l := LookupNumber(all, n)
+		l.formatDates(layout)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l)
+	}
+}
+
+func intParam(w http.ResponseWriter, r *http.Request, name string, def int) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		http.Error(w, "Invalid "+name+" parameter", http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
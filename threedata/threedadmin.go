@@ -0,0 +1,122 @@
+package threedata
+
+import (
+	"encoding/json"
+	"errors"
+	"gosse/audit"
+	"net/http"
+)
+
+// ResultNotifier is called after a draw result has been stored or corrected
+type ResultNotifier func(d ThreedData)
+
+// ThreedCreateHandler handles POST /threed/add to store a new draw result
+func ThreedCreateHandler(repo Repository, notify ResultNotifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ThreedData
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		d, err := Validate(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		audit.SetTarget(r, d.Date)
+		if err := repo.Create(d); err != nil {
+			writeRepoError(w, err)
+			return
+		}
+		if notify != nil {
+			notify(d)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "inserted",
+			"data":   d,
+		})
+	}
+}
+
+// ThreedUpdateHandler handles POST /threed/update?date=... to correct a stored draw.
+// The body may move the draw to another date; an empty date keeps the current one.
+func ThreedUpdateHandler(repo Repository, notify ResultNotifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		date, err := NormalizeDate(r.URL.Query().Get("date"))
+		if err != nil {
+			http.Error(w, "Invalid date parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req ThreedData
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Date == "" {
+			req.Date = date
+		}
+		d, err := Validate(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		audit.SetTarget(r, date)
+		if err := repo.Update(date, d); err != nil {
+			writeRepoError(w, err)
+			return
+		}
+		if notify != nil {
+			notify(d)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "updated",
+			"data":   d,
+		})
+	}
+}
+
+// ThreedDeleteHandler handles POST or DELETE /threed/delete?date=... to remove a draw
+func ThreedDeleteHandler(repo Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		date, err := NormalizeDate(r.URL.Query().Get("date"))
+		if err != nil {
+			http.Error(w, "Invalid date parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := repo.Delete(date); err != nil {
+			writeRepoError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "deleted",
+			"date":   date,
+		})
+	}
+}
+
+func writeRepoError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrDuplicate):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -19,13 +19,81 @@ func InitThreedDB(dbPath string) *sql.DB {
 	if err != nil {
 		log.Fatalf("failed to open db: %v", err)
 	}
-	createTable := `CREATE TABLE IF NOT EXISTS threeddata (
+	if err := InitThreedTable(db); err != nil {
+		log.Fatalf("failed to create threeddata table: %v", err)
+	}
+	return db
+}
+
+// InitThreedTable creates the threeddata table on an already open database.
+// Older files may hold several rows for one date; before the unique date index
+// is first created, all but the newest row of each date are moved to
+// threeddata_duplicate and logged, so nothing is lost.
+func InitThreedTable(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS threeddata (
         date TEXT,
         result TEXT
-    );`
-	_, err = db.Exec(createTable)
+    );`); err != nil {
+		return err
+	}
+	var indexed int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='index' AND name='threeddata_date'`).Scan(&indexed); err != nil {
+		return err
+	}
+	if indexed > 0 {
+		return nil
+	}
+	return moveDuplicates(db)
+}
+
+// moveDuplicates runs once, in one transaction with the creation of the unique date index
+func moveDuplicates(db *sql.DB) error {
+	tx, err := db.Begin()
 	if err != nil {
-		log.Fatalf("failed to create threeddata table: %v", err)
+		return err
 	}
-	return db
+	defer tx.Rollback()
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS threeddata_duplicate (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        date TEXT,
+        result TEXT,
+        moved_at TEXT NOT NULL DEFAULT (datetime('now'))
+    );`,
+		`INSERT INTO threeddata_duplicate (date, result)
+        SELECT date, result FROM threeddata
+        WHERE rowid NOT IN (SELECT MAX(rowid) FROM threeddata GROUP BY date) ORDER BY rowid;`,
+	}
+	for _, q := range stmts {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	res, err := tx.Exec(`DELETE FROM threeddata WHERE rowid NOT IN (SELECT MAX(rowid) FROM threeddata GROUP BY date)`)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		rows, err := tx.Query(`SELECT d.date, d.result, t.result FROM threeddata_duplicate d LEFT JOIN threeddata t ON t.date = d.date ORDER BY d.id`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var date, moved, kept sql.NullString
+			if err := rows.Scan(&date, &moved, &kept); err != nil {
+				rows.Close()
+				return err
+			}
+			log.Printf("threeddata: moved duplicate %s result %s to threeddata_duplicate, kept %s", date.String, moved.String, kept.String)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		log.Printf("threeddata: moved %d duplicate rows to threeddata_duplicate", n)
+	}
+	if _, err := tx.Exec(`CREATE UNIQUE INDEX threeddata_date ON threeddata (date)`); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,40 @@
+package threedata_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"gosse/threedata"
+)
+
+func TestInitThreedTableMovesDuplicates(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE threeddata (date TEXT, result TEXT);
+        INSERT INTO threeddata VALUES ('2025-08-01', '111'), ('2025-08-01', '222'), ('2025-08-16', '508');`); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := threedata.InitThreedTable(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var result string
+	if err := db.QueryRow(`SELECT result FROM threeddata WHERE date='2025-08-01'`).Scan(&result); err != nil || result != "222" {
+		t.Fatalf("kept %q, %v; want the newest row 222", result, err)
+	}
+	var moved int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM threeddata_duplicate WHERE date='2025-08-01' AND result='111'`).Scan(&moved); err != nil || moved != 1 {
+		t.Fatalf("threeddata_duplicate holds %d copies of the older row, %v; want 1", moved, err)
+	}
+
+	// The unique date index now guards new rows
+	if _, err := db.Exec(`INSERT INTO threeddata VALUES ('2025-08-01', '333')`); err == nil {
+		t.Fatal("unique date index is missing")
+	}
+}
@@ -1,32 +1,69 @@
 package threedata
 
 import (
-	"database/sql"
 	"encoding/json"
+	"gosse/dates"
+	"strconv"
 
 	"net/http"
 )
 
-// ThreedDataHandler handles GET /threeddata and returns all rows as JSON
-func ThreedDataHandler(db *sql.DB) http.HandlerFunc {
+// ThreedDataHandler handles GET /threeddata and returns rows as JSON, newest first.
+// Optional from and to parameters limit the dates, and limit and offset page
+// through the history; the total number of matching draws is returned in the
+// X-Total-Count header. Dates are written as 2025/08/15 unless date_format=iso
+// is given.
+func ThreedDataHandler(repo Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query(`SELECT date, result FROM threeddata`)
+		limit, offset, ok := parsePage(w, r)
+		if !ok {
+			return
+		}
+		layout, err := dates.OutputLayout(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, to, err := dates.ParseRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		all, total, err := repo.Page(from, to, limit, offset)
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-		var all []ThreedData
-		for rows.Next() {
-			var d ThreedData
-			err := rows.Scan(&d.Date, &d.Result)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			all = append(all, d)
+		for i := range all {
+			all[i].Date = dates.Format(all[i].Date, layout)
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(all)
 	}
 }
+
+// parsePage reads the limit and offset parameters; limit 0 means everything
+func parsePage(w http.ResponseWriter, r *http.Request) (limit, offset int, ok bool) {
+	q := r.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 1000 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset parameter", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		offset = n
+		if limit == 0 {
+			limit = 50
+		}
+	}
+	return limit, offset, true
+}
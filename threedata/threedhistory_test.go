@@ -0,0 +1,100 @@
+package threedata_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gosse/storage"
+	"gosse/threedata"
+)
+
+func newRepo(t *testing.T) *threedata.SQLiteRepository {
+	t.Helper()
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return threedata.NewSQLiteRepository(db)
+}
+
+func get(t *testing.T, h http.HandlerFunc, target string) ([]threedata.ThreedData, *httptest.ResponseRecorder) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: status = %d", target, rec.Code)
+	}
+	var got []threedata.ThreedData
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	return got, rec
+}
+
+func TestThreedDataHandler(t *testing.T) {
+	h := threedata.ThreedDataHandler(newRepo(t))
+	got, _ := get(t, h, "/threed")
+	if len(got) != 2 || got[0].Result != "123" || got[0].Date != "2025/08/01" {
+		t.Fatalf("want newest first, got %+v", got)
+	}
+	got, rec := get(t, h, "/threed?limit=1&offset=1")
+	if len(got) != 1 || got[0].Result != "508" || rec.Header().Get("X-Total-Count") != "2" {
+		t.Fatalf("unexpected page %+v (total %q)", got, rec.Header().Get("X-Total-Count"))
+	}
+}
+
+func TestThreedWriteHandlers(t *testing.T) {
+	repo := newRepo(t)
+	send := func(h http.HandlerFunc, method, target, body string) int {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec.Code
+	}
+	create := threedata.ThreedCreateHandler(repo, nil)
+
+	cases := []struct {
+		body string
+		want int
+	}{
+		{`{"date":"2025-08-16","result":"947"}`, http.StatusCreated},
+		{`{"date":"2025-08-16","result":"111"}`, http.StatusConflict},
+		{`{"date":"2025-09-01","result":"12"}`, http.StatusBadRequest},
+		{`{"date":"2025-09-01","result":"1a3"}`, http.StatusBadRequest},
+		{`{"date":"not a date","result":"123"}`, http.StatusBadRequest},
+		{`{"date":"16.08.2025","result":"123"}`, http.StatusConflict},
+		{`{"date":"2999-01-01","result":"123"}`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if got := send(create, http.MethodPost, "/threed/add", c.body); got != c.want {
+			t.Errorf("create %s: status = %d, want %d", c.body, got, c.want)
+		}
+	}
+
+	update := threedata.ThreedUpdateHandler(repo, nil)
+	if got := send(update, http.MethodPost, "/threed/update?date=2025-08-16", `{"result":"948"}`); got != http.StatusOK {
+		t.Fatalf("update status = %d", got)
+	}
+	if got := send(update, http.MethodPost, "/threed/update?date=2025/08/16", `{"date":"2025-08-01","result":"948"}`); got != http.StatusConflict {
+		t.Fatalf("update onto taken date status = %d, want 409", got)
+	}
+	if got := send(update, http.MethodPost, "/threed/update?date=2024/01/01", `{"result":"000"}`); got != http.StatusNotFound {
+		t.Fatalf("update of missing draw status = %d, want 404", got)
+	}
+
+	del := threedata.ThreedDeleteHandler(repo)
+	if got := send(del, http.MethodDelete, "/threed/delete?date=2025/07/16", ""); got != http.StatusOK {
+		t.Fatalf("delete status = %d", got)
+	}
+	if got := send(del, http.MethodDelete, "/threed/delete?date=2025/07/16", ""); got != http.StatusNotFound {
+		t.Fatalf("second delete status = %d, want 404", got)
+	}
+
+	all, _ := repo.All()
+	if len(all) != 2 || all[0].Date != "2025-08-16" || all[0].Result != "948" {
+		t.Fatalf("unexpected rows %+v", all)
+	}
+}
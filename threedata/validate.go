@@ -0,0 +1,60 @@
+package threedata
+
+import (
+	"errors"
+	"strings"
+
+	"gosse/dates"
+)
+
+// DateLayout is the layout 3D draw dates are stored in
+const DateLayout = dates.Layout
+
+var (
+	// ErrInvalidResult is returned for results that are not exactly three digits
+	ErrInvalidResult = errors.New("result must be exactly three digits")
+	// ErrInvalidDate is returned for draw dates that cannot be parsed
+	ErrInvalidDate = errors.New("unrecognized draw date, use YYYY-MM-DD")
+	// ErrFutureDate is returned for draw dates after today
+	ErrFutureDate = errors.New("draw date is in the future")
+)
+
+// Validate trims and checks d, returning it with the date in DateLayout
+func Validate(d ThreedData) (ThreedData, error) {
+	d.Result = strings.TrimSpace(d.Result)
+	if !ValidResult(d.Result) {
+		return d, ErrInvalidResult
+	}
+	date, err := NormalizeDate(d.Date)
+	if err != nil {
+		return d, err
+	}
+	d.Date = date
+	return d, nil
+}
+
+// ValidResult reports whether s is exactly three ASCII digits
+func ValidResult(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeDate parses a draw date in any format accepted by dates.Parse and
+// formats it with DateLayout; dates after today in Yangon are rejected
+func NormalizeDate(s string) (string, error) {
+	date, err := dates.Normalize(s)
+	if err != nil {
+		return "", ErrInvalidDate
+	}
+	if date > dates.Today() {
+		return "", ErrFutureDate
+	}
+	return date, nil
+}
@@ -0,0 +1,244 @@
+package twoddata
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gosse/dbutil"
+)
+
+// Correction actions
+const (
+	ActionEdit     = "edit"
+	ActionVoid     = "void"
+	ActionReinsert = "reinsert"
+)
+
+var (
+	// ErrNotFound is returned when a twoddata row does not exist
+	ErrNotFound = errors.New("twoddata row not found")
+	// ErrVoided is returned when editing or voiding a row that is already voided
+	ErrVoided = errors.New("twoddata row is voided")
+	// ErrDateTaken is returned when re-inserting or moving a result onto a date
+	// that still has a live row
+	ErrDateTaken = errors.New("a result for this date already exists")
+	// ErrInvalidResult is returned when a result is not exactly two digits
+	ErrInvalidResult = errors.New("results must be exactly two digits")
+)
+
+// Correction is one audited admin change to an archived 2D result
+type Correction struct {
+	ID        int       `json:"id"`
+	RowID     int       `json:"row_id"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Reason    string    `json:"reason"`
+	Before    *TwodData `json:"before"`
+	After     *TwodData `json:"after"`
+	CreatedAt string    `json:"created_at"`
+}
+
+// CorrectionRepository applies admin corrections and keeps their audit trail.
+// Every method changes the row and records the correction atomically.
+type CorrectionRepository interface {
+	// Get returns a row by id, including voided rows
+	Get(id int) (d TwodData, voided bool, err error)
+	// Edit replaces the values of row id with d
+	Edit(id int, d TwodData, actor, reason string) (Correction, error)
+	// Void hides row id from the public history
+	Void(id int, actor, reason string) (Correction, error)
+	// Reinsert archives d for a date that has no live row
+	Reinsert(d TwodData, actor, reason string) (Correction, error)
+	// Corrections lists the audit trail, newest first; rowID 0 lists every row
+	Corrections(rowID int) ([]Correction, error)
+}
+
+// InitCorrectionTable adds the voided flag to twoddata and creates the correction audit table
+func InitCorrectionTable(db *sql.DB) error {
+	if err := dbutil.AddColumn(db, "twoddata", "voided", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS twoddata_correction (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        row_id INTEGER NOT NULL,
+        action TEXT NOT NULL,
+        actor TEXT NOT NULL,
+        reason TEXT NOT NULL,
+        before TEXT,
+        after TEXT,
+        created_at TEXT NOT NULL
+    );`)
+	return err
+}
+
+// Get returns a single row by id
+func (s *SQLiteRepository) Get(id int) (TwodData, bool, error) {
+	return getRow(s.db.QueryRow, id)
+}
+
+func getRow(queryRow func(string, ...any) *sql.Row, id int) (TwodData, bool, error) {
+	var d TwodData
+	var voided bool
+	err := queryRow(`SELECT id, mset, mvalue, mresult, eset, evalue, eresult, tmodern, tinernet, nmodern, ninternet, date, voided FROM twoddata WHERE id=?`, id).
+		Scan(&d.ID, &d.MSet, &d.MValue, &d.MResult, &d.ESet, &d.EValue, &d.EResult, &d.TModern, &d.TInernet, &d.NModern, &d.NInernet, &d.Date, &voided)
+	if err == sql.ErrNoRows {
+		return TwodData{}, false, ErrNotFound
+	}
+	return d, voided, err
+}
+
+// Edit updates row id and records the before/after values. It refuses to move
+// the row onto a date that another live row holds.
+func (s *SQLiteRepository) Edit(id int, d TwodData, actor, reason string) (Correction, error) {
+	if !ValidResult(d.MResult) || !ValidResult(d.EResult) {
+		return Correction{}, ErrInvalidResult
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Correction{}, err
+	}
+	defer tx.Rollback()
+	before, voided, err := getRow(tx.QueryRow, id)
+	if err != nil {
+		return Correction{}, err
+	}
+	if voided {
+		return Correction{}, ErrVoided
+	}
+	var live int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM twoddata WHERE date=? AND voided=0 AND id<>?`, d.Date, id).Scan(&live); err != nil {
+		return Correction{}, err
+	}
+	if live > 0 {
+		return Correction{}, ErrDateTaken
+	}
+	d.ID = id
+	_, err = tx.Exec(`UPDATE twoddata SET mset=?, mvalue=?, mresult=?, eset=?, evalue=?, eresult=?, tmodern=?, tinernet=?, nmodern=?, ninternet=?, date=? WHERE id=?`,
+		d.MSet, d.MValue, d.MResult, d.ESet, d.EValue, d.EResult, d.TModern, d.TInernet, d.NModern, d.NInernet, d.Date, id)
+	if err != nil {
+		return Correction{}, err
+	}
+	c, err := recordCorrection(tx, Correction{RowID: id, Action: ActionEdit, Actor: actor, Reason: reason, Before: &before, After: &d})
+	if err != nil {
+		return Correction{}, err
+	}
+	return c, tx.Commit()
+}
+
+// Void marks row id as voided
+func (s *SQLiteRepository) Void(id int, actor, reason string) (Correction, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Correction{}, err
+	}
+	defer tx.Rollback()
+	before, voided, err := getRow(tx.QueryRow, id)
+	if err != nil {
+		return Correction{}, err
+	}
+	if voided {
+		return Correction{}, ErrVoided
+	}
+	if _, err := tx.Exec(`UPDATE twoddata SET voided=1 WHERE id=?`, id); err != nil {
+		return Correction{}, err
+	}
+	c, err := recordCorrection(tx, Correction{RowID: id, Action: ActionVoid, Actor: actor, Reason: reason, Before: &before})
+	if err != nil {
+		return Correction{}, err
+	}
+	return c, tx.Commit()
+}
+
+// Reinsert adds a new row for d.Date, refusing when a non-voided row already holds that date
+func (s *SQLiteRepository) Reinsert(d TwodData, actor, reason string) (Correction, error) {
+	if !ValidResult(d.MResult) || !ValidResult(d.EResult) {
+		return Correction{}, ErrInvalidResult
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Correction{}, err
+	}
+	defer tx.Rollback()
+	var live int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM twoddata WHERE date=? AND voided=0`, d.Date).Scan(&live); err != nil {
+		return Correction{}, err
+	}
+	if live > 0 {
+		return Correction{}, ErrDateTaken
+	}
+	res, err := tx.Exec(`INSERT INTO twoddata (mset, mvalue, mresult, eset, evalue, eresult, tmodern, tinernet, nmodern, ninternet, date) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.MSet, d.MValue, d.MResult, d.ESet, d.EValue, d.EResult, d.TModern, d.TInernet, d.NModern, d.NInernet, d.Date)
+	if err != nil {
+		return Correction{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Correction{}, err
+	}
+	d.ID = int(id)
+	c, err := recordCorrection(tx, Correction{RowID: d.ID, Action: ActionReinsert, Actor: actor, Reason: reason, After: &d})
+	if err != nil {
+		return Correction{}, err
+	}
+	return c, tx.Commit()
+}
+
+// Corrections returns the audit trail for rowID, or for every row when rowID is 0
+func (s *SQLiteRepository) Corrections(rowID int) ([]Correction, error) {
+	query := `SELECT id, row_id, action, actor, reason, before, after, created_at FROM twoddata_correction`
+	var args []any
+	if rowID != 0 {
+		query += ` WHERE row_id=?`
+		args = append(args, rowID)
+	}
+	rows, err := s.db.Query(query+` ORDER BY id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []Correction
+	for rows.Next() {
+		var c Correction
+		var before, after sql.NullString
+		if err := rows.Scan(&c.ID, &c.RowID, &c.Action, &c.Actor, &c.Reason, &before, &after, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		if before.Valid {
+			c.Before = new(TwodData)
+			if err := json.Unmarshal([]byte(before.String), c.Before); err != nil {
+				return nil, err
+			}
+		}
+		if after.Valid {
+			c.After = new(TwodData)
+			if err := json.Unmarshal([]byte(after.String), c.After); err != nil {
+				return nil, err
+			}
+		}
+		all = append(all, c)
+	}
+	return all, rows.Err()
+}
+
+func recordCorrection(tx *sql.Tx, c Correction) (Correction, error) {
+	c.CreatedAt = time.Now().Format(time.RFC3339)
+	var before, after sql.NullString
+	if c.Before != nil {
+		b, _ := json.Marshal(c.Before)
+		before = sql.NullString{String: string(b), Valid: true}
+	}
+	if c.After != nil {
+		b, _ := json.Marshal(c.After)
+		after = sql.NullString{String: string(b), Valid: true}
+	}
+	res, err := tx.Exec(`INSERT INTO twoddata_correction (row_id, action, actor, reason, before, after, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		c.RowID, c.Action, c.Actor, c.Reason, before, after, c.CreatedAt)
+	if err != nil {
+		return Correction{}, err
+	}
+	id, err := res.LastInsertId()
+	c.ID = int(id)
+	return c, err
+}
@@ -0,0 +1,71 @@
+package twoddata
+
+import (
+	"database/sql"
+
+	"gosse/dates"
+)
+
+// Repository is the storage contract for archived 2D results
+type Repository interface {
+	// All returns every archived result ordered by date, skipping voided rows
+	All() ([]TwodData, error)
+	// Between returns the results dated within the inclusive ISO range; empty bounds are open
+	Between(from, to string) ([]TwodData, error)
+	// CountByDate returns how many results are archived for the given date, voided rows included
+	CountByDate(date string) (int, error)
+	// Insert archives a new result; d.Date must already be normalized
+	Insert(d TwodData) error
+}
+
+// SQLiteRepository implements Repository on top of the twoddata table
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository wraps an open database handle
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{db: db}
+}
+
+// All returns every row of the twoddata table
+func (s *SQLiteRepository) All() ([]TwodData, error) {
+	return s.Between("", "")
+}
+
+// Between returns the rows whose date lies in the inclusive range
+func (s *SQLiteRepository) Between(from, to string) ([]TwodData, error) {
+	query := `SELECT id, mset, mvalue, mresult, eset, evalue, eresult, tmodern, tinernet, nmodern, ninternet, date FROM twoddata WHERE voided=0`
+	cond, args := dates.RangeClause("date", from, to)
+	if cond != "" {
+		query += " AND " + cond
+	}
+	rows, err := s.db.Query(query+" ORDER BY date, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []TwodData
+	for rows.Next() {
+		var d TwodData
+		if err := rows.Scan(&d.ID, &d.MSet, &d.MValue, &d.MResult, &d.ESet, &d.EValue, &d.EResult, &d.TModern, &d.TInernet, &d.NModern, &d.NInernet, &d.Date); err != nil {
+			return nil, err
+		}
+		all = append(all, d)
+	}
+	return all, rows.Err()
+}
+
+// CountByDate returns the number of rows stored for date
+func (s *SQLiteRepository) CountByDate(date string) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM twoddata WHERE date = ?", date).Scan(&count)
+	return count, err
+}
+
+// Insert adds a new row to the twoddata table
+func (s *SQLiteRepository) Insert(d TwodData) error {
+	_, err := s.db.Exec(`INSERT INTO twoddata (mset, mvalue, mresult, eset, evalue, eresult, tmodern, tinernet, nmodern, ninternet, date) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.MSet, d.MValue, d.MResult, d.ESet, d.EValue, d.EResult, d.TModern, d.TInernet, d.NModern, d.NInernet, d.Date)
+	return err
+}
@@ -13,6 +13,17 @@ func InitDB(dbPath string) *sql.DB {
 	if err != nil {
 		log.Fatalf("failed to open db: %v", err)
 	}
+	if err := InitTwodDataTable(db); err != nil {
+		log.Fatalf("failed to create table: %v", err)
+	}
+	if err := InitCorrectionTable(db); err != nil {
+		log.Fatalf("failed to create correction table: %v", err)
+	}
+	return db
+}
+
+// InitTwodDataTable creates the twoddata table on an already open database
+func InitTwodDataTable(db *sql.DB) error {
 	createTable := `CREATE TABLE IF NOT EXISTS twoddata (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         mset TEXT,
@@ -29,9 +40,6 @@ func InitDB(dbPath string) *sql.DB {
         date TEXT,
         status TEXT
     );`
-	_, err = db.Exec(createTable)
-	if err != nil {
-		log.Fatalf("failed to create table: %v", err)
-	}
-	return db
+	_, err := db.Exec(createTable)
+	return err
 }
@@ -33,3 +33,25 @@ const (
 	ColDate     = "date"
 	// ColUpdateTime and ColStatus removed
 )
+
+// Match returns the sessions a two-digit ticket wins, reported by their column
+// names: ColMResult for the 12:01 result and ColEResult for the 4:30 result.
+// Sessions that are not decided yet ("--" or empty) never match.
+func (d TwodData) Match(ticket string) []string {
+	if !ValidResult(ticket) {
+		return nil
+	}
+	var won []string
+	if ValidResult(d.MResult) && d.MResult == ticket {
+		won = append(won, ColMResult)
+	}
+	if ValidResult(d.EResult) && d.EResult == ticket {
+		won = append(won, ColEResult)
+	}
+	return won
+}
+
+// ValidResult reports whether s is exactly two ASCII digits
+func ValidResult(s string) bool {
+	return len(s) == 2 && s[0] >= '0' && s[0] <= '9' && s[1] >= '0' && s[1] <= '9'
+}
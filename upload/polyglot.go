@@ -0,0 +1,243 @@
+package upload
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gosse/imaging"
+	"io"
+)
+
+var errTruncated = errors.New("image data is truncated")
+
+// markup is content a browser or interpreter would act on if the file were
+// ever served or included as something other than an image
+var markup = [][]byte{
+	[]byte("<?php"), []byte("<?="), []byte("<script"), []byte("<html"),
+	[]byte("<svg"), []byte("<!doctype"), []byte("<iframe"), []byte("<body"),
+}
+
+// maxInflated caps how much of a compressed PNG text chunk is scanned
+const maxInflated = 1 << 20
+
+// checkPolyglot rejects data that is more than one image: bytes after the
+// end of the image stream, or embedded markup in a metadata segment. Only
+// metadata is scanned; compressed pixel data matches short patterns like
+// "<?=" by chance.
+func checkPolyglot(data []byte, format string) error {
+	var end int
+	var meta [][]byte
+	var err error
+	switch format {
+	case imaging.FormatJPEG:
+		end, meta, err = jpegEnd(data)
+	case imaging.FormatPNG:
+		end, meta, err = pngEnd(data)
+	case imaging.FormatGIF:
+		end, meta, err = gifEnd(data)
+	default:
+		return imaging.ErrUnsupported
+	}
+	if err != nil {
+		return err
+	}
+	// some encoders pad files with zero bytes, anything else is a second payload
+	for _, b := range data[end:] {
+		if b != 0 {
+			return fmt.Errorf("%d bytes of trailing data after the image", len(data)-end)
+		}
+	}
+	for _, seg := range meta {
+		lower := bytes.ToLower(seg)
+		for _, m := range markup {
+			if bytes.Contains(lower, m) {
+				return fmt.Errorf("embedded %q content", m)
+			}
+		}
+	}
+	return nil
+}
+
+// jpegEnd returns the offset just past the EOI marker and the payloads of the
+// APPn and comment segments
+func jpegEnd(data []byte) (int, [][]byte, error) {
+	var meta [][]byte
+	i := 2
+	for {
+		if i+2 > len(data) {
+			return 0, nil, errTruncated
+		}
+		if data[i] != 0xFF {
+			return 0, nil, errors.New("malformed JPEG marker")
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF: // fill byte
+			i++
+			continue
+		case marker == 0xD9:
+			return i + 2, meta, nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return 0, nil, errTruncated
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return 0, nil, errTruncated
+		}
+		if marker == 0xFE || (marker >= 0xE0 && marker <= 0xEF) {
+			meta = append(meta, data[i+4:i+2+n])
+		}
+		i += 2 + n
+		if marker != 0xDA {
+			continue
+		}
+		// entropy-coded data runs until a marker other than a stuffed zero or a restart
+		for {
+			j := bytes.IndexByte(data[i:], 0xFF)
+			if j < 0 || i+j+1 >= len(data) {
+				return 0, nil, errTruncated
+			}
+			i += j
+			next := data[i+1]
+			if next == 0x00 || (next >= 0xD0 && next <= 0xD7) {
+				i += 2
+				continue
+			}
+			if next == 0xFF {
+				i++
+				continue
+			}
+			break
+		}
+	}
+}
+
+// pngEnd returns the offset just past the IEND chunk and the text of the
+// tEXt, iTXt and zTXt chunks, inflated when compressed
+func pngEnd(data []byte) (int, [][]byte, error) {
+	var meta [][]byte
+	i := 8
+	for {
+		if i+12 > len(data) {
+			return 0, nil, errTruncated
+		}
+		n := int64(binary.BigEndian.Uint32(data[i:]))
+		end := int64(i) + 12 + n
+		if end > int64(len(data)) {
+			return 0, nil, errTruncated
+		}
+		body := data[i+8 : end-4]
+		switch string(data[i+4 : i+8]) {
+		case "IEND":
+			return int(end), meta, nil
+		case "tEXt":
+			meta = append(meta, body)
+		case "zTXt":
+			// keyword, NUL, compression method, compressed text
+			meta = append(meta, body)
+			if k := bytes.IndexByte(body, 0); k >= 0 && k+2 <= len(body) {
+				meta = append(meta, inflate(body[k+2:]))
+			}
+		case "iTXt":
+			// keyword, NUL, compression flag and method, language and
+			// translated keyword (both NUL terminated), text
+			meta = append(meta, body)
+			if k := bytes.IndexByte(body, 0); k >= 0 && k+3 <= len(body) && body[k+1] == 1 {
+				rest := body[k+3:]
+				for skip := 0; skip < 2; skip++ {
+					if z := bytes.IndexByte(rest, 0); z >= 0 {
+						rest = rest[z+1:]
+					}
+				}
+				meta = append(meta, inflate(rest))
+			}
+		}
+		i = int(end)
+	}
+}
+
+// inflate decompresses a zlib stream up to maxInflated bytes; a broken stream
+// yields what could be read
+func inflate(b []byte) []byte {
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+	out, _ := io.ReadAll(io.LimitReader(zr, maxInflated))
+	return out
+}
+
+// gifEnd returns the offset just past the trailer byte and the data of the
+// comment, plain text and application extensions
+func gifEnd(data []byte) (int, [][]byte, error) {
+	var meta [][]byte
+	if len(data) < 13 {
+		return 0, nil, errTruncated
+	}
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+	// subBlocks skips a chain of length-prefixed blocks ending in a zero
+	// length and returns their joined data when keep is set
+	subBlocks := func(keep bool) ([]byte, error) {
+		var joined []byte
+		for {
+			if i >= len(data) {
+				return nil, errTruncated
+			}
+			n := int(data[i])
+			if keep && i+1+n <= len(data) {
+				joined = append(joined, data[i+1:i+1+n]...)
+			}
+			i += 1 + n
+			if n == 0 {
+				return joined, nil
+			}
+		}
+	}
+	for {
+		if i >= len(data) {
+			return 0, nil, errTruncated
+		}
+		switch data[i] {
+		case 0x3B:
+			return i + 1, meta, nil
+		case 0x21: // extension: introducer, label, data
+			if i+1 >= len(data) {
+				return 0, nil, errTruncated
+			}
+			label := data[i+1]
+			i += 2
+			text, err := subBlocks(label == 0xFE || label == 0x01 || label == 0xFF)
+			if err != nil {
+				return 0, nil, err
+			}
+			if text != nil {
+				meta = append(meta, text)
+			}
+		case 0x2C: // image descriptor, optional local colour table, LZW code size, data
+			if i+10 > len(data) {
+				return 0, nil, errTruncated
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i++
+			if _, err := subBlocks(false); err != nil {
+				return 0, nil, err
+			}
+		default:
+			return 0, nil, fmt.Errorf("malformed GIF block 0x%02x", data[i])
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package upload
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Quota limits how many files and bytes each uploader may store within a
+// sliding window. It is kept in memory, so it restarts empty with the server.
+// A nil *Quota allows everything.
+type Quota struct {
+	Files  int
+	Bytes  int64
+	Window time.Duration
+	// Now is the clock; tests replace it
+	Now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string][]quotaEntry
+	nextID  uint64
+}
+
+type quotaEntry struct {
+	id   uint64
+	at   time.Time
+	size int64
+}
+
+// NewQuota allows files uploads totalling bytes per uploader within window
+func NewQuota(files int, bytes int64, window time.Duration) *Quota {
+	return &Quota{Files: files, Bytes: bytes, Window: window, Now: time.Now, entries: map[string][]quotaEntry{}}
+}
+
+// Usage returns the files and bytes who uploaded within the window
+func (q *Quota) Usage(who string) (int, int64) {
+	if q == nil {
+		return 0, 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	live := q.prune(who)
+	var total int64
+	for _, e := range live {
+		total += e.size
+	}
+	return len(live), total
+}
+
+// Check fails if who cannot upload anything more right now
+func (q *Quota) Check(who string) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.admit(who, 0)
+}
+
+// Reserve charges an upload of size bytes to who. The returned release func
+// refunds it, for uploads that fail before they are stored.
+func (q *Quota) Reserve(who string, size int64) (func(), error) {
+	if q == nil {
+		return func() {}, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.admit(who, size); err != nil {
+		return nil, err
+	}
+	q.nextID++
+	id := q.nextID
+	q.entries[who] = append(q.entries[who], quotaEntry{id: id, at: q.Now(), size: size})
+	return func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		list := q.entries[who]
+		for i, e := range list {
+			if e.id == id {
+				q.entries[who] = append(list[:i:i], list[i+1:]...)
+				break
+			}
+		}
+	}, nil
+}
+
+// admit checks one more upload of size bytes; q.mu must be held
+func (q *Quota) admit(who string, size int64) error {
+	live := q.prune(who)
+	var total int64
+	for _, e := range live {
+		total += e.size
+	}
+	overFiles := q.Files > 0 && len(live)+1 > q.Files
+	overBytes := q.Bytes > 0 && total+size > q.Bytes
+	if !overFiles && !overBytes {
+		return nil
+	}
+	// retry once enough of the oldest uploads have left the window
+	var wait time.Duration
+	if q.Bytes <= 0 || size <= q.Bytes {
+		for k, e := range live {
+			total -= e.size
+			wait = e.at.Add(q.Window).Sub(q.Now())
+			if (q.Files <= 0 || len(live)-k <= q.Files) && (q.Bytes <= 0 || total+size <= q.Bytes) {
+				break
+			}
+		}
+	}
+	return &Error{
+		Status:     http.StatusTooManyRequests,
+		Message:    fmt.Sprintf("upload quota exceeded: %d files or %d bytes per %s", q.Files, q.Bytes, q.Window),
+		RetryAfter: wait,
+	}
+}
+
+// prune drops entries older than the window and returns the rest, oldest first; q.mu must be held
+func (q *Quota) prune(who string) []quotaEntry {
+	if q.entries == nil {
+		q.entries = map[string][]quotaEntry{}
+	}
+	cutoff := q.Now().Add(-q.Window)
+	list := q.entries[who]
+	i := 0
+	for i < len(list) && !list[i].at.After(cutoff) {
+		i++
+	}
+	if i == len(list) {
+		delete(q.entries, who)
+		return nil
+	}
+	q.entries[who] = list[i:]
+	return list[i:]
+}
@@ -0,0 +1,181 @@
+// Package upload receives image uploads safely: it caps the request size,
+// checks that the bytes really are one complete JPEG, PNG or GIF image of
+// acceptable dimensions, charges per-uploader quotas and writes files
+// atomically.
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gosse/admin"
+	"gosse/audit"
+	"gosse/imaging"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limits bounds what an Uploader accepts
+type Limits struct {
+	// MaxBytes is the largest accepted request body
+	MaxBytes int64
+	// MaxWidth and MaxHeight bound each side of the image in pixels
+	MaxWidth  int
+	MaxHeight int
+	// MaxPixels bounds width*height, which decides the memory a decode needs
+	MaxPixels int
+}
+
+// DefaultLimits allow 10 MB images of up to 8000x8000 and 40 megapixels
+var DefaultLimits = Limits{MaxBytes: 10 << 20, MaxWidth: 8000, MaxHeight: 8000, MaxPixels: 40_000_000}
+
+// Error is a rejected upload with the HTTP status to answer with
+type Error struct {
+	Status     int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func reject(status int, format string, args ...any) *Error {
+	return &Error{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// WriteError answers with the status of an *Error, or 500 for any other error
+func WriteError(w http.ResponseWriter, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((e.RetryAfter+time.Second-1)/time.Second)))
+	}
+	http.Error(w, e.Message, e.Status)
+}
+
+// File is an accepted upload
+type File struct {
+	Data     []byte
+	Format   string
+	Image    image.Image
+	Width    int
+	Height   int
+	Uploader string
+
+	release func()
+}
+
+// Release gives the quota charged for f back, for uploads that fail after Receive
+func (f *File) Release() {
+	if f.release != nil {
+		f.release()
+		f.release = nil
+	}
+}
+
+// Uploader checks incoming uploads against its limits and quota
+type Uploader struct {
+	Limits Limits
+	// Quota is charged for every accepted upload; nil disables quotas
+	Quota *Quota
+	// Auth recognises admin tokens on routes that do not require them. Admin
+	// uploads are charged to the admin name, all others to the client IP.
+	Auth *admin.Authenticator
+}
+
+// New returns an Uploader with the given limits and quota
+func New(limits Limits, quota *Quota) *Uploader {
+	return &Uploader{Limits: limits, Quota: quota}
+}
+
+func (u *Uploader) identify(r *http.Request) string {
+	if name := admin.Actor(r); name != "" {
+		return "admin:" + name
+	}
+	if u.Auth != nil {
+		if name, ok := u.Auth.Authenticate(r); ok {
+			return "admin:" + name
+		}
+	}
+	return "ip:" + audit.ClientIP(r)
+}
+
+// Receive reads the request body as an image upload. Errors are *Error values
+// carrying the status to answer with; use WriteError.
+func (u *Uploader) Receive(w http.ResponseWriter, r *http.Request) (*File, error) {
+	who := u.identify(r)
+	if err := u.Quota.Check(who); err != nil {
+		return nil, err
+	}
+	if r.ContentLength > u.Limits.MaxBytes {
+		return nil, reject(http.StatusRequestEntityTooLarge, "image is larger than %d bytes", u.Limits.MaxBytes)
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, u.Limits.MaxBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, reject(http.StatusRequestEntityTooLarge, "image is larger than %d bytes", u.Limits.MaxBytes)
+		}
+		return nil, reject(http.StatusBadRequest, "failed to read image: %v", err)
+	}
+	f, err := u.Inspect(data)
+	if err != nil {
+		return nil, err
+	}
+	f.Uploader = who
+	if f.release, err = u.Quota.Reserve(who, int64(len(data))); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Inspect accepts data only if it is exactly one JPEG, PNG or GIF image within the limits
+func (u *Uploader) Inspect(data []byte) (*File, error) {
+	if len(data) == 0 {
+		return nil, reject(http.StatusBadRequest, "empty upload")
+	}
+	format := imaging.Sniff(data)
+	if format == "" || http.DetectContentType(data) != "image/"+format {
+		return nil, reject(http.StatusUnsupportedMediaType, "%v", imaging.ErrUnsupported)
+	}
+	if err := checkPolyglot(data, format); err != nil {
+		return nil, reject(http.StatusBadRequest, "rejected image: %v", err)
+	}
+
+	// Check the declared size before decoding, so a small file cannot claim a huge canvas
+	var cfg image.Config
+	var err error
+	switch format {
+	case imaging.FormatJPEG:
+		cfg, err = jpeg.DecodeConfig(bytes.NewReader(data))
+	case imaging.FormatPNG:
+		cfg, err = png.DecodeConfig(bytes.NewReader(data))
+	case imaging.FormatGIF:
+		cfg, err = gif.DecodeConfig(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, reject(http.StatusBadRequest, "invalid %s header: %v", format, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > u.Limits.MaxWidth || cfg.Height > u.Limits.MaxHeight {
+		return nil, reject(http.StatusBadRequest, "image is %dx%d, at most %dx%d allowed", cfg.Width, cfg.Height, u.Limits.MaxWidth, u.Limits.MaxHeight)
+	}
+	if u.Limits.MaxPixels > 0 && cfg.Width*cfg.Height > u.Limits.MaxPixels {
+		return nil, reject(http.StatusBadRequest, "image is %dx%d, %d pixels, at most %d allowed", cfg.Width, cfg.Height, cfg.Width*cfg.Height, u.Limits.MaxPixels)
+	}
+
+	img, _, err := imaging.Decode(data)
+	if err != nil {
+		return nil, reject(http.StatusBadRequest, "invalid image: %v", err)
+	}
+	// Decode turns photos upright, so take the size from the image rather than the header
+	b := img.Bounds()
+	return &File{Data: data, Format: format, Image: img, Width: b.Dx(), Height: b.Dy()}, nil
+}
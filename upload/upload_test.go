@@ -0,0 +1,199 @@
+package upload_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gosse/upload"
+)
+
+func encode(t *testing.T, format string, w, h int) []byte {
+	t.Helper()
+	img := image.NewPaletted(image.Rect(0, 0, w, h), color.Palette{color.Black, color.White})
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func status(err error) int {
+	var e *upload.Error
+	if errors.As(err, &e) {
+		return e.Status
+	}
+	return 0
+}
+
+func TestInspect(t *testing.T) {
+	u := upload.New(upload.Limits{MaxBytes: 1 << 20, MaxWidth: 400, MaxHeight: 400, MaxPixels: 100_000}, nil)
+	for _, format := range []string{"jpeg", "png", "gif"} {
+		data := encode(t, format, 64, 32)
+		f, err := u.Inspect(data)
+		if err != nil || f.Format != format || f.Width != 64 || f.Height != 32 {
+			t.Errorf("%s: %+v, %v", format, f, err)
+			continue
+		}
+		// zero padding is tolerated, a zip archive after the image is not
+		if _, err := u.Inspect(append(append([]byte{}, data...), 0, 0, 0)); err != nil {
+			t.Errorf("%s with padding: %v", format, err)
+		}
+		zip := append(append([]byte{}, data...), "PK\x03\x04payload"...)
+		if _, err := u.Inspect(zip); status(err) != http.StatusBadRequest {
+			t.Errorf("%s with appended zip: %v", format, err)
+		}
+		if _, err := u.Inspect(data[:len(data)-1]); status(err) != http.StatusBadRequest {
+			t.Errorf("%s truncated: %v", format, err)
+		}
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"empty", nil, http.StatusBadRequest},
+		{"text", []byte("<?php system($_GET['c']); ?>"), http.StatusUnsupportedMediaType},
+		{"wide", encode(t, "png", 401, 10), http.StatusBadRequest},
+		{"too many pixels", encode(t, "png", 400, 400), http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if _, err := u.Inspect(c.data); status(err) != c.want {
+			t.Errorf("%s: %v, want status %d", c.name, err, c.want)
+		}
+	}
+	// the message names the limit that was hit
+	if _, err := u.Inspect(encode(t, "png", 400, 400)); err == nil || !strings.Contains(err.Error(), "at most 100000 allowed") {
+		t.Errorf("too many pixels: %v", err)
+	}
+
+	// markup hidden in a PNG text chunk
+	data := encode(t, "png", 8, 8)
+	chunk := []byte("\x00\x00\x00\x12tEXtc\x00<script>alert(1)\x00\x00\x00\x00")
+	evil := append(append(append([]byte{}, data[:33]...), chunk...), data[33:]...)
+	if _, err := u.Inspect(evil); status(err) != http.StatusBadRequest {
+		t.Errorf("png with script chunk: %v", err)
+	}
+
+	// compressed text is inflated before it is scanned
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte("<?php echo 1; ?>"))
+	zw.Close()
+	evil = withPNGChunk(data, "zTXt", append([]byte("c\x00\x00"), z.Bytes()...))
+	if _, err := u.Inspect(evil); status(err) != http.StatusBadRequest {
+		t.Errorf("png with compressed php chunk: %v", err)
+	}
+
+	// markup in a JPEG comment
+	jpg := encode(t, "jpeg", 8, 8)
+	com := append([]byte{0xFF, 0xFE, 0x00, 0x0A}, []byte("<?= $x ?>")[:8]...)
+	evil = append(append(append([]byte{}, jpg[:2]...), com...), jpg[2:]...)
+	if _, err := u.Inspect(evil); status(err) != http.StatusBadRequest {
+		t.Errorf("jpeg with php comment: %v", err)
+	}
+
+	// bytes outside metadata, like compressed pixels, are not scanned
+	if _, err := u.Inspect(withPNGChunk(data, "gsSe", []byte("a<?=b"))); err != nil {
+		t.Errorf("png with markup-like bytes in a private chunk: %v", err)
+	}
+}
+
+// withPNGChunk inserts a chunk with a valid CRC after the IHDR chunk
+func withPNGChunk(data []byte, typ string, body []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	chunk = append(append(chunk, typ...), body...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	return append(append(append([]byte{}, data[:33]...), chunk...), data[33:]...)
+}
+
+func TestReceive(t *testing.T) {
+	now := time.Date(2025, 8, 15, 12, 0, 0, 0, time.UTC)
+	quota := upload.NewQuota(2, 1<<20, time.Hour)
+	quota.Now = func() time.Time { return now }
+	u := upload.New(upload.Limits{MaxBytes: 4096, MaxWidth: 1000, MaxHeight: 1000, MaxPixels: 1_000_000}, quota)
+	img := encode(t, "png", 16, 16)
+
+	send := func(body []byte) (*upload.File, error) {
+		req := httptest.NewRequest(http.MethodPost, "/addgift/", bytes.NewReader(body))
+		req.RemoteAddr = "10.0.0.1:5000"
+		return u.Receive(httptest.NewRecorder(), req)
+	}
+	if _, err := send(make([]byte, 5000)); status(err) != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: %v", err)
+	}
+	f, err := send(img)
+	if err != nil || f.Uploader != "ip:10.0.0.1" {
+		t.Fatalf("first upload: %+v, %v", f, err)
+	}
+	// a failed upload gives its share back
+	f, err = send(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Release()
+	if _, err := send(img); err != nil {
+		t.Fatalf("upload after release: %v", err)
+	}
+	if n, _ := quota.Usage("ip:10.0.0.1"); n != 2 {
+		t.Errorf("usage = %d files, want 2", n)
+	}
+
+	_, err = send(img)
+	var e *upload.Error
+	if !errors.As(err, &e) || e.Status != http.StatusTooManyRequests || e.RetryAfter != time.Hour {
+		t.Fatalf("over quota: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	upload.WriteError(rec, err)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "3600" {
+		t.Errorf("WriteError = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	now = now.Add(time.Hour + time.Second)
+	if _, err := send(img); err != nil {
+		t.Errorf("upload after the window: %v", err)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.WriteFile(dir, "a.png", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "a.png"))
+	entries, _ := os.ReadDir(dir)
+	if string(got) != "new" || len(entries) != 1 {
+		t.Errorf("content %q, %d entries", got, len(entries))
+	}
+	if err := upload.WriteFile(filepath.Join(dir, "missing"), "b.png", nil); err == nil {
+		t.Error("write into a missing dir succeeded")
+	}
+}
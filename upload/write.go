@@ -0,0 +1,31 @@
+package upload
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes data to dir/name through a temporary file in dir that is
+// synced and renamed into place, so readers never see a partial image
+func WriteFile(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
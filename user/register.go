@@ -1,14 +1,13 @@
 package user
 
 import (
-	"database/sql"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
 )
 
 // RegisterUserHandler handles POST /register to add a new useraccount if id not exists
-func RegisterUserHandler(db *sql.DB) http.HandlerFunc {
+func RegisterUserHandler(repo Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -23,18 +22,18 @@ func RegisterUserHandler(db *sql.DB) http.HandlerFunc {
 		}
 
 		// Check if user already exists
-		var exists string
-		err := db.QueryRow("SELECT id FROM useraccount WHERE id=?", id).Scan(&exists)
-		if err == nil {
+		_, exists, err := repo.Get(id)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if exists {
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]interface{}{
 				"status": "already registered",
 				"id":     id,
 			})
 			return
-		} else if err != sql.ErrNoRows {
-			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
-			return
 		}
 
 		// Parse JSON body
@@ -50,8 +49,7 @@ func RegisterUserHandler(db *sql.DB) http.HandlerFunc {
 		}
 
 		// Insert new user
-		_, err = db.Exec("INSERT INTO useraccount (id, name, profile_pic, email) VALUES (?, ?, ?, ?)", user.ID, user.Name, user.ProfilePic, user.Email)
-		if err != nil {
+		if err := repo.Create(user); err != nil {
 			http.Error(w, "Database insert error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -0,0 +1,42 @@
+package user_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gosse/storage"
+	"gosse/user"
+)
+
+func TestRegisterUserHandler(t *testing.T) {
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := user.NewSQLiteRepository(db)
+	h := user.RegisterUserHandler(repo)
+
+	register := func(id, body string) string {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/register?id="+id, strings.NewReader(body)))
+		var resp map[string]any
+		json.NewDecoder(rec.Body).Decode(&resp)
+		s, _ := resp["status"].(string)
+		return s
+	}
+
+	if got := register("u1", `{}`); got != "already registered" {
+		t.Fatalf("status = %q, want already registered", got)
+	}
+	if got := register("u2", `{"id":"u2","name":"Su Su","profile_pic":"p.png"}`); got != "registered" {
+		t.Fatalf("status = %q, want registered", got)
+	}
+	u, ok, err := repo.Get("u2")
+	if err != nil || !ok || u.Name != "Su Su" {
+		t.Fatalf("Get(u2) = %+v, %v, %v", u, ok, err)
+	}
+}
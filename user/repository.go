@@ -0,0 +1,44 @@
+package user
+
+import (
+	"database/sql"
+)
+
+// Repository is the storage contract for user accounts
+type Repository interface {
+	// Get returns the account with the given id; ok is false when it does not exist
+	Get(id string) (u UserAccount, ok bool, err error)
+	// Create stores a new account
+	Create(u UserAccount) error
+}
+
+// SQLiteRepository implements Repository on top of the useraccount table
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository wraps an open database handle
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{db: db}
+}
+
+// Get looks up a useraccount row by id
+func (s *SQLiteRepository) Get(id string) (UserAccount, bool, error) {
+	var u UserAccount
+	var name, pic, email sql.NullString
+	err := s.db.QueryRow("SELECT id, name, profile_pic, email FROM useraccount WHERE id=?", id).Scan(&u.ID, &name, &pic, &email)
+	if err == sql.ErrNoRows {
+		return UserAccount{}, false, nil
+	}
+	if err != nil {
+		return UserAccount{}, false, err
+	}
+	u.Name, u.ProfilePic, u.Email = name.String, pic.String, email.String
+	return u, true, nil
+}
+
+// Create inserts a new useraccount row
+func (s *SQLiteRepository) Create(u UserAccount) error {
+	_, err := s.db.Exec("INSERT INTO useraccount (id, name, profile_pic, email) VALUES (?, ?, ?, ?)", u.ID, u.Name, u.ProfilePic, u.Email)
+	return err
+}
@@ -0,0 +1,227 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gosse/admin"
+	"gosse/audit"
+	"gosse/user"
+)
+
+// postRequest is the body of the top-up, spend and refund endpoints
+type postRequest struct {
+	UserID         string `json:"user_id"`
+	Amount         int64  `json:"amount"`
+	IdempotencyKey string `json:"idempotency_key"`
+	Reference      string `json:"reference"`
+	TransactionID  int64  `json:"transaction_id"`
+}
+
+// TopUpHandler handles POST /admin/wallet/topup with
+// {"user_id": "...", "amount": 100, "idempotency_key": "...", "reference": "..."}
+// and credits a registered user with newly minted coins
+func TopUpHandler(store Store, users user.Repository) http.HandlerFunc {
+	return postHandler(store, KindTopUp, users)
+}
+
+// SpendHandler handles POST /admin/wallet/spend with
+// {"user_id": "...", "amount": 100, "idempotency_key": "...", "reference": "..."}
+// and debits the user; it fails with 402 when the balance is too low
+func SpendHandler(store Store) http.HandlerFunc {
+	return postHandler(store, KindSpend, nil)
+}
+
+// RefundHandler handles POST /admin/wallet/refund with
+// {"transaction_id": 12, "amount": 50, "idempotency_key": "..."} and returns
+// coins of a spend to its user; without an amount the rest of the spend is refunded
+func RefundHandler(store Store) http.HandlerFunc {
+	return postHandler(store, KindRefund, nil)
+}
+
+// postHandler posts one transaction of kind. The idempotency key may also be
+// given in the Idempotency-Key header.
+func postHandler(store Store, kind string, users user.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req postRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.IdempotencyKey == "" {
+			req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+		}
+		req.IdempotencyKey = strings.TrimSpace(req.IdempotencyKey)
+		req.UserID = strings.TrimSpace(req.UserID)
+		if req.IdempotencyKey == "" {
+			http.Error(w, "idempotency_key is required", http.StatusBadRequest)
+			return
+		}
+		t := Transaction{Kind: kind, Amount: req.Amount, IdempotencyKey: req.IdempotencyKey, Reference: req.Reference, Actor: admin.Actor(r)}
+		if kind == KindRefund {
+			if req.TransactionID <= 0 || req.Amount < 0 {
+				http.Error(w, "transaction_id is required and amount must not be negative", http.StatusBadRequest)
+				return
+			}
+			t.RefundOf = req.TransactionID
+			audit.SetTarget(r, strconv.FormatInt(req.TransactionID, 10))
+		} else {
+			if req.UserID == "" || req.Amount <= 0 {
+				http.Error(w, "user_id is required and amount must be positive", http.StatusBadRequest)
+				return
+			}
+			t.UserID = req.UserID
+			audit.SetTarget(r, req.UserID)
+		}
+		if users != nil {
+			_, ok, err := users.Get(req.UserID)
+			if err != nil {
+				http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "Unknown user "+req.UserID, http.StatusNotFound)
+				return
+			}
+		}
+
+		saved, replayed, err := store.Post(t)
+		switch {
+		case errors.Is(err, ErrInsufficientFunds):
+			have, _ := store.Balance(t.UserID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusPaymentRequired)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  "insufficient funds",
+				"balance": have,
+				"amount":  t.Amount,
+			})
+			return
+		case errors.Is(err, ErrKeyReused):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":      "idempotency key reused",
+				"transaction": saved,
+			})
+			return
+		case errors.Is(err, ErrNotFound):
+			http.Error(w, "Transaction not found", http.StatusNotFound)
+			return
+		case errors.Is(err, ErrNotRefundable):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		have, err := store.Balance(saved.UserID)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		status := "posted"
+		if replayed {
+			status = "replayed"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":      status,
+			"transaction": saved,
+			"balance":     have,
+		})
+	}
+}
+
+// BalanceHandler handles GET /wallet/balance?user_id= and returns the user's
+// balance. Wrap it with admin.Authenticator.Require.
+func BalanceHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("user_id")
+		if id == "" {
+			http.Error(w, "Missing user_id parameter", http.StatusBadRequest)
+			return
+		}
+		have, err := store.Balance(id)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"user_id": id,
+			"balance": have,
+		})
+	}
+}
+
+// StatementHandler handles GET /wallet/statement?user_id=&before=&limit= and
+// returns the user's transactions newest first with the balance after each.
+// limit defaults to 50; pass the last transaction_id as before for the next page.
+// Wrap it with admin.Authenticator.Require.
+func StatementHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		id := q.Get("user_id")
+		if id == "" {
+			http.Error(w, "Missing user_id parameter", http.StatusBadRequest)
+			return
+		}
+		var before int64
+		if v := q.Get("before"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				http.Error(w, "Invalid before parameter", http.StatusBadRequest)
+				return
+			}
+			before = n
+		}
+		limit := 50
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 500 {
+				http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		lines, err := store.Statement(id, before, limit)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		have, err := store.Balance(id)
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"user_id": id,
+			"balance": have,
+			"lines":   lines,
+		})
+	}
+}
+
+// CheckHandler handles GET /admin/wallet/check and runs the ledger consistency check
+func CheckHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := store.Check()
+		if err != nil {
+			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
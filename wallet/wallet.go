@@ -0,0 +1,336 @@
+// Package wallet keeps coin balances in a double-entry ledger.
+//
+// Every transaction moves coins from one account to another and is stored as
+// a balanced pair of entries: a debit on the source and a credit on the
+// destination, so the entries of the whole ledger always sum to zero. Top-ups
+// are minted from the mint account, spends go to the revenue account and
+// refunds move coins back from revenue. Each transaction carries an
+// idempotency key; posting the same key twice returns the first transaction
+// instead of moving coins again.
+package wallet
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transaction kinds
+const (
+	KindTopUp  = "topup"
+	KindSpend  = "spend"
+	KindRefund = "refund"
+)
+
+// System accounts; user accounts are named by UserAccount
+const (
+	AccountMint    = "system:mint"
+	AccountRevenue = "system:revenue"
+)
+
+var (
+	// ErrNotFound is returned when a transaction does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrInsufficientFunds is returned when a spend exceeds the balance
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrKeyReused is returned when an idempotency key was used for a different transaction
+	ErrKeyReused = errors.New("idempotency key already used for a different transaction")
+	// ErrNotRefundable is returned when a refund names a transaction that is not a spend
+	// or asks for more than is left of it
+	ErrNotRefundable = errors.New("transaction cannot be refunded by that amount")
+)
+
+// UserAccount returns the ledger account of a user
+func UserAccount(userID string) string {
+	return "user:" + userID
+}
+
+// Transaction is one movement of coins. Amount is always positive; for a
+// refund, RefundOf is the spend it reverses.
+type Transaction struct {
+	ID             int64  `json:"id"`
+	Kind           string `json:"kind"`
+	UserID         string `json:"user_id"`
+	Amount         int64  `json:"amount"`
+	IdempotencyKey string `json:"idempotency_key"`
+	Reference      string `json:"reference,omitempty"`
+	RefundOf       int64  `json:"refund_of,omitempty"`
+	Actor          string `json:"actor,omitempty"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// Line is one transaction as seen from a user's account: Amount is negative
+// for coins leaving it and Balance is the balance right after it
+type Line struct {
+	TransactionID int64  `json:"transaction_id"`
+	Kind          string `json:"kind"`
+	Amount        int64  `json:"amount"`
+	Balance       int64  `json:"balance"`
+	Reference     string `json:"reference,omitempty"`
+	RefundOf      int64  `json:"refund_of,omitempty"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// Report is the result of a ledger consistency check
+type Report struct {
+	Consistent   bool  `json:"consistent"`
+	Transactions int   `json:"transactions"`
+	Entries      int   `json:"entries"`
+	Sum          int64 `json:"sum"`
+	// Unbalanced lists transactions whose entries are not one balanced pair
+	Unbalanced []int64 `json:"unbalanced"`
+	// Overdrawn lists user accounts with a negative balance
+	Overdrawn []string `json:"overdrawn"`
+}
+
+// Store is the storage contract for the ledger
+type Store interface {
+	// Post stores t with its pair of entries and returns it with its ID. When
+	// t.IdempotencyKey was already posted with the same details, the earlier
+	// transaction is returned with replayed set and nothing moves. A refund
+	// takes its user from the refunded spend and, without an amount, refunds
+	// what is left of it.
+	Post(t Transaction) (saved Transaction, replayed bool, err error)
+	// Transaction returns one transaction
+	Transaction(id int64) (Transaction, error)
+	// Balance returns the balance of a user
+	Balance(userID string) (int64, error)
+	// Statement returns up to limit lines of a user's account, newest first,
+	// that are older than transaction before; before 0 starts at the newest
+	Statement(userID string, before int64, limit int) ([]Line, error)
+	// Check verifies that the ledger sums to zero, every transaction is one
+	// balanced pair of entries and no user is overdrawn
+	Check() (Report, error)
+}
+
+// InitWalletTables creates the wallet_transaction and wallet_entry tables
+func InitWalletTables(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS wallet_transaction (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        kind TEXT NOT NULL,
+        user_id TEXT NOT NULL,
+        amount INTEGER NOT NULL,
+        idempotency_key TEXT NOT NULL UNIQUE,
+        reference TEXT NOT NULL DEFAULT '',
+        refund_of INTEGER NOT NULL DEFAULT 0,
+        actor TEXT NOT NULL DEFAULT '',
+        created_at TEXT NOT NULL
+    );`,
+		`CREATE INDEX IF NOT EXISTS wallet_transaction_refund_of ON wallet_transaction (refund_of);`,
+		`CREATE TABLE IF NOT EXISTS wallet_entry (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        transaction_id INTEGER NOT NULL,
+        account TEXT NOT NULL,
+        amount INTEGER NOT NULL
+    );`,
+		`CREATE INDEX IF NOT EXISTS wallet_entry_account ON wallet_entry (account);`,
+		`CREATE INDEX IF NOT EXISTS wallet_entry_transaction ON wallet_entry (transaction_id);`,
+	}
+	for _, q := range stmts {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SQLiteStore implements Store on top of the wallet tables
+type SQLiteStore struct {
+	db *sql.DB
+	// mu serialises posts so a balance check and the spend it allows are one step
+	mu sync.Mutex
+}
+
+// NewSQLiteStore wraps an open database handle
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{db: db}
+}
+
+// querier is what both *sql.DB and *sql.Tx offer
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+const selectTransaction = `SELECT id, kind, user_id, amount, idempotency_key, reference, refund_of, actor, created_at FROM wallet_transaction`
+
+func getTransaction(q querier, where string, arg any) (Transaction, error) {
+	var t Transaction
+	err := q.QueryRow(selectTransaction+` WHERE `+where, arg).Scan(&t.ID, &t.Kind, &t.UserID, &t.Amount, &t.IdempotencyKey, &t.Reference, &t.RefundOf, &t.Actor, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return t, ErrNotFound
+	}
+	return t, err
+}
+
+func balance(q querier, account string) (int64, error) {
+	var n int64
+	err := q.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM wallet_entry WHERE account=?`, account).Scan(&n)
+	return n, err
+}
+
+// sameRequest reports whether t asks for what the already posted prev did
+func sameRequest(prev, t Transaction) bool {
+	if prev.Kind != t.Kind || prev.RefundOf != t.RefundOf {
+		return false
+	}
+	if t.UserID != "" && t.UserID != prev.UserID {
+		return false
+	}
+	// a refund without an amount means whatever was left at the time
+	return (t.Amount == 0 && t.Kind == KindRefund) || t.Amount == prev.Amount
+}
+
+// Post moves the coins of t inside one database transaction
+func (s *SQLiteStore) Post(t Transaction) (Transaction, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return t, false, err
+	}
+	defer tx.Rollback()
+
+	prev, err := getTransaction(tx, "idempotency_key=?", t.IdempotencyKey)
+	if err == nil {
+		if !sameRequest(prev, t) {
+			return prev, false, ErrKeyReused
+		}
+		return prev, true, nil
+	}
+	if err != ErrNotFound {
+		return t, false, err
+	}
+
+	if t.Amount < 0 || t.Amount == 0 && t.Kind != KindRefund {
+		return t, false, errors.New("amount must be positive")
+	}
+	var from, to string
+	switch t.Kind {
+	case KindTopUp:
+		from, to = AccountMint, UserAccount(t.UserID)
+	case KindSpend:
+		from, to = UserAccount(t.UserID), AccountRevenue
+		have, err := balance(tx, from)
+		if err != nil {
+			return t, false, err
+		}
+		if have < t.Amount {
+			return t, false, ErrInsufficientFunds
+		}
+	case KindRefund:
+		spend, err := getTransaction(tx, "id=?", t.RefundOf)
+		if err != nil {
+			return t, false, err
+		}
+		if spend.Kind != KindSpend {
+			return t, false, ErrNotRefundable
+		}
+		var refunded int64
+		if err := tx.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM wallet_transaction WHERE kind=? AND refund_of=?`, KindRefund, spend.ID).Scan(&refunded); err != nil {
+			return t, false, err
+		}
+		if t.Amount == 0 {
+			t.Amount = spend.Amount - refunded
+		}
+		if t.Amount <= 0 || refunded+t.Amount > spend.Amount {
+			return t, false, ErrNotRefundable
+		}
+		t.UserID = spend.UserID
+		from, to = AccountRevenue, UserAccount(spend.UserID)
+	default:
+		return t, false, errors.New("unknown transaction kind " + t.Kind)
+	}
+
+	t.CreatedAt = time.Now().Format(time.RFC3339)
+	res, err := tx.Exec(`INSERT INTO wallet_transaction (kind, user_id, amount, idempotency_key, reference, refund_of, actor, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.Kind, t.UserID, t.Amount, t.IdempotencyKey, t.Reference, t.RefundOf, t.Actor, t.CreatedAt)
+	if err != nil {
+		return t, false, err
+	}
+	if t.ID, err = res.LastInsertId(); err != nil {
+		return t, false, err
+	}
+	if _, err := tx.Exec(`INSERT INTO wallet_entry (transaction_id, account, amount) VALUES (?, ?, ?), (?, ?, ?)`,
+		t.ID, from, -t.Amount, t.ID, to, t.Amount); err != nil {
+		return t, false, err
+	}
+	return t, false, tx.Commit()
+}
+
+// Transaction looks up a transaction by id
+func (s *SQLiteStore) Transaction(id int64) (Transaction, error) {
+	return getTransaction(s.db, "id=?", id)
+}
+
+// Balance sums the entries of the user's account
+func (s *SQLiteStore) Balance(userID string) (int64, error) {
+	return balance(s.db, UserAccount(userID))
+}
+
+// Statement computes the running balance over the whole account and pages it
+func (s *SQLiteStore) Statement(userID string, before int64, limit int) ([]Line, error) {
+	rows, err := s.db.Query(`SELECT id, kind, amount, balance, reference, refund_of, created_at FROM (
+		SELECT t.id, t.kind, e.amount, SUM(e.amount) OVER (ORDER BY e.id) AS balance, t.reference, t.refund_of, t.created_at
+		FROM wallet_entry e JOIN wallet_transaction t ON t.id = e.transaction_id WHERE e.account=?)
+		WHERE ?=0 OR id < ? ORDER BY id DESC LIMIT ?`, UserAccount(userID), before, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Line{}
+	for rows.Next() {
+		var l Line
+		if err := rows.Scan(&l.TransactionID, &l.Kind, &l.Amount, &l.Balance, &l.Reference, &l.RefundOf, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// Check runs the consistency queries over the whole ledger
+func (s *SQLiteStore) Check() (Report, error) {
+	r := Report{Unbalanced: []int64{}, Overdrawn: []string{}}
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM wallet_transaction`).Scan(&r.Transactions); err != nil {
+		return r, err
+	}
+	if err := s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM wallet_entry`).Scan(&r.Entries, &r.Sum); err != nil {
+		return r, err
+	}
+	rows, err := s.db.Query(`SELECT t.id FROM wallet_transaction t LEFT JOIN wallet_entry e ON e.transaction_id = t.id
+		GROUP BY t.id HAVING COUNT(e.id) != 2 OR COALESCE(SUM(e.amount), 0) != 0 OR MAX(e.amount) != t.amount
+		UNION SELECT DISTINCT transaction_id FROM wallet_entry WHERE transaction_id NOT IN (SELECT id FROM wallet_transaction)
+		ORDER BY 1`)
+	if err != nil {
+		return r, err
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return r, err
+		}
+		r.Unbalanced = append(r.Unbalanced, id)
+	}
+	rows.Close()
+	rows, err = s.db.Query(`SELECT account FROM wallet_entry WHERE account LIKE 'user:%' GROUP BY account HAVING SUM(amount) < 0 ORDER BY account`)
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var account string
+		if err := rows.Scan(&account); err != nil {
+			return r, err
+		}
+		r.Overdrawn = append(r.Overdrawn, strings.TrimPrefix(account, "user:"))
+	}
+	if err := rows.Err(); err != nil {
+		return r, err
+	}
+	r.Consistent = r.Sum == 0 && len(r.Unbalanced) == 0 && len(r.Overdrawn) == 0
+	return r, nil
+}
@@ -0,0 +1,87 @@
+package wallet_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gosse/storage"
+	"gosse/user"
+	"gosse/wallet"
+)
+
+func TestLedger(t *testing.T) {
+	db, err := storage.OpenMemoryWithFixtures("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := wallet.NewSQLiteStore(db)
+
+	post := func(h http.HandlerFunc, body string, want int) map[string]any {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/admin/wallet", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Fatalf("%s: status = %d, want %d: %s", body, rec.Code, want, rec.Body)
+		}
+		var resp map[string]any
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp
+	}
+	topup := wallet.TopUpHandler(store, user.NewSQLiteRepository(db))
+	spend := wallet.SpendHandler(store)
+	refund := wallet.RefundHandler(store)
+
+	post(topup, `{"user_id": "u1", "amount": 100}`, http.StatusBadRequest)
+	post(topup, `{"user_id": "nobody", "amount": 100, "idempotency_key": "t0"}`, http.StatusNotFound)
+	post(topup, `{"user_id": "u1", "amount": 100, "idempotency_key": "t1"}`, http.StatusOK)
+	if resp := post(topup, `{"user_id": "u1", "amount": 100, "idempotency_key": "t1"}`, http.StatusOK); resp["status"] != "replayed" || resp["balance"] != 100.0 {
+		t.Errorf("replayed top-up = %v", resp)
+	}
+	post(topup, `{"user_id": "u1", "amount": 50, "idempotency_key": "t1"}`, http.StatusConflict)
+
+	resp := post(spend, `{"user_id": "u1", "amount": 30, "idempotency_key": "s1", "reference": "gift:rose"}`, http.StatusOK)
+	spendID := int64(resp["transaction"].(map[string]any)["id"].(float64))
+	post(spend, `{"user_id": "u1", "amount": 30, "idempotency_key": "s1", "reference": "gift:rose"}`, http.StatusOK)
+	if resp := post(spend, `{"user_id": "u1", "amount": 71, "idempotency_key": "s2"}`, http.StatusPaymentRequired); resp["balance"] != 70.0 {
+		t.Errorf("overspend = %v", resp)
+	}
+
+	body := func(key string, amount int) string {
+		b, _ := json.Marshal(map[string]any{"transaction_id": spendID, "amount": amount, "idempotency_key": key})
+		return string(b)
+	}
+	post(refund, body("r1", 10), http.StatusOK)
+	post(refund, body("r2", 25), http.StatusConflict)
+	if resp := post(refund, body("r3", 0), http.StatusOK); resp["balance"] != 100.0 {
+		t.Errorf("full refund = %v", resp)
+	}
+	post(refund, body("r4", 0), http.StatusConflict)
+	post(refund, `{"transaction_id": 1, "idempotency_key": "r5"}`, http.StatusConflict)
+	post(refund, `{"transaction_id": 99, "idempotency_key": "r6"}`, http.StatusNotFound)
+
+	lines, err := store.Statement("u1", 0, 10)
+	if err != nil || len(lines) != 4 {
+		t.Fatalf("statement = %+v, %v", lines, err)
+	}
+	if lines[0].Balance != 100 || lines[2].Amount != -30 || lines[2].Balance != 70 || lines[3].Balance != 100 {
+		t.Errorf("statement = %+v", lines)
+	}
+	if older, _ := store.Statement("u1", lines[1].TransactionID, 10); len(older) != 2 || older[0] != lines[2] {
+		t.Errorf("second page = %+v", older)
+	}
+
+	report, err := store.Check()
+	if err != nil || !report.Consistent || report.Transactions != 4 || report.Entries != 8 {
+		t.Errorf("check = %+v, %v", report, err)
+	}
+	// a lone entry breaks both the total and its transaction
+	db.Exec(`INSERT INTO wallet_entry (transaction_id, account, amount) VALUES (?, ?, ?)`, spendID, wallet.UserAccount("u2"), -5)
+	report, _ = store.Check()
+	if report.Consistent || report.Sum != -5 || len(report.Unbalanced) != 1 || report.Unbalanced[0] != spendID || len(report.Overdrawn) != 1 {
+		t.Errorf("check after corruption = %+v", report)
+	}
+}
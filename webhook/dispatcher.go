@@ -0,0 +1,249 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Gosse-Event"
+	HeaderDelivery  = "X-Gosse-Delivery"
+	HeaderTimestamp = "X-Gosse-Timestamp"
+	HeaderSignature = "X-Gosse-Signature"
+)
+
+// Envelope is the JSON body posted to endpoints
+type Envelope struct {
+	ID        int             `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt string          `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Sign returns the signature header value for body sent at timestamp:
+// "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with secret
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature headers of a received delivery; receivers should
+// also reject timestamps that are too old to prevent replays
+func Verify(secret string, h http.Header, body []byte) bool {
+	want := Sign(secret, h.Get(HeaderTimestamp), body)
+	return hmac.Equal([]byte(want), []byte(h.Get(HeaderSignature)))
+}
+
+// Dispatcher queues events for subscribed endpoints and delivers them in the background
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+
+	// MaxAttempts is how often a delivery is tried before it is dead-lettered
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry; it doubles after every failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// PollInterval is how often the queue is checked for due retries
+	PollInterval time.Duration
+	// Now is the clock; tests replace it to step through retries
+	Now func() time.Time
+
+	wake chan struct{}
+}
+
+// NewDispatcher builds a Dispatcher with a 10 second request timeout, 8 attempts,
+// and backoff from 30 seconds up to one hour
+func NewDispatcher(store Store) *Dispatcher {
+	return &Dispatcher{
+		store:        store,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts:  8,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     time.Hour,
+		PollInterval: 5 * time.Second,
+		Now:          time.Now,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Store returns the store the dispatcher works on
+func (d *Dispatcher) Store() Store {
+	return d.store
+}
+
+// Emit queues data as event for every active endpoint subscribed to it.
+// Delivery happens asynchronously; errors are logged and never reach the caller's request.
+// It is safe to call on a nil Dispatcher.
+func (d *Dispatcher) Emit(event string, data any) {
+	if d == nil {
+		return
+	}
+	if _, err := d.Enqueue(event, data); err != nil {
+		log.Printf("webhook: queue %s: %v", event, err)
+	}
+}
+
+// Enqueue stores one pending delivery per subscribed endpoint and wakes the sender
+func (d *Dispatcher) Enqueue(event string, data any) ([]Delivery, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := d.store.Endpoints()
+	if err != nil {
+		return nil, err
+	}
+	now := d.Now()
+	var queued []Delivery
+	for _, e := range endpoints {
+		if !e.Active || !e.Subscribes(event) {
+			continue
+		}
+		q, err := d.store.Enqueue(Delivery{
+			EndpointID:  e.ID,
+			Event:       event,
+			Payload:     string(raw),
+			NextAttempt: now.Format(time.RFC3339),
+			CreatedAt:   now.Format(time.RFC3339),
+		})
+		if err != nil {
+			return queued, err
+		}
+		queued = append(queued, q)
+	}
+	if len(queued) > 0 {
+		d.Wake()
+	}
+	return queued, nil
+}
+
+// Wake makes the background sender check the queue now
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the sender until stop is closed; a nil stop runs forever
+func (d *Dispatcher) Start(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		d.DeliverDue()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DeliverDue sends every delivery whose next attempt has come and returns how many were tried
+func (d *Dispatcher) DeliverDue() int {
+	tried := map[int]bool{}
+	for {
+		due, err := d.store.Due(d.Now(), 50)
+		if err != nil {
+			log.Printf("webhook: load due deliveries: %v", err)
+			return len(tried)
+		}
+		progress := false
+		for _, del := range due {
+			// A delivery that is due again within one pass is retried on the next one
+			if tried[del.ID] {
+				continue
+			}
+			tried[del.ID] = true
+			progress = true
+			d.attempt(del)
+		}
+		if !progress {
+			return len(tried)
+		}
+	}
+}
+
+// backoff returns the wait after the given number of failed attempts
+func (d *Dispatcher) backoff(failures int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < failures && delay < d.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxDelay {
+		delay = d.MaxDelay
+	}
+	return delay
+}
+
+// attempt sends one delivery and records the outcome
+func (d *Dispatcher) attempt(del Delivery) {
+	start := d.Now()
+	del.Attempts++
+	a := Attempt{DeliveryID: del.ID, Attempt: del.Attempts, CreatedAt: start.Format(time.RFC3339)}
+
+	e, err := d.store.Endpoint(del.EndpointID)
+	if err == nil {
+		a.ResponseCode, err = d.send(e, del, start)
+	}
+	a.DurationMS = d.Now().Sub(start).Milliseconds()
+	del.ResponseCode = a.ResponseCode
+	switch {
+	case err == nil:
+		del.Status, del.LastError, del.NextAttempt = StatusDelivered, "", ""
+		del.DeliveredAt = d.Now().Format(time.RFC3339)
+	case err == ErrNotFound || del.Attempts >= d.MaxAttempts:
+		a.Error, del.LastError = err.Error(), err.Error()
+		del.Status, del.NextAttempt = StatusDead, ""
+	default:
+		a.Error, del.LastError = err.Error(), err.Error()
+		del.NextAttempt = start.Add(d.backoff(del.Attempts)).Format(time.RFC3339)
+	}
+	if err := d.store.Record(del, a); err != nil {
+		log.Printf("webhook: record delivery %d: %v", del.ID, err)
+	}
+}
+
+// send posts the signed envelope; any non-2xx response is an error
+func (d *Dispatcher) send(e Endpoint, del Delivery, now time.Time) (int, error) {
+	body, err := json.Marshal(Envelope{ID: del.ID, Event: del.Event, CreatedAt: del.CreatedAt, Data: json.RawMessage(del.Payload)})
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gosse-webhook/1")
+	req.Header.Set(HeaderEvent, del.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(del.ID))
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderSignature, Sign(e.Secret, ts, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,208 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"gosse/audit"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// EndpointsHandler handles GET /admin/webhooks and lists the registered endpoints without their secrets
+func EndpointsHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all, err := store.Endpoints()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(all)
+	}
+}
+
+// CreateEndpointHandler handles POST /admin/webhooks/create with
+// {"url": "...", "events": ["twod.final", ...], "secret": "..."}.
+// A secret is generated when none is given; it is only ever returned here.
+func CreateEndpointHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+			Secret string   `json:"secret"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		u, err := url.Parse(strings.TrimSpace(req.URL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "url must be an absolute http or https URL", http.StatusBadRequest)
+			return
+		}
+		if len(req.Events) == 0 {
+			http.Error(w, "events are required, one or more of "+strings.Join(Events, ", "), http.StatusBadRequest)
+			return
+		}
+		for _, ev := range req.Events {
+			if !knownEvent(ev) {
+				http.Error(w, "unknown event "+ev+", use "+strings.Join(Events, ", "), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Secret == "" {
+			buf := make([]byte, 24)
+			if _, err := rand.Read(buf); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			req.Secret = hex.EncodeToString(buf)
+		}
+		e, err := store.CreateEndpoint(Endpoint{URL: u.String(), Secret: req.Secret, Events: req.Events, Active: true})
+		if err != nil {
+			http.Error(w, "Database insert error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit.SetTarget(r, strconv.Itoa(e.ID))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "created", "data": e})
+	}
+}
+
+// DeleteEndpointHandler handles POST or DELETE /admin/webhooks/delete?id=... and
+// drops the endpoint's pending deliveries
+func DeleteEndpointHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, ok := idParam(w, r)
+		if !ok {
+			return
+		}
+		audit.SetTarget(r, strconv.Itoa(id))
+		if err := store.DeleteEndpoint(id); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "deleted", "id": id})
+	}
+}
+
+// DeliveriesHandler handles GET /admin/webhooks/deliveries with optional
+// endpoint_id, event, status (pending, delivered or dead for the dead-letter
+// list), limit and offset filters
+func DeliveriesHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		f := DeliveryFilter{Event: q.Get("event"), Status: q.Get("status")}
+		for name, dst := range map[string]*int{"endpoint_id": &f.EndpointID, "limit": &f.Limit, "offset": &f.Offset} {
+			if v := q.Get(name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					http.Error(w, "Invalid "+name+" parameter", http.StatusBadRequest)
+					return
+				}
+				*dst = n
+			}
+		}
+		all, err := store.Deliveries(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(all)
+	}
+}
+
+// DeliveryHandler handles GET /admin/webhooks/delivery?id=... and returns the
+// delivery together with its log of attempts
+func DeliveryHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := idParam(w, r)
+		if !ok {
+			return
+		}
+		d, err := store.Delivery(id)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		attempts, err := store.Attempts(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"delivery": d, "attempts": attempts})
+	}
+}
+
+// RedeliverHandler handles POST /admin/webhooks/redeliver?id=... and queues a
+// dead-lettered or already delivered delivery again
+func RedeliverHandler(d *Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, ok := idParam(w, r)
+		if !ok {
+			return
+		}
+		audit.SetTarget(r, strconv.Itoa(id))
+		del, err := d.store.Delivery(id)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if del.Status == StatusPending {
+			http.Error(w, "delivery is still pending", http.StatusConflict)
+			return
+		}
+		if err := d.store.Requeue(id, d.Now()); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		d.Wake()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "queued", "id": id})
+	}
+}
+
+func knownEvent(ev string) bool {
+	for _, e := range Events {
+		if e == ev {
+			return true
+		}
+	}
+	return false
+}
+
+func idParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Missing or invalid id parameter", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if err == ErrNotFound {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
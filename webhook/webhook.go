@@ -0,0 +1,417 @@
+// Package webhook delivers signed event notifications to registered HTTP endpoints.
+//
+// Every event is stored as one delivery per subscribed endpoint before it is
+// sent, so deliveries survive restarts. Failed deliveries are retried with
+// exponential backoff; after the last attempt they are moved to the dead-letter
+// list, from where an admin can requeue them. Each attempt is kept in the
+// delivery log.
+package webhook
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Events an endpoint can subscribe to
+const (
+	EventTwodFinal    = "twod.final"
+	EventThreedResult = "threed.result"
+	EventLottoResult  = "lotto.result"
+	EventChatBan      = "chat.ban"
+	EventChatReport   = "chat.report"
+)
+
+// Events lists every event name in the order they are documented
+var Events = []string{EventTwodFinal, EventThreedResult, EventLottoResult, EventChatBan, EventChatReport}
+
+// Delivery states
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// ErrNotFound is returned when an endpoint or delivery does not exist
+var ErrNotFound = errors.New("not found")
+
+// Endpoint is a registered receiver. Secret signs the payloads and is only
+// returned when the endpoint is created.
+type Endpoint struct {
+	ID        int      `json:"id"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// Subscribes reports whether e receives event
+func (e Endpoint) Subscribes(event string) bool {
+	for _, ev := range e.Events {
+		if ev == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is one event queued for one endpoint
+type Delivery struct {
+	ID           int    `json:"id"`
+	EndpointID   int    `json:"endpoint_id"`
+	Event        string `json:"event"`
+	Payload      string `json:"payload"`
+	Status       string `json:"status"`
+	Attempts     int    `json:"attempts"`
+	ResponseCode int    `json:"response_code,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+	NextAttempt  string `json:"next_attempt_at,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	DeliveredAt  string `json:"delivered_at,omitempty"`
+}
+
+// Attempt is one entry of the delivery log
+type Attempt struct {
+	ID           int    `json:"id"`
+	DeliveryID   int    `json:"delivery_id"`
+	Attempt      int    `json:"attempt"`
+	ResponseCode int    `json:"response_code,omitempty"`
+	Error        string `json:"error,omitempty"`
+	DurationMS   int64  `json:"duration_ms"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// DeliveryFilter narrows Deliveries; empty fields match everything
+type DeliveryFilter struct {
+	EndpointID int
+	Event      string
+	Status     string
+	Limit      int
+	Offset     int
+}
+
+// Store is the storage contract for endpoints, deliveries and the delivery log
+type Store interface {
+	// CreateEndpoint registers e and returns it with its ID
+	CreateEndpoint(e Endpoint) (Endpoint, error)
+	// Endpoints returns every registered endpoint without its secret
+	Endpoints() ([]Endpoint, error)
+	// Endpoint returns one endpoint including its secret
+	Endpoint(id int) (Endpoint, error)
+	// DeleteEndpoint removes an endpoint and its pending deliveries
+	DeleteEndpoint(id int) error
+	// Enqueue stores a pending delivery due at d.NextAttempt
+	Enqueue(d Delivery) (Delivery, error)
+	// Due returns up to limit pending deliveries whose next attempt is not after now
+	Due(now time.Time, limit int) ([]Delivery, error)
+	// Record logs an attempt and stores the new state of the delivery
+	Record(d Delivery, a Attempt) error
+	// Delivery returns one delivery
+	Delivery(id int) (Delivery, error)
+	// Deliveries returns matching deliveries, newest first
+	Deliveries(f DeliveryFilter) ([]Delivery, error)
+	// Attempts returns the delivery log of one delivery, oldest first
+	Attempts(deliveryID int) ([]Attempt, error)
+	// Requeue makes a dead or delivered delivery pending again, due at now
+	Requeue(id int, now time.Time) error
+}
+
+// InitWebhookTables creates the webhook_endpoint, webhook_delivery and webhook_attempt tables
+func InitWebhookTables(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS webhook_endpoint (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        url TEXT NOT NULL,
+        secret TEXT NOT NULL,
+        events TEXT NOT NULL,
+        active INTEGER NOT NULL DEFAULT 1,
+        created_at TEXT NOT NULL
+    );`,
+		`CREATE TABLE IF NOT EXISTS webhook_delivery (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        endpoint_id INTEGER NOT NULL,
+        event TEXT NOT NULL,
+        payload TEXT NOT NULL,
+        status TEXT NOT NULL,
+        attempts INTEGER NOT NULL DEFAULT 0,
+        response_code INTEGER,
+        last_error TEXT,
+        next_attempt_at INTEGER,
+        created_at TEXT NOT NULL,
+        delivered_at TEXT
+    );`,
+		`CREATE INDEX IF NOT EXISTS webhook_delivery_due ON webhook_delivery (status, next_attempt_at);`,
+		`CREATE TABLE IF NOT EXISTS webhook_attempt (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        delivery_id INTEGER NOT NULL,
+        attempt INTEGER NOT NULL,
+        response_code INTEGER,
+        error TEXT,
+        duration_ms INTEGER,
+        created_at TEXT NOT NULL
+    );`,
+		`CREATE INDEX IF NOT EXISTS webhook_attempt_delivery ON webhook_attempt (delivery_id);`,
+	}
+	for _, q := range stmts {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SQLiteStore implements Store on top of the webhook tables
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore wraps an open database handle
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{db: db}
+}
+
+// CreateEndpoint inserts e, stamping the creation time
+func (s *SQLiteStore) CreateEndpoint(e Endpoint) (Endpoint, error) {
+	e.CreatedAt = time.Now().Format(time.RFC3339)
+	res, err := s.db.Exec(`INSERT INTO webhook_endpoint (url, secret, events, active, created_at) VALUES (?, ?, ?, ?, ?)`,
+		e.URL, e.Secret, strings.Join(e.Events, ","), e.Active, e.CreatedAt)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	id, err := res.LastInsertId()
+	e.ID = int(id)
+	return e, err
+}
+
+func scanEndpoints(rows *sql.Rows, withSecret bool) ([]Endpoint, error) {
+	defer rows.Close()
+	all := []Endpoint{}
+	for rows.Next() {
+		var e Endpoint
+		var events string
+		if err := rows.Scan(&e.ID, &e.URL, &e.Secret, &events, &e.Active, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if events != "" {
+			e.Events = strings.Split(events, ",")
+		}
+		if !withSecret {
+			e.Secret = ""
+		}
+		all = append(all, e)
+	}
+	return all, rows.Err()
+}
+
+const selectEndpoint = `SELECT id, url, secret, events, active, created_at FROM webhook_endpoint`
+
+// Endpoints returns all endpoints ordered by id
+func (s *SQLiteStore) Endpoints() ([]Endpoint, error) {
+	rows, err := s.db.Query(selectEndpoint + ` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanEndpoints(rows, false)
+}
+
+// Endpoint returns the endpoint with the given id
+func (s *SQLiteStore) Endpoint(id int) (Endpoint, error) {
+	rows, err := s.db.Query(selectEndpoint+` WHERE id=?`, id)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	all, err := scanEndpoints(rows, true)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	if len(all) == 0 {
+		return Endpoint{}, ErrNotFound
+	}
+	return all[0], nil
+}
+
+// DeleteEndpoint removes the endpoint and drops its pending deliveries; the
+// delivery log of finished deliveries is kept
+func (s *SQLiteStore) DeleteEndpoint(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM webhook_endpoint WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec(`DELETE FROM webhook_delivery WHERE endpoint_id=? AND status=?`, id, StatusPending); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Enqueue inserts d as a pending delivery
+func (s *SQLiteStore) Enqueue(d Delivery) (Delivery, error) {
+	d.Status = StatusPending
+	if d.CreatedAt == "" {
+		d.CreatedAt = time.Now().Format(time.RFC3339)
+	}
+	next, err := unix(d.NextAttempt)
+	if err != nil {
+		return Delivery{}, err
+	}
+	res, err := s.db.Exec(`INSERT INTO webhook_delivery (endpoint_id, event, payload, status, attempts, next_attempt_at, created_at) VALUES (?, ?, ?, ?, 0, ?, ?)`,
+		d.EndpointID, d.Event, d.Payload, d.Status, next, d.CreatedAt)
+	if err != nil {
+		return Delivery{}, err
+	}
+	id, err := res.LastInsertId()
+	d.ID = int(id)
+	return d, err
+}
+
+const selectDelivery = `SELECT id, endpoint_id, event, payload, status, attempts, response_code, last_error, next_attempt_at, created_at, delivered_at FROM webhook_delivery`
+
+func (s *SQLiteStore) queryDeliveries(query string, args ...any) ([]Delivery, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var code, next sql.NullInt64
+		var lastErr, delivered sql.NullString
+		if err := rows.Scan(&d.ID, &d.EndpointID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &code, &lastErr, &next, &d.CreatedAt, &delivered); err != nil {
+			return nil, err
+		}
+		d.ResponseCode, d.LastError, d.DeliveredAt = int(code.Int64), lastErr.String, delivered.String
+		if next.Valid && d.Status == StatusPending {
+			d.NextAttempt = time.Unix(next.Int64, 0).Format(time.RFC3339)
+		}
+		all = append(all, d)
+	}
+	return all, rows.Err()
+}
+
+// Due returns pending deliveries whose next attempt has come, oldest first
+func (s *SQLiteStore) Due(now time.Time, limit int) ([]Delivery, error) {
+	return s.queryDeliveries(selectDelivery+` WHERE status=? AND next_attempt_at<=? ORDER BY next_attempt_at, id LIMIT ?`,
+		StatusPending, now.Unix(), limit)
+}
+
+// Record appends a to the delivery log and updates d in one transaction
+func (s *SQLiteStore) Record(d Delivery, a Attempt) error {
+	next, err := unix(d.NextAttempt)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO webhook_attempt (delivery_id, attempt, response_code, error, duration_ms, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		d.ID, a.Attempt, a.ResponseCode, a.Error, a.DurationMS, a.CreatedAt); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE webhook_delivery SET status=?, attempts=?, response_code=?, last_error=?, next_attempt_at=?, delivered_at=? WHERE id=?`,
+		d.Status, d.Attempts, d.ResponseCode, d.LastError, next, d.DeliveredAt, d.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delivery returns the delivery with the given id
+func (s *SQLiteStore) Delivery(id int) (Delivery, error) {
+	all, err := s.queryDeliveries(selectDelivery+` WHERE id=?`, id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	if len(all) == 0 {
+		return Delivery{}, ErrNotFound
+	}
+	return all[0], nil
+}
+
+// Deliveries returns the deliveries matching f, newest first
+func (s *SQLiteStore) Deliveries(f DeliveryFilter) ([]Delivery, error) {
+	var where []string
+	var args []any
+	if f.EndpointID != 0 {
+		where = append(where, "endpoint_id=?")
+		args = append(args, f.EndpointID)
+	}
+	if f.Event != "" {
+		where = append(where, "event=?")
+		args = append(args, f.Event)
+	}
+	if f.Status != "" {
+		where = append(where, "status=?")
+		args = append(args, f.Status)
+	}
+	query := selectDelivery
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	limit := f.Limit
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	args = append(args, limit, f.Offset)
+	return s.queryDeliveries(query+" ORDER BY id DESC LIMIT ? OFFSET ?", args...)
+}
+
+// Attempts returns the logged attempts of a delivery
+func (s *SQLiteStore) Attempts(deliveryID int) ([]Attempt, error) {
+	rows, err := s.db.Query(`SELECT id, delivery_id, attempt, response_code, error, duration_ms, created_at FROM webhook_attempt WHERE delivery_id=? ORDER BY id`, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := []Attempt{}
+	for rows.Next() {
+		var a Attempt
+		var code, dur sql.NullInt64
+		var msg sql.NullString
+		if err := rows.Scan(&a.ID, &a.DeliveryID, &a.Attempt, &code, &msg, &dur, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.ResponseCode, a.Error, a.DurationMS = int(code.Int64), msg.String, dur.Int64
+		all = append(all, a)
+	}
+	return all, rows.Err()
+}
+
+// Requeue resets the attempt counter of a finished delivery and makes it due at now
+func (s *SQLiteStore) Requeue(id int, now time.Time) error {
+	res, err := s.db.Exec(`UPDATE webhook_delivery SET status=?, attempts=0, next_attempt_at=?, delivered_at=NULL WHERE id=? AND status!=?`,
+		StatusPending, now.Unix(), id, StatusPending)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// unix converts an RFC3339 time to Unix seconds; empty means no next attempt
+func unix(v string) (any, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, err
+	}
+	return t.Unix(), nil
+}
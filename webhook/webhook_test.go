@@ -0,0 +1,169 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gosse/storage"
+	"gosse/webhook"
+)
+
+// receiver is a local endpoint that fails the first failures requests
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	secret   string
+	bodies   []webhook.Envelope
+	badSig   int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if !webhook.Verify(rc.secret, r.Header, body) {
+		rc.badSig++
+	}
+	if rc.failures > 0 {
+		rc.failures--
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+		return
+	}
+	var env webhook.Envelope
+	json.Unmarshal(body, &env)
+	rc.bodies = append(rc.bodies, env)
+}
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time      { return c.t }
+func (c *clock) add(d time.Duration) { c.t = c.t.Add(d) }
+
+func setup(t *testing.T) (*webhook.Dispatcher, *webhook.SQLiteStore, *clock) {
+	t.Helper()
+	db, err := storage.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	store := webhook.NewSQLiteStore(db)
+	d := webhook.NewDispatcher(store)
+	c := &clock{t: time.Date(2025, 8, 15, 16, 30, 0, 0, time.UTC)}
+	d.Now = c.now
+	d.MaxAttempts = 3
+	d.BaseDelay = time.Minute
+	return d, store, c
+}
+
+func TestDeliveryRetriesWithBackoff(t *testing.T) {
+	d, store, c := setup(t)
+	rc := &receiver{failures: 2, secret: "s3cret"}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	if _, err := store.CreateEndpoint(webhook.Endpoint{URL: srv.URL, Secret: rc.secret, Events: []string{webhook.EventTwodFinal}, Active: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Emit(webhook.EventTwodFinal, map[string]string{"date": "2025-08-15", "eresult": "20"})
+	d.Emit(webhook.EventChatBan, map[string]string{"id": "u1"}) // not subscribed
+
+	if n := d.DeliverDue(); n != 1 {
+		t.Fatalf("first pass tried %d deliveries, want 1", n)
+	}
+	// the first retry waits BaseDelay, the second twice as long
+	c.add(59 * time.Second)
+	if n := d.DeliverDue(); n != 0 {
+		t.Fatalf("retried %d deliveries before the backoff elapsed", n)
+	}
+	c.add(time.Second)
+	d.DeliverDue()
+	c.add(2 * time.Minute)
+	d.DeliverDue()
+
+	if len(rc.bodies) != 1 || rc.badSig != 0 {
+		t.Fatalf("received %d bodies, %d bad signatures", len(rc.bodies), rc.badSig)
+	}
+	if env := rc.bodies[0]; env.Event != webhook.EventTwodFinal || !strings.Contains(string(env.Data), `"eresult":"20"`) {
+		t.Errorf("unexpected envelope %+v", env)
+	}
+	del, _ := store.Delivery(rc.bodies[0].ID)
+	attempts, _ := store.Attempts(del.ID)
+	if del.Status != webhook.StatusDelivered || del.Attempts != 3 || len(attempts) != 3 || attempts[0].ResponseCode != http.StatusServiceUnavailable {
+		t.Errorf("delivery %+v, log %+v", del, attempts)
+	}
+}
+
+func TestDeadLetterAndRedeliver(t *testing.T) {
+	d, store, c := setup(t)
+	rc := &receiver{failures: 3, secret: "k"}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	store.CreateEndpoint(webhook.Endpoint{URL: srv.URL, Secret: rc.secret, Events: []string{webhook.EventThreedResult}, Active: true})
+
+	d.Emit(webhook.EventThreedResult, map[string]string{"Date": "2025-08-16", "Result": "947"})
+	for i := 0; i < 3; i++ {
+		d.DeliverDue()
+		c.add(time.Hour)
+	}
+	dead, err := store.Deliveries(webhook.DeliveryFilter{Status: webhook.StatusDead})
+	if err != nil || len(dead) != 1 || dead[0].Attempts != 3 {
+		t.Fatalf("dead letters = %+v, %v", dead, err)
+	}
+
+	redeliver := webhook.RedeliverHandler(d)
+	rec := httptest.NewRecorder()
+	redeliver(rec, httptest.NewRequest(http.MethodPost, "/admin/webhooks/redeliver?id=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("redeliver status = %d", rec.Code)
+	}
+	d.DeliverDue()
+	if del, _ := store.Delivery(dead[0].ID); del.Status != webhook.StatusDelivered || len(rc.bodies) != 1 {
+		t.Errorf("after redeliver: %+v", del)
+	}
+	rec = httptest.NewRecorder()
+	redeliver(rec, httptest.NewRequest(http.MethodPost, "/admin/webhooks/redeliver?id=9", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown delivery: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestCreateEndpointHandler(t *testing.T) {
+	_, store, _ := setup(t)
+	h := webhook.CreateEndpointHandler(store)
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/admin/webhooks/create", strings.NewReader(body)))
+		return rec
+	}
+	rec := post(`{"url":"https://bot.example/hook","events":["twod.final","chat.report"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Data webhook.Endpoint `json:"data"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Data.Secret) != 48 {
+		t.Errorf("generated secret %q", resp.Data.Secret)
+	}
+	list, _ := store.Endpoints()
+	if len(list) != 1 || list[0].Secret != "" || !list[0].Subscribes(webhook.EventChatReport) {
+		t.Errorf("endpoints = %+v", list)
+	}
+
+	for _, body := range []string{
+		`{"url":"ftp://bot.example","events":["twod.final"]}`,
+		`{"url":"https://bot.example/hook","events":[]}`,
+		`{"url":"https://bot.example/hook","events":["twod.live"]}`,
+	} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}